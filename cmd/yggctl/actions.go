@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"text/tabwriter"
 	"text/template"
 
@@ -248,6 +249,205 @@ func dispatchAction(c *cli.Context) error {
 	return nil
 }
 
+func deadLettersListAction(c *cli.Context) error {
+	conn, err := connectBus()
+	if err != nil {
+		return cli.Exit(fmt.Errorf("cannot connect to bus: %w", err), 1)
+	}
+
+	obj := conn.Object("com.redhat.Yggdrasil1", "/com/redhat/Yggdrasil1")
+	var entries []map[string]string
+	call := obj.Call("com.redhat.Yggdrasil1.DeadLetters", dbus.Flags(0))
+	if err := call.Store(&entries); err != nil {
+		return cli.Exit(fmt.Errorf("cannot list dead-letter messages: %v", err), 1)
+	}
+
+	switch c.String("format") {
+	case "json":
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("cannot marshal dead-letter messages: %v", err), 1)
+		}
+		fmt.Println(string(data))
+	case "table":
+		writer := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		fmt.Fprint(writer, "ID\tMESSAGE ID\tDIRECTIVE\tFAILED\tATTEMPTS\tERROR\n")
+		for _, entry := range entries {
+			fmt.Fprintf(
+				writer,
+				"%s\t%s\t%s\t%s\t%s\t%s\n",
+				entry["id"],
+				entry["message_id"],
+				entry["directive"],
+				entry["failed"],
+				entry["attempts"],
+				entry["error"],
+			)
+		}
+		if err := writer.Flush(); err != nil {
+			return cli.Exit(fmt.Errorf("unable to flush tab writer: %v", err), 1)
+		}
+	default:
+		return cli.Exit(fmt.Errorf("unknown format type: %v", c.String("format")), 1)
+	}
+
+	return nil
+}
+
+func deadLettersInspectAction(c *cli.Context) error {
+	id, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("cannot parse ID: %w", err), 1)
+	}
+
+	conn, err := connectBus()
+	if err != nil {
+		return cli.Exit(fmt.Errorf("cannot connect to bus: %w", err), 1)
+	}
+
+	obj := conn.Object("com.redhat.Yggdrasil1", "/com/redhat/Yggdrasil1")
+	var entry map[string]string
+	var data []byte
+	call := obj.Call("com.redhat.Yggdrasil1.InspectDeadLetter", dbus.Flags(0), id)
+	if err := call.Store(&entry, &data); err != nil {
+		return cli.Exit(fmt.Errorf("cannot inspect dead-letter message: %v", err), 1)
+	}
+
+	if c.Bool("content") {
+		if _, err := os.Stdout.Write(data); err != nil {
+			return cli.Exit(fmt.Errorf("cannot write content: %w", err), 1)
+		}
+		return nil
+	}
+
+	output, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return cli.Exit(fmt.Errorf("cannot marshal dead-letter message: %v", err), 1)
+	}
+	fmt.Println(string(output))
+
+	return nil
+}
+
+func deadLettersRequeueAction(c *cli.Context) error {
+	id, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("cannot parse ID: %w", err), 1)
+	}
+
+	conn, err := connectBus()
+	if err != nil {
+		return cli.Exit(fmt.Errorf("cannot connect to bus: %w", err), 1)
+	}
+
+	obj := conn.Object("com.redhat.Yggdrasil1", "/com/redhat/Yggdrasil1")
+	call := obj.Call("com.redhat.Yggdrasil1.RequeueDeadLetter", dbus.Flags(0), id)
+	if err := call.Store(); err != nil {
+		return cli.Exit(fmt.Errorf("cannot requeue dead-letter message: %v", err), 1)
+	}
+
+	fmt.Printf("Requeued dead-letter message %v\n", id)
+
+	return nil
+}
+
+func deadLettersPurgeAction(c *cli.Context) error {
+	if c.Args().Len() == 0 && !c.Bool("all") {
+		return cli.Exit(fmt.Errorf("specify message IDs or --all"), 1)
+	}
+
+	ids := []int64{}
+	for _, arg := range c.Args().Slice() {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("cannot parse ID: %w", err), 1)
+		}
+		ids = append(ids, id)
+	}
+
+	conn, err := connectBus()
+	if err != nil {
+		return cli.Exit(fmt.Errorf("cannot connect to bus: %w", err), 1)
+	}
+
+	obj := conn.Object("com.redhat.Yggdrasil1", "/com/redhat/Yggdrasil1")
+	var count int64
+	call := obj.Call("com.redhat.Yggdrasil1.PurgeDeadLetters", dbus.Flags(0), ids)
+	if err := call.Store(&count); err != nil {
+		return cli.Exit(fmt.Errorf("cannot purge dead-letter messages: %v", err), 1)
+	}
+
+	fmt.Printf("Purged %v dead-letter messages\n", count)
+
+	return nil
+}
+
+func scheduleListAction(c *cli.Context) error {
+	conn, err := connectBus()
+	if err != nil {
+		return cli.Exit(fmt.Errorf("cannot connect to bus: %w", err), 1)
+	}
+
+	obj := conn.Object("com.redhat.Yggdrasil1", "/com/redhat/Yggdrasil1")
+	var entries []map[string]string
+	call := obj.Call("com.redhat.Yggdrasil1.ScheduledMessages", dbus.Flags(0))
+	if err := call.Store(&entries); err != nil {
+		return cli.Exit(fmt.Errorf("cannot list scheduled messages: %v", err), 1)
+	}
+
+	switch c.String("format") {
+	case "json":
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("cannot marshal scheduled messages: %v", err), 1)
+		}
+		fmt.Println(string(data))
+	case "table":
+		writer := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		fmt.Fprint(writer, "ID\tMESSAGE ID\tDIRECTIVE\tNEXT\tCRON\n")
+		for _, entry := range entries {
+			fmt.Fprintf(
+				writer,
+				"%s\t%s\t%s\t%s\t%s\n",
+				entry["id"],
+				entry["message_id"],
+				entry["directive"],
+				entry["next"],
+				entry["cron"],
+			)
+		}
+		if err := writer.Flush(); err != nil {
+			return cli.Exit(fmt.Errorf("unable to flush tab writer: %v", err), 1)
+		}
+	default:
+		return cli.Exit(fmt.Errorf("unknown format type: %v", c.String("format")), 1)
+	}
+
+	return nil
+}
+
+func scheduleCancelAction(c *cli.Context) error {
+	id, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("cannot parse ID: %w", err), 1)
+	}
+
+	conn, err := connectBus()
+	if err != nil {
+		return cli.Exit(fmt.Errorf("cannot connect to bus: %w", err), 1)
+	}
+
+	obj := conn.Object("com.redhat.Yggdrasil1", "/com/redhat/Yggdrasil1")
+	call := obj.Call("com.redhat.Yggdrasil1.CancelScheduledMessage", dbus.Flags(0), id)
+	if err := call.Store(); err != nil {
+		return cli.Exit(fmt.Errorf("cannot cancel scheduled message: %v", err), 1)
+	}
+
+	fmt.Printf("Cancelled scheduled message %v\n", id)
+
+	return nil
+}
+
 func listenAction(ctx *cli.Context) error {
 	conn, err := connectBus()
 	if err != nil {
@@ -308,15 +508,23 @@ func listenAction(ctx *cli.Context) error {
 // worker-data" subcommand. It formats and outputs files needed by workers to
 // communicate with the yggdrasil service over D-Bus.
 func generateWorkerDataAction(ctx *cli.Context) error {
-	config := struct {
+	type workerData struct {
 		User    string
 		Group   string
 		Name    string
+		Names   []string
 		Program string
-	}{
+
+		// Directive is the name a D-Bus service file activates the
+		// worker program for.
+		Directive string
+	}
+
+	config := workerData{
 		User:    ctx.String("user"),
 		Group:   ctx.String("group"),
 		Name:    ctx.String("name"),
+		Names:   append([]string{ctx.String("name")}, ctx.StringSlice("directive")...),
 		Program: ctx.String("program"),
 	}
 
@@ -340,21 +548,33 @@ func generateWorkerDataAction(ctx *cli.Context) error {
 		}
 	}
 
-	data := []struct {
+	type dataFile struct {
 		FileName string
 		FilePath string
 		Template *template.Template
-	}{
-		{
-			FileName: fmt.Sprintf("com.redhat.Yggdrasil1.Worker1.%v.service", config.Name),
+		Data     workerData
+	}
+
+	// A worker program that handles several directives owns a bus name for
+	// each of them. Each name gets its own D-Bus service file so that the bus
+	// can activate the shared systemd unit for any of them.
+	var data []dataFile
+	for _, name := range config.Names {
+		d := config
+		d.Directive = name
+		data = append(data, dataFile{
+			FileName: fmt.Sprintf("com.redhat.Yggdrasil1.Worker1.%v.service", name),
 			FilePath: joinpath(
 				filepath.Join(ctx.Path("output"), "dbus-1", "system-services"),
 				constants.DBusSystemServicesDir,
-				fmt.Sprintf("com.redhat.Yggdrasil1.Worker1.%v.service", config.Name),
+				fmt.Sprintf("com.redhat.Yggdrasil1.Worker1.%v.service", name),
 				ctx.Bool("install"),
 			),
 			Template: template.Must(template.New("").Parse(DBusServiceTemplate)),
-		},
+			Data:     d,
+		})
+	}
+	data = append(data, []dataFile{
 		{
 			FileName: fmt.Sprintf("com.redhat.Yggdrasil1.Worker1.%v.conf", config.Name),
 			FilePath: joinpath(
@@ -364,6 +584,7 @@ func generateWorkerDataAction(ctx *cli.Context) error {
 				ctx.Bool("install"),
 			),
 			Template: template.Must(template.New("").Parse(DBusPolicyConfigTemplate)),
+			Data:     config,
 		},
 		{
 			FileName: fmt.Sprintf("com.redhat.Yggdrasil1.Worker1.%v.service", config.Name),
@@ -374,8 +595,9 @@ func generateWorkerDataAction(ctx *cli.Context) error {
 				ctx.Bool("install"),
 			),
 			Template: template.Must(template.New("").Parse(SystemdServiceTemplate)),
+			Data:     config,
 		},
-	}
+	}...)
 
 	for _, d := range data {
 		if err := os.MkdirAll(filepath.Dir(d.FilePath), 0755); err != nil {
@@ -389,7 +611,7 @@ func generateWorkerDataAction(ctx *cli.Context) error {
 			return cli.Exit(fmt.Errorf("cannt create file %v: %v", d.FilePath, err), 1)
 		}
 		defer f.Close()
-		if err := d.Template.Execute(f, config); err != nil {
+		if err := d.Template.Execute(f, d.Data); err != nil {
 			return cli.Exit(fmt.Errorf("cannot write file %v: %v", d.FilePath, err), 1)
 		}
 	}
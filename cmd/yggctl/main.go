@@ -104,7 +104,12 @@ from stdin.`,
 					Usage:     "Generate data files needed for workers to interact with yggd",
 					UsageText: "yggctl generate worker-data [command options]",
 					Description: `The generate worker-data command creates data files necessary for workers to
-communicate properly with yggd.`,
+communicate properly with yggd.
+
+A worker program that handles several directives in one process may list
+each additional directive with --directive. The generated D-Bus policy
+allows the program to own the bus name of every directive, and each
+directive's bus name activates the same systemd unit.`,
 					Flags: []cli.Flag{
 						&cli.BoolFlag{
 							Name:    "install",
@@ -122,6 +127,11 @@ communicate properly with yggd.`,
 							Usage:    "generate files using `NAME`",
 							Required: true,
 						},
+						&cli.StringSliceFlag{
+							Name:    "directive",
+							Aliases: []string{"d"},
+							Usage:   "allow the worker to also handle `DIRECTIVE`",
+						},
 						&cli.StringFlag{
 							Name:     "program",
 							Aliases:  []string{"p"},
@@ -148,9 +158,17 @@ communicate properly with yggd.`,
 							)
 						}
 
-						if strings.Contains(ctx.String("name"), " -") {
+						if strings.ContainsAny(ctx.String("name"), " -") {
 							return cli.Exit("'name' cannot contain spaces or dashes", 1)
 						}
+						for _, directive := range ctx.StringSlice("directive") {
+							if strings.ContainsAny(directive, " -") {
+								return cli.Exit("'directive' cannot contain spaces or dashes", 1)
+							}
+							if directive == ctx.String("name") {
+								return cli.Exit("'directive' cannot be the same as 'name'", 1)
+							}
+						}
 
 						re := regexp.MustCompile("^[a-z][a-z0-9_]{0,31}$")
 						if !re.Match([]byte(ctx.String("user"))) {
@@ -254,6 +272,84 @@ communicate properly with yggd.`,
 			},
 			Action: messageJournalAction,
 		},
+		{
+			Name:  "dead-letters",
+			Usage: "Interact with messages that could not be dispatched to workers",
+			Subcommands: []*cli.Command{
+				{
+					Name:        "list",
+					Usage:       "List dead-letter messages",
+					Description: "The list command prints a list of messages that could not be dispatched to a worker after repeated attempts.",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "format",
+							Usage: "Print output in `FORMAT` (json or table)",
+							Value: "table",
+						},
+					},
+					Action: deadLettersListAction,
+				},
+				{
+					Name:        "inspect",
+					Usage:       "Show a dead-letter message",
+					UsageText:   "yggctl dead-letters inspect [command options] ID",
+					Description: "The inspect command prints the dead-letter message identified by ID.",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "content",
+							Usage: "Print only the message content",
+						},
+					},
+					Action: deadLettersInspectAction,
+				},
+				{
+					Name:        "requeue",
+					Usage:       "Dispatch a dead-letter message again",
+					UsageText:   "yggctl dead-letters requeue ID",
+					Description: "The requeue command removes the dead-letter message identified by ID and queues it to be dispatched to its worker again.",
+					Action:      deadLettersRequeueAction,
+				},
+				{
+					Name:        "purge",
+					Usage:       "Remove dead-letter messages",
+					UsageText:   "yggctl dead-letters purge [command options] [ID...]",
+					Description: "The purge command removes the dead-letter messages identified by each ID, or all dead-letter messages if --all is given.",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "all",
+							Usage: "Remove all dead-letter messages",
+						},
+					},
+					Action: deadLettersPurgeAction,
+				},
+			},
+		},
+		{
+			Name:  "schedule",
+			Usage: "Interact with messages waiting to be dispatched at a later time",
+			Subcommands: []*cli.Command{
+				{
+					Name:        "list",
+					Usage:       "List scheduled messages",
+					Description: "The list command prints a list of messages held because of their \"not-before\" or \"cron\" metadata values, in the order they are due.",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "format",
+							Usage: "Print output in `FORMAT` (json or table)",
+							Value: "table",
+						},
+					},
+					Action: scheduleListAction,
+				},
+				{
+					Name:        "cancel",
+					Usage:       "Cancel a scheduled message",
+					UsageText:   "yggctl schedule cancel ID",
+					Description: "The cancel command removes the scheduled message identified by ID so that it is not dispatched.",
+					Action:      scheduleCancelAction,
+				},
+			},
+		},
 		{
 			Name:        "listen",
 			Usage:       "Listen to worker event output",
@@ -3,6 +3,7 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -18,25 +19,57 @@ import (
 	internaldbus "github.com/redhatinsights/yggdrasil/dbus"
 	"github.com/redhatinsights/yggdrasil/internal/config"
 	"github.com/redhatinsights/yggdrasil/internal/constants"
+	"github.com/redhatinsights/yggdrasil/internal/deadletter"
+	"github.com/redhatinsights/yggdrasil/internal/dedup"
 	"github.com/redhatinsights/yggdrasil/internal/messagejournal"
+	"github.com/redhatinsights/yggdrasil/internal/outboundqueue"
+	"github.com/redhatinsights/yggdrasil/internal/policy"
+	"github.com/redhatinsights/yggdrasil/internal/signature"
+	"github.com/redhatinsights/yggdrasil/internal/sync"
 	"github.com/redhatinsights/yggdrasil/internal/tags"
 	"github.com/redhatinsights/yggdrasil/internal/transport"
 	"github.com/redhatinsights/yggdrasil/internal/work"
 	"github.com/redhatinsights/yggdrasil/ipc"
 )
 
+// outboundRetryMinDelay and outboundRetryMaxDelay bound the delay between
+// attempts to flush the outbound queue.
+var (
+	outboundRetryMinDelay = 5 * time.Second
+	outboundRetryMaxDelay = 5 * time.Minute
+)
+
+// forwardEventsBufferSize is the number of worker events held for forwarding
+// to the server while earlier events are being sent.
+const forwardEventsBufferSize = 100
+
+// errMalformed is wrapped by errors returned when a message received from the
+// server cannot be parsed or is missing required values.
+var errMalformed = errors.New("malformed message")
+
 type Client struct {
 	conn                *dbus.Conn
 	transporter         transport.Transporter
 	dispatcher          *work.Dispatcher
+	outboundQueue       *outboundqueue.OutboundQueue
+	dedupCache          *dedup.Cache
+	verifier            *signature.Verifier
+	policy              *policy.Policy
+	forwarder           *work.EventForwarder
+	forwardedEvents     chan ipc.WorkerEvent
 	prevDispatchersHash atomic.Value
+	disconnected        atomic.Value
+	pendingResponses    sync.RWMutexMap[chan yggdrasil.Response]
 }
 
 // NewClient creates a new Client configured with dispatcher and transporter.
 func NewClient(dispatcher *work.Dispatcher, transporter transport.Transporter) *Client {
+	disconnected := atomic.Value{}
+	disconnected.Store(false)
 	return &Client{
-		transporter: transporter,
-		dispatcher:  dispatcher,
+		transporter:  transporter,
+		dispatcher:   dispatcher,
+		disconnected: disconnected,
 	}
 }
 
@@ -86,12 +119,44 @@ func (c *Client) Connect() error {
 	}()
 
 	// start receiving values from the dispatcher and transmit them using the
-	// provided transporter.
+	// provided transporter. If the outbound queue is enabled, messages are
+	// queued instead while the transport is disconnected or while older
+	// messages are still waiting to be transmitted.
 	go func() {
 		for msg := range c.dispatcher.Outbound {
+			queued, err := c.queueIfPending(&msg.Data)
+			if err != nil {
+				log.Errorf("cannot queue data message: %v", err)
+				continue
+			}
+			if queued {
+				msg.Resp <- yggdrasil.Response{Code: work.TransmitResponseQueued}
+				continue
+			}
+			// Register the message as awaiting acknowledgement before sending
+			// it, so that an acknowledgement received immediately is not
+			// missed.
+			var ack chan yggdrasil.Response
+			if config.DefaultConfig.TransmitAck {
+				ack = make(chan yggdrasil.Response, 1)
+				c.pendingResponses.Set(msg.Data.MessageID, ack)
+			}
 			code, metadata, data, err := c.SendDataMessage(&msg.Data, msg.Data.Metadata)
 			if err != nil {
 				log.Errorf("cannot send data message: %v", err)
+				c.pendingResponses.Del(msg.Data.MessageID)
+				if c.outboundQueue == nil {
+					continue
+				}
+				if err := c.QueueDataMessage(&msg.Data); err != nil {
+					log.Errorf("cannot queue data message: %v", err)
+					continue
+				}
+				msg.Resp <- yggdrasil.Response{Code: work.TransmitResponseQueued}
+				continue
+			}
+			if ack != nil {
+				go c.awaitResponse(msg.Data.MessageID, ack, msg.Resp)
 				continue
 			}
 			msg.Resp <- yggdrasil.Response{
@@ -102,6 +167,23 @@ func (c *Client) Connect() error {
 		}
 	}()
 
+	// Start a goroutine retrying the transmission of queued messages, so that
+	// they are not held until the transport reconnects.
+	if c.outboundQueue != nil {
+		go c.retryOutboundQueue()
+	}
+
+	// Start a goroutine receiving messages the dispatcher failed to deliver
+	// to a worker and report each failure to the server.
+	go func() {
+		for f := range c.dispatcher.Failures {
+			err := c.sendDispatchFailure(f.Data.MessageID, f.Data.Directive, f.Err)
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	}()
+
 	// set a transport RxHandlerFunc that calls the client's control and data
 	// receive handler functions.
 	err := c.transporter.SetRxHandler(
@@ -111,7 +193,21 @@ func (c *Client) Connect() error {
 				var message yggdrasil.Data
 
 				if err := json.Unmarshal(data, &message); err != nil {
-					return fmt.Errorf("cannot unmarshal data message: %w", err)
+					return c.rejectMessage(
+						message.MessageID,
+						message.Directive,
+						fmt.Errorf("%w: cannot unmarshal data message: %v", errMalformed, err),
+					)
+				}
+				if c.verifier != nil {
+					targets := c.dispatcher.Targets(message.Directive, message.Metadata)
+					if err := c.verifier.VerifyData(&message, targets); err != nil {
+						return c.rejectMessage(
+							message.MessageID,
+							message.Directive,
+							fmt.Errorf("rejecting data message %v: %w", message.MessageID, err),
+						)
+					}
 				}
 				if err := c.ReceiveDataMessage(&message); err != nil {
 					return fmt.Errorf("cannot process data message: %w", err)
@@ -119,11 +215,30 @@ func (c *Client) Connect() error {
 			case "control":
 				var message yggdrasil.Control
 
+				// Failures to handle acknowledgements are not reported, so
+				// that the server never needs to acknowledge a report.
+				reject := func(err error) error {
+					switch message.Type {
+					case yggdrasil.MessageTypeResponse, yggdrasil.MessageTypeEvent:
+						return err
+					}
+					return c.rejectMessage(message.MessageID, "", err)
+				}
+
 				if err := json.Unmarshal(data, &message); err != nil {
-					return fmt.Errorf("cannot unmarshal control message: %w", err)
+					return reject(
+						fmt.Errorf("%w: cannot unmarshal control message: %v", errMalformed, err),
+					)
+				}
+				if c.verifier != nil {
+					if err := c.verifier.VerifyControl(&message); err != nil {
+						return reject(
+							fmt.Errorf("rejecting control message %v: %w", message.MessageID, err),
+						)
+					}
 				}
 				if err := c.ReceiveControlMessage(&message); err != nil {
-					return fmt.Errorf("cannot process control message: %w", err)
+					return reject(fmt.Errorf("cannot process control message: %w", err))
 				}
 			default:
 				return fmt.Errorf("unsupported destination type: %v", addr)
@@ -138,10 +253,17 @@ func (c *Client) Connect() error {
 	_ = c.transporter.SetEventHandler(func(e transport.TransporterEvent) {
 		switch e {
 		case transport.TransporterEventConnected:
+			c.disconnected.Store(false)
+			go func() {
+				if err := c.flushOutboundQueue(); err != nil {
+					log.Error(err)
+				}
+			}()
 			if err := c.dispatcher.EmitEvent(ipc.DispatcherEventConnectionRestored); err != nil {
 				log.Errorf("cannot emit event: %v", err)
 			}
 		case transport.TransporterEventDisconnected:
+			c.disconnected.Store(true)
 			if err := c.dispatcher.EmitEvent(ipc.DispatcherEventUnexpectedDisconnect); err != nil {
 				log.Errorf("cannot emit event: %v", err)
 			}
@@ -176,10 +298,21 @@ func (c *Client) Connect() error {
 	}
 	log.Infof("exported /com/redhat/Yggdrasil1 on bus")
 
+	// Start a goroutine forwarding worker events to the server, so that
+	// sending them does not delay emitting the D-Bus signals.
+	if c.forwarder != nil {
+		c.forwardedEvents = make(chan ipc.WorkerEvent, forwardEventsBufferSize)
+		go c.forwardWorkerEvents()
+	}
+
 	// Start a goroutine receiving values from the dispatcher's WorkerEvents
-	// channel, emitting a D-Bus "WorkerEvent" signal for each.
+	// channel, emitting a D-Bus "WorkerEvent" signal for each and queueing it
+	// to be forwarded to the server if enabled.
 	go func() {
 		for e := range c.dispatcher.WorkerEvents {
+			if c.forwarder != nil && c.forwarder.Forward(e) {
+				c.queueWorkerEvent(e)
+			}
 			args := []interface{}{e.Worker, e.Name, e.MessageID, e.ResponseTo}
 			switch e.Name {
 			case ipc.WorkerEventNameWorking:
@@ -227,6 +360,136 @@ func (c *Client) MessageJournal(
 	return journal, nil
 }
 
+// OutboundQueue implements the com.redhat.Yggdrasil1.OutboundQueue method.
+func (c *Client) OutboundQueue() ([]map[string]string, *dbus.Error) {
+	if c.outboundQueue == nil {
+		return nil, dbus.MakeFailedError(fmt.Errorf("outbound queue is not enabled"))
+	}
+	entries, err := c.outboundQueue.GetEntries()
+	if err != nil {
+		return nil, dbus.MakeFailedError(err)
+	}
+	return entries, nil
+}
+
+// DeadLetters implements the com.redhat.Yggdrasil1.DeadLetters method.
+func (c *Client) DeadLetters() ([]map[string]string, *dbus.Error) {
+	if c.dispatcher.DeadLetters == nil {
+		return nil, dbus.MakeFailedError(fmt.Errorf("dead-letter store is not enabled"))
+	}
+	entries, err := c.dispatcher.DeadLetters.GetEntries()
+	if err != nil {
+		return nil, dbus.MakeFailedError(err)
+	}
+	return entries, nil
+}
+
+// InspectDeadLetter implements the com.redhat.Yggdrasil1.InspectDeadLetter
+// method.
+func (c *Client) InspectDeadLetter(id int64) (map[string]string, []byte, *dbus.Error) {
+	entry, err := c.deadLetter(id)
+	if err != nil {
+		return nil, nil, dbus.MakeFailedError(err)
+	}
+	return entry.Summary(), entry.Message.Content, nil
+}
+
+// RequeueDeadLetter implements the com.redhat.Yggdrasil1.RequeueDeadLetter
+// method. The message is verified and authorized again before it is queued,
+// since the signature requirements and policy may have changed since it was
+// received.
+func (c *Client) RequeueDeadLetter(id int64) *dbus.Error {
+	entry, err := c.deadLetter(id)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	targets := c.dispatcher.Targets(entry.Message.Directive, entry.Message.Metadata)
+	if c.verifier != nil {
+		if err := c.verifier.VerifyStoredData(&entry.Message, targets); err != nil {
+			return dbus.MakeFailedError(
+				fmt.Errorf("cannot requeue message %v: %w", entry.MessageID, err),
+			)
+		}
+	}
+	if err := c.authorize(&entry.Message, targets); err != nil {
+		return dbus.MakeFailedError(
+			fmt.Errorf("cannot requeue message %v: %w", entry.MessageID, err),
+		)
+	}
+	if err := c.dispatcher.Enqueue(entry.Message); err != nil {
+		return dbus.MakeFailedError(fmt.Errorf("cannot queue message for dispatch: %w", err))
+	}
+	if err := c.dispatcher.DeadLetters.Remove(id); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	log.Infof("requeued dead-letter message %v", entry.MessageID)
+	return nil
+}
+
+// PurgeDeadLetters implements the com.redhat.Yggdrasil1.PurgeDeadLetters
+// method.
+func (c *Client) PurgeDeadLetters(ids []int64) (int64, *dbus.Error) {
+	if c.dispatcher.DeadLetters == nil {
+		return 0, dbus.MakeFailedError(fmt.Errorf("dead-letter store is not enabled"))
+	}
+	n, err := c.dispatcher.DeadLetters.Purge(ids...)
+	if err != nil {
+		return 0, dbus.MakeFailedError(err)
+	}
+	log.Infof("purged %v dead-letter messages", n)
+	return n, nil
+}
+
+// deadLetter returns the dead-letter entry with the given id.
+func (c *Client) deadLetter(id int64) (*deadletter.Entry, error) {
+	if c.dispatcher.DeadLetters == nil {
+		return nil, fmt.Errorf("dead-letter store is not enabled")
+	}
+	entry, err := c.dispatcher.DeadLetters.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("no dead-letter message with ID %v", id)
+	}
+	return entry, nil
+}
+
+// ScheduledMessages implements the com.redhat.Yggdrasil1.ScheduledMessages
+// method.
+func (c *Client) ScheduledMessages() ([]map[string]string, *dbus.Error) {
+	if c.dispatcher.Scheduler == nil {
+		return nil, dbus.MakeFailedError(fmt.Errorf("scheduler is not enabled"))
+	}
+	entries, err := c.dispatcher.Scheduler.GetEntries()
+	if err != nil {
+		return nil, dbus.MakeFailedError(err)
+	}
+	return entries, nil
+}
+
+// CancelScheduledMessage implements the
+// com.redhat.Yggdrasil1.CancelScheduledMessage method.
+func (c *Client) CancelScheduledMessage(id int64) *dbus.Error {
+	if c.dispatcher.Scheduler == nil {
+		return dbus.MakeFailedError(fmt.Errorf("scheduler is not enabled"))
+	}
+	cancelled, err := c.dispatcher.Scheduler.Cancel(id)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	if !cancelled {
+		return dbus.MakeFailedError(fmt.Errorf("no scheduled message with ID %v", id))
+	}
+	log.Infof("cancelled scheduled message %v", id)
+	return nil
+}
+
+// DispatchQueues implements the com.redhat.Yggdrasil1.DispatchQueues method.
+func (c *Client) DispatchQueues() (map[string]map[string]string, *dbus.Error) {
+	return c.dispatcher.QueueDepths(), nil
+}
+
 // Dispatch implements the com.redhat.Yggdrasil1.Dispatch method.
 func (c *Client) Dispatch(
 	directive string,
@@ -260,6 +523,101 @@ func (c *Client) SendDataMessage(
 	return c.sendMessage("data", metadata, msg)
 }
 
+// QueueDataMessage marshals msg and stores it in the outbound queue for later
+// transmission.
+func (c *Client) QueueDataMessage(msg *yggdrasil.Data) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("cannot marshal message: %w", err)
+	}
+	if err := c.outboundQueue.Push(msg.MessageID, "data", msg.Metadata, data); err != nil {
+		return fmt.Errorf("cannot push message: %w", err)
+	}
+	log.Infof("queued message %v for later transmission", msg.MessageID)
+	return nil
+}
+
+// queueIfPending stores msg in the outbound queue rather than transmitting it
+// immediately when the outbound queue is enabled and either the transport is
+// disconnected or the queue is not empty, so that messages are transmitted in
+// the order they were sent. It reports whether msg was queued.
+func (c *Client) queueIfPending(msg *yggdrasil.Data) (bool, error) {
+	if c.outboundQueue == nil {
+		return false, nil
+	}
+	if c.disconnected.Load().(bool) {
+		if err := c.QueueDataMessage(msg); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return false, fmt.Errorf("cannot marshal message: %w", err)
+	}
+	queued, err := c.outboundQueue.PushIfNotEmpty(msg.MessageID, "data", msg.Metadata, data)
+	if err != nil {
+		return false, fmt.Errorf("cannot push message: %w", err)
+	}
+	if queued {
+		log.Infof("queued message %v behind older messages", msg.MessageID)
+	}
+	return queued, nil
+}
+
+// flushOutboundQueue transmits all messages held in the outbound queue, oldest
+// first, stopping at the first message that cannot be transmitted.
+func (c *Client) flushOutboundQueue() error {
+	if c.outboundQueue == nil {
+		return nil
+	}
+	err := c.outboundQueue.Flush(func(e outboundqueue.Entry) error {
+		if c.disconnected.Load().(bool) {
+			return fmt.Errorf("transport is disconnected")
+		}
+		if _, _, _, err := c.transporter.Tx(e.Dest, e.Metadata, e.Data); err != nil {
+			return err
+		}
+		log.Debugf("transmitted queued message %v", e.MessageID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot flush outbound queue: %w", err)
+	}
+	return nil
+}
+
+// retryOutboundQueue periodically flushes the outbound queue while it holds
+// messages and the transport is connected. Transports that do not report
+// reconnecting, and failed flushes while connected, are retried with a delay
+// that doubles after each failure, between outboundRetryMinDelay and
+// outboundRetryMaxDelay.
+func (c *Client) retryOutboundQueue() {
+	delay := outboundRetryMinDelay
+	for {
+		time.Sleep(delay)
+		if c.disconnected.Load().(bool) {
+			continue
+		}
+		n, err := c.outboundQueue.Len()
+		if err != nil {
+			log.Errorf("cannot get outbound queue length: %v", err)
+			continue
+		}
+		if n == 0 {
+			delay = outboundRetryMinDelay
+			continue
+		}
+		if err := c.flushOutboundQueue(); err != nil {
+			log.Errorf("%v", err)
+			delay = min(delay*2, outboundRetryMaxDelay)
+			continue
+		}
+		delay = outboundRetryMinDelay
+	}
+}
+
 func (c *Client) SendConnectionStatusMessage(
 	msg *yggdrasil.ConnectionStatus,
 ) (int, map[string]string, []byte, error) {
@@ -291,20 +649,226 @@ func (c *Client) sendMessage(
 	return c.transporter.Tx(dest, metadata, data)
 }
 
+// authorize returns an error if the policy does not permit msg to be
+// dispatched to every one of targets, the workers it may be routed to, so that
+// routing cannot be used to reach a worker the policy does not permit.
+func (c *Client) authorize(msg *yggdrasil.Data, targets []string) error {
+	if c.policy == nil {
+		return nil
+	}
+	for _, target := range targets {
+		if err := c.policy.Authorize(target, msg.Metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ReceiveDataMessage sends a value to a channel for dispatching to worker processes.
 func (c *Client) ReceiveDataMessage(msg *yggdrasil.Data) error {
-	c.dispatcher.Inbound <- *msg
+	targets := c.dispatcher.Targets(msg.Directive, msg.Metadata)
+	if err := c.authorize(msg, targets); err != nil {
+		log.Warnf("rejecting message %v: %v", msg.MessageID, err)
+		c.dispatcher.RecordJournalEntry(msg, ipc.WorkerEventNameDenied, map[string]string{
+			"reason": err.Error(),
+		})
+		return c.sendDispatchFailure(msg.MessageID, msg.Directive, err)
+	}
+
+	if c.dedupCache != nil {
+		duplicate, err := c.dedupCache.Seen(msg.MessageID)
+		if err != nil {
+			log.Errorf("cannot check message %v for duplicates: %v", msg.MessageID, err)
+		}
+		if duplicate {
+			log.Warnf("dropping duplicate message %v", msg.MessageID)
+			c.dispatcher.RecordJournalEntry(msg, ipc.WorkerEventNameDuplicate, map[string]string{})
+			return nil
+		}
+	}
+
+	if err := c.dispatcher.Enqueue(*msg); err != nil {
+		log.Warnf("rejecting message %v: %v", msg.MessageID, err)
+		// The server may send the message again once it has been told that
+		// it was not handled.
+		if c.dedupCache != nil {
+			if err := c.dedupCache.Forget(msg.MessageID); err != nil {
+				log.Errorf("cannot forget message %v: %v", msg.MessageID, err)
+			}
+		}
+		return c.sendDispatchFailure(msg.MessageID, msg.Directive, err)
+	}
+
+	return nil
+}
+
+// queueWorkerEvent queues e to be forwarded to the server without waiting for
+// it to be sent. If too many events are waiting to be sent, e is dropped.
+func (c *Client) queueWorkerEvent(e ipc.WorkerEvent) {
+	select {
+	case c.forwardedEvents <- e:
+	default:
+		log.Errorf(
+			"cannot forward %v event of message %v: too many events waiting to be sent",
+			e.Name,
+			e.MessageID,
+		)
+	}
+}
 
+// forwardWorkerEvents sends the worker events queued by queueWorkerEvent to the
+// server.
+func (c *Client) forwardWorkerEvents() {
+	for e := range c.forwardedEvents {
+		if err := c.sendWorkerEvent(e); err != nil {
+			log.Errorf("cannot forward worker event: %v", err)
+		}
+	}
+}
+
+// sendWorkerEvent publishes a "worker-event" Event message describing e to the
+// server.
+func (c *Client) sendWorkerEvent(e ipc.WorkerEvent) error {
+	event := yggdrasil.Event{
+		Type:       yggdrasil.MessageTypeEvent,
+		MessageID:  uuid.New().String(),
+		ResponseTo: e.MessageID,
+		Version:    1,
+		Sent:       time.Now(),
+		Content:    string(yggdrasil.EventNameWorkerEvent),
+		WorkerEvent: &yggdrasil.WorkerEvent{
+			Worker:     e.Worker,
+			Name:       e.Name.String(),
+			MessageID:  e.MessageID,
+			ResponseTo: e.ResponseTo,
+			Data:       e.Data,
+		},
+	}
+	if _, _, _, err := c.SendEventMessage(&event); err != nil {
+		return fmt.Errorf("cannot send %v event: %w", e.Name, err)
+	}
 	return nil
 }
 
+// sendDispatchFailure informs the server that the message with the ID
+// responseTo, sent to directive, was not handled because of err.
+func (c *Client) sendDispatchFailure(responseTo, directive string, err error) error {
+	msg := yggdrasil.DispatchFailed{
+		Type:       yggdrasil.MessageTypeDispatchFailed,
+		MessageID:  uuid.New().String(),
+		ResponseTo: responseTo,
+		Version:    1,
+		Sent:       time.Now(),
+	}
+	msg.Content.Code = failureCode(err)
+	msg.Content.Reason = err.Error()
+	msg.Content.Directive = directive
+	if _, _, _, err := c.sendMessage("control", nil, &msg); err != nil {
+		return fmt.Errorf("cannot send dispatch-failed message: %w", err)
+	}
+	return nil
+}
+
+// rejectMessage reports err to the server as the reason the message with the
+// ID messageID was not handled and returns err.
+func (c *Client) rejectMessage(messageID, directive string, err error) error {
+	if sendErr := c.sendDispatchFailure(messageID, directive, err); sendErr != nil {
+		log.Error(sendErr)
+	}
+	return err
+}
+
+// failureCode classifies an error returned while handling a message received
+// from the server.
+func failureCode(err error) yggdrasil.ErrorCode {
+	var dispatchErr *work.DispatchError
+	switch {
+	case errors.As(err, &dispatchErr):
+		return dispatchErr.Code
+	case errors.Is(err, errMalformed):
+		return yggdrasil.ErrorCodeMalformed
+	case errors.Is(err, policy.ErrDenied),
+		errors.Is(err, signature.ErrUnsigned),
+		errors.Is(err, signature.ErrInvalid),
+		errors.Is(err, signature.ErrReplayed):
+		return yggdrasil.ErrorCodeUnauthorized
+	case errors.Is(err, work.ErrQueueFull):
+		return yggdrasil.ErrorCodeQueueFull
+	default:
+		return yggdrasil.ErrorCodeInternal
+	}
+}
+
+// awaitResponse waits for the server to acknowledge the message messageID,
+// sending the acknowledgement on resp. If no acknowledgement is received
+// before the configured timeout, nothing is sent on resp.
+func (c *Client) awaitResponse(
+	messageID string,
+	ack <-chan yggdrasil.Response,
+	resp chan<- yggdrasil.Response,
+) {
+	defer c.pendingResponses.Del(messageID)
+
+	select {
+	case r := <-ack:
+		log.Debugf("received acknowledgement for message %v", messageID)
+		resp <- r
+	case <-time.After(config.DefaultConfig.TransmitAckTimeout):
+		log.Errorf("timeout reached waiting for acknowledgement of message %v", messageID)
+	}
+}
+
+// responseFromControl creates a Response from a "response" or "event" control
+// message sent by the server.
+func responseFromControl(msg *yggdrasil.Control) (*yggdrasil.Response, error) {
+	switch msg.Type {
+	case yggdrasil.MessageTypeResponse:
+		var resp yggdrasil.Response
+		if err := json.Unmarshal(msg.Content, &resp); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal response message: %w", err)
+		}
+		return &resp, nil
+	case yggdrasil.MessageTypeEvent:
+		var event string
+		if err := json.Unmarshal(msg.Content, &event); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal event message: %w", err)
+		}
+		return &yggdrasil.Response{
+			Code: work.TransmitResponseOK,
+			Data: []byte(event),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported control message type: %v", msg.Type)
+	}
+}
+
 // ReceiveControlMessage unpacks a control message and acts accordingly.
 func (c *Client) ReceiveControlMessage(msg *yggdrasil.Control) error {
 	switch msg.Type {
+	case yggdrasil.MessageTypeResponse, yggdrasil.MessageTypeEvent:
+		ack, has := c.pendingResponses.Get(msg.ResponseTo)
+		if !has {
+			log.Debugf(
+				"ignoring %v message %v: message %v is not awaiting acknowledgement",
+				msg.Type,
+				msg.MessageID,
+				msg.ResponseTo,
+			)
+			return nil
+		}
+		resp, err := responseFromControl(msg)
+		if err != nil {
+			return err
+		}
+		select {
+		case ack <- *resp:
+		default:
+			log.Debugf("ignoring duplicate acknowledgement %v", msg.MessageID)
+		}
 	case yggdrasil.MessageTypeCommand:
 		var cmd yggdrasil.Command
 		if err := json.Unmarshal(msg.Content, &cmd); err != nil {
-			return fmt.Errorf("cannot unmarshal command message: %w", err)
+			return fmt.Errorf("%w: cannot unmarshal command message: %v", errMalformed, err)
 		}
 
 		log.Debugf("received message %v", msg.MessageID)
@@ -338,7 +902,7 @@ func (c *Client) ReceiveControlMessage(msg *yggdrasil.Control) error {
 			c.transporter.Disconnect(500)
 			delay, err := strconv.ParseInt(cmd.Arguments["delay"], 10, 64)
 			if err != nil {
-				return fmt.Errorf("cannot parse data to int: %w", err)
+				return fmt.Errorf("%w: cannot parse data to int: %v", errMalformed, err)
 			}
 			time.Sleep(time.Duration(delay) * time.Second)
 
@@ -351,11 +915,17 @@ func (c *Client) ReceiveControlMessage(msg *yggdrasil.Control) error {
 			// cmd contains the directive and the message id to be canceled.
 			directive, exists := cmd.Arguments["directive"]
 			if !exists {
-				return fmt.Errorf("cancel command does not contain 'directive' argument")
+				return fmt.Errorf(
+					"%w: cancel command does not contain 'directive' argument",
+					errMalformed,
+				)
 			}
 			cancelID, exists := cmd.Arguments["messageID"]
 			if !exists {
-				return fmt.Errorf("cancel command does not contain 'messageID' argument")
+				return fmt.Errorf(
+					"%w: cancel command does not contain 'messageID' argument",
+					errMalformed,
+				)
 			}
 
 			directive, err := work.ScrubName(directive)
@@ -363,15 +933,34 @@ func (c *Client) ReceiveControlMessage(msg *yggdrasil.Control) error {
 				log.Debug(err)
 			}
 
-			// Dispatch to appropriate worker.
-			if err := c.dispatcher.CancelMessage(directive, msg.MessageID, cancelID); err != nil {
+			// A message that has not been dispatched yet is removed from the
+			// scheduler instead.
+			if c.dispatcher.Scheduler != nil {
+				cancelled, err := c.dispatcher.Scheduler.CancelMessage(cancelID)
+				if err != nil {
+					return fmt.Errorf("cannot cancel scheduled message: %w", err)
+				}
+				if cancelled {
+					log.Infof("cancelled scheduled message %v", cancelID)
+					return nil
+				}
+			}
+
+			// Dispatch to the workers the message was routed to.
+			var errs []error
+			for _, worker := range c.dispatcher.Recipients(directive, cancelID) {
+				if err := c.dispatcher.CancelMessage(worker, msg.MessageID, cancelID); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			if err := errors.Join(errs...); err != nil {
 				return fmt.Errorf("cannot dispatch cancel message: %w", err)
 			}
 		default:
-			return fmt.Errorf("unknown command: %v", cmd.Command)
+			return fmt.Errorf("%w: unknown command: %v", errMalformed, cmd.Command)
 		}
 	default:
-		return fmt.Errorf("unsupported control message: %v", msg)
+		return fmt.Errorf("%w: unsupported control message: %v", errMalformed, msg)
 	}
 
 	return nil
@@ -414,6 +1003,7 @@ func (c *Client) ConnectionStatus() (*yggdrasil.ConnectionStatus, error) {
 			State          yggdrasil.ConnectionState    "json:\"state\""
 			Tags           map[string]string            "json:\"tags,omitempty\""
 			ClientVersion  string                       "json:\"client_version,omitempty\""
+			ActiveServer   string                       "json:\"active_server,omitempty\""
 		}{
 			CanonicalFacts: facts,
 			Dispatchers:    c.dispatcher.FlattenDispatchers(),
@@ -423,5 +1013,11 @@ func (c *Client) ConnectionStatus() (*yggdrasil.ConnectionStatus, error) {
 		},
 	}
 
+	// Transports that fail over between servers report which server is in
+	// use.
+	if t, ok := c.transporter.(interface{ ActiveServer() string }); ok {
+		msg.Content.ActiveServer = t.ActiveServer()
+	}
+
 	return &msg, nil
 }
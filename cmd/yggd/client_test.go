@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/redhatinsights/yggdrasil"
+	"github.com/redhatinsights/yggdrasil/internal/config"
+	"github.com/redhatinsights/yggdrasil/internal/deadletter"
+	"github.com/redhatinsights/yggdrasil/internal/outboundqueue"
+	"github.com/redhatinsights/yggdrasil/internal/policy"
+	"github.com/redhatinsights/yggdrasil/internal/signature"
+	"github.com/redhatinsights/yggdrasil/internal/transport"
+	"github.com/redhatinsights/yggdrasil/internal/work"
+	"github.com/redhatinsights/yggdrasil/ipc"
+)
+
+// fakeTransport is a transport.Transporter that records the messages it
+// transmits, failing to transmit while fail is set.
+type fakeTransport struct {
+	transport.Noop
+
+	mu   sync.Mutex
+	fail bool
+	sent []string
+}
+
+func (t *fakeTransport) Tx(
+	addr string,
+	metadata map[string]string,
+	data []byte,
+) (int, map[string]string, []byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fail {
+		return transport.TxResponseErr, nil, nil, fmt.Errorf("cannot transmit")
+	}
+	t.sent = append(t.sent, string(data))
+	return 200, nil, nil, nil
+}
+
+func (t *fakeTransport) setFail(fail bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fail = fail
+}
+
+func (t *fakeTransport) transmitted() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.sent...)
+}
+
+func init() {
+	// Retry goroutines started by tests outlive them, so the delays are set
+	// once for every test.
+	outboundRetryMinDelay = time.Millisecond
+	outboundRetryMaxDelay = 10 * time.Millisecond
+}
+
+func TestRetryOutboundQueue(t *testing.T) {
+	queue, err := outboundqueue.Open(filepath.Join(t.TempDir(), "queue.db"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := queue.Push(id, "data", nil, []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The transport fails to transmit while connected, and never reports
+	// reconnecting.
+	tr := &fakeTransport{fail: true}
+	c := NewClient(nil, tr)
+	c.outboundQueue = queue
+	go c.retryOutboundQueue()
+
+	time.Sleep(20 * time.Millisecond)
+	tr.setFail(false)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if n, err := queue.Len(); err == nil && n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if want := []string{"a", "b"}; !cmp.Equal(tr.transmitted(), want) {
+		t.Errorf("%v", cmp.Diff(tr.transmitted(), want))
+	}
+}
+
+func TestQueueWorkerEvent(t *testing.T) {
+	tr := &fakeTransport{}
+	c := NewClient(nil, tr)
+	c.forwardedEvents = make(chan ipc.WorkerEvent, 1)
+
+	// Events that do not fit in the buffer are dropped instead of blocking.
+	for _, id := range []string{"a", "b", "c"} {
+		c.queueWorkerEvent(ipc.WorkerEvent{
+			Worker:    "echo",
+			Name:      ipc.WorkerEventNameBegin,
+			MessageID: id,
+		})
+	}
+	close(c.forwardedEvents)
+	c.forwardWorkerEvents()
+
+	sent := tr.transmitted()
+	if len(sent) != 1 {
+		t.Fatalf("%v != %v", len(sent), 1)
+	}
+	var event yggdrasil.Event
+	if err := json.Unmarshal([]byte(sent[0]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.WorkerEvent == nil || event.WorkerEvent.MessageID != "a" {
+		t.Errorf("unexpected event: %+v", event.WorkerEvent)
+	}
+}
+
+func TestResponseFromControl(t *testing.T) {
+	tests := []struct {
+		description string
+		input       yggdrasil.Control
+		want        *yggdrasil.Response
+		wantError   error
+	}{
+		{
+			description: "response",
+			input: yggdrasil.Control{
+				Type:    yggdrasil.MessageTypeResponse,
+				Content: json.RawMessage(`{"code":202,"metadata":{"a":"b"},"data":"aGVsbG8="}`),
+			},
+			want: &yggdrasil.Response{
+				Code:     202,
+				Metadata: map[string]string{"a": "b"},
+				Data:     []byte("hello"),
+			},
+		},
+		{
+			description: "event",
+			input: yggdrasil.Control{
+				Type:    yggdrasil.MessageTypeEvent,
+				Content: json.RawMessage(`"RECEIVED"`),
+			},
+			want: &yggdrasil.Response{
+				Code: work.TransmitResponseOK,
+				Data: []byte("RECEIVED"),
+			},
+		},
+		{
+			description: "malformed response",
+			input: yggdrasil.Control{
+				Type:    yggdrasil.MessageTypeResponse,
+				Content: json.RawMessage(`"RECEIVED"`),
+			},
+			wantError: cmpopts.AnyError,
+		},
+		{
+			description: "unsupported type",
+			input: yggdrasil.Control{
+				Type:    yggdrasil.MessageTypeCommand,
+				Content: json.RawMessage(`{}`),
+			},
+			wantError: cmpopts.AnyError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := responseFromControl(&test.input)
+
+			if test.wantError != nil {
+				if !cmp.Equal(err, test.wantError, cmpopts.EquateErrors()) {
+					t.Errorf("%#v != %#v", err, test.wantError)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !cmp.Equal(got, test.want) {
+					t.Errorf("%v", cmp.Diff(got, test.want))
+				}
+			}
+		})
+	}
+}
+
+func TestAwaitResponse(t *testing.T) {
+	timeout := config.DefaultConfig.TransmitAckTimeout
+	t.Cleanup(func() {
+		config.DefaultConfig.TransmitAckTimeout = timeout
+	})
+	config.DefaultConfig.TransmitAckTimeout = 100 * time.Millisecond
+
+	tests := []struct {
+		description string
+		input       yggdrasil.Control
+		want        *yggdrasil.Response
+	}{
+		{
+			description: "acknowledged",
+			input: yggdrasil.Control{
+				Type:       yggdrasil.MessageTypeEvent,
+				MessageID:  "ack",
+				ResponseTo: "1234",
+				Content:    json.RawMessage(`"RECEIVED"`),
+			},
+			want: &yggdrasil.Response{
+				Code: work.TransmitResponseOK,
+				Data: []byte("RECEIVED"),
+			},
+		},
+		{
+			description: "acknowledgement of another message",
+			input: yggdrasil.Control{
+				Type:       yggdrasil.MessageTypeEvent,
+				MessageID:  "ack",
+				ResponseTo: "5678",
+				Content:    json.RawMessage(`"RECEIVED"`),
+			},
+		},
+		{
+			description: "timeout",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := NewClient(nil, &fakeTransport{})
+
+			ack := make(chan yggdrasil.Response, 1)
+			c.pendingResponses.Set("1234", ack)
+			resp := make(chan yggdrasil.Response, 1)
+			done := make(chan struct{})
+			go func() {
+				c.awaitResponse("1234", ack, resp)
+				close(done)
+			}()
+
+			if test.input.Type != "" {
+				if err := c.ReceiveControlMessage(&test.input); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got *yggdrasil.Response
+			select {
+			case r := <-resp:
+				got = &r
+			case <-done:
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v", cmp.Diff(got, test.want))
+			}
+
+			<-done
+			if _, has := c.pendingResponses.Get("1234"); has {
+				t.Errorf("message is still awaiting acknowledgement")
+			}
+		})
+	}
+}
+
+func TestRequeueDeadLetter(t *testing.T) {
+	dir := t.TempDir()
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	policyFile := filepath.Join(dir, "policy.toml")
+	policyData := []byte("[directive.echo]\nremote = false\n")
+	if err := os.WriteFile(policyFile, policyData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		required    []string
+		policyFile  string
+	}{
+		{
+			description: "unsigned required",
+			required:    []string{"echo"},
+		},
+		{
+			description: "denied by policy",
+			policyFile:  policyFile,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			store, err := deadletter.Open(filepath.Join(t.TempDir(), "dead-letters.db"), 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := yggdrasil.Data{
+				Type:      yggdrasil.MessageTypeData,
+				MessageID: "1234",
+				Directive: "echo",
+				Content:   json.RawMessage(`{}`),
+			}
+			if err := store.Add(msg, 1, fmt.Errorf("worker not found")); err != nil {
+				t.Fatal(err)
+			}
+
+			dispatcher := work.NewDispatcher(nil)
+			dispatcher.DeadLetters = store
+			c := NewClient(dispatcher, &fakeTransport{})
+			c.verifier, err = signature.NewVerifier([]string{keyFile}, nil, test.required, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.policyFile != "" {
+				c.policy, err = policy.Load(test.policyFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := c.RequeueDeadLetter(1); err == nil {
+				t.Fatal("expected error")
+			}
+			entry, err := store.Get(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry == nil {
+				t.Errorf("dead-letter message was removed")
+			}
+		})
+	}
+}
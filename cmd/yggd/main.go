@@ -12,8 +12,15 @@ import (
 	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/redhatinsights/yggdrasil/internal/config"
 	"github.com/redhatinsights/yggdrasil/internal/constants"
+	"github.com/redhatinsights/yggdrasil/internal/deadletter"
+	"github.com/redhatinsights/yggdrasil/internal/dedup"
 	"github.com/redhatinsights/yggdrasil/internal/http"
 	"github.com/redhatinsights/yggdrasil/internal/messagejournal"
+	"github.com/redhatinsights/yggdrasil/internal/outboundqueue"
+	"github.com/redhatinsights/yggdrasil/internal/policy"
+	"github.com/redhatinsights/yggdrasil/internal/routing"
+	"github.com/redhatinsights/yggdrasil/internal/schedule"
+	"github.com/redhatinsights/yggdrasil/internal/signature"
 	"github.com/redhatinsights/yggdrasil/internal/transport"
 	"github.com/redhatinsights/yggdrasil/internal/work"
 
@@ -63,13 +70,49 @@ func setupDefaultConfig(c *cli.Context) {
 		FactsFile:                c.String(config.FlagNameFactsFile),
 		HTTPRetries:              c.Int(config.FlagNameHTTPRetries),
 		HTTPTimeout:              c.Duration(config.FlagNameHTTPTimeout),
+		HTTPPollingInterval:      c.Duration(config.FlagNameHTTPPollingInterval),
+		HTTPLongPollWait:         c.Duration(config.FlagNameHTTPLongPollWait),
+		HTTPMaxBackoff:           c.Duration(config.FlagNameHTTPMaxBackoff),
+		HTTPFailoverCooldown:     c.Duration(config.FlagNameHTTPFailoverCooldown),
 		MQTTConnectRetry:         c.Bool(config.FlagNameMQTTConnectRetry),
 		MQTTConnectRetryInterval: c.Duration(config.FlagNameMQTTConnectRetryInterval),
 		MQTTAutoReconnect:        c.Bool(config.FlagNameMQTTAutoReconnect),
 		MQTTReconnectDelay:       c.Duration(config.FlagNameMQTTReconnectDelay),
 		MQTTConnectTimeout:       c.Duration(config.FlagNameMQTTConnectTimeout),
 		MQTTPublishTimeout:       c.Duration(config.FlagNameMQTTPublishTimeout),
+		MQTTFragmentSize:         c.Int(config.FlagNameMQTTFragmentSize),
+		MQTTFragmentTimeout:      c.Duration(config.FlagNameMQTTFragmentTimeout),
 		MessageJournal:           c.String(config.FlagNameMessageJournal),
+		OutboundQueue:            c.Bool(config.FlagNameOutboundQueue),
+		OutboundQueueMaxSize:     c.Int(config.FlagNameOutboundQueueMaxSize),
+		OutboundQueueMaxAge:      c.Duration(config.FlagNameOutboundQueueMaxAge),
+		Dedup:                    c.Bool(config.FlagNameDedup),
+		DedupPersist:             c.Bool(config.FlagNameDedupPersist),
+		DedupMaxSize:             c.Int(config.FlagNameDedupMaxSize),
+		DedupWindow:              c.Duration(config.FlagNameDedupWindow),
+		SignatureKey:             c.StringSlice(config.FlagNameSignatureKey),
+		SignatureCARoot:          c.StringSlice(config.FlagNameSignatureCARoot),
+		SignatureRequire:         c.StringSlice(config.FlagNameSignatureRequire),
+		SignatureReplayWindow:    c.Duration(config.FlagNameSignatureReplayWindow),
+		DispatchConcurrency:      c.Int(config.FlagNameDispatchConcurrency),
+		DispatchQueueDepth:       c.Int(config.FlagNameDispatchQueueDepth),
+		DispatchQueueFull:        c.String(config.FlagNameDispatchQueueFull),
+		DispatchMaxAttempts:      c.Int(config.FlagNameDispatchMaxAttempts),
+		DispatchRetryDelay:       c.Duration(config.FlagNameDispatchRetryDelay),
+		ActivationTimeout:        c.Duration(config.FlagNameActivationTimeout),
+		ContentCacheMaxSize:      c.Int(config.FlagNameContentCacheMaxSize),
+		ContentCacheMaxAge:       c.Duration(config.FlagNameContentCacheMaxAge),
+		DeadLetter:               c.Bool(config.FlagNameDeadLetter),
+		DeadLetterMaxSize:        c.Int(config.FlagNameDeadLetterMaxSize),
+		DeadLetterMaxAge:         c.Duration(config.FlagNameDeadLetterMaxAge),
+		ScheduleMaxEntries:       c.Int(config.FlagNameScheduleMaxEntries),
+		MessageMaxAge:            c.Duration(config.FlagNameMessageMaxAge),
+		ClockSkew:                c.Duration(config.FlagNameClockSkew),
+		ForwardEvents:            c.StringSlice(config.FlagNameForwardEvents),
+		ForwardInterval:          c.Duration(config.FlagNameForwardInterval),
+		TransmitTimeout:          c.Duration(config.FlagNameTransmitTimeout),
+		TransmitAck:              c.Bool(config.FlagNameTransmitAck),
+		TransmitAckTimeout:       c.Duration(config.FlagNameTransmitAckTimeout),
 	}
 }
 
@@ -124,6 +167,10 @@ func setupClientID() error {
 func setupClient(
 	dispatcher *work.Dispatcher,
 	tlsConfig *tls.Config,
+	outboundQueue *outboundqueue.OutboundQueue,
+	dedupCache *dedup.Cache,
+	verifier *signature.Verifier,
+	authPolicy *policy.Policy,
 ) (*Client, transport.Transporter, error) {
 	var transporter transport.Transporter
 	switch config.DefaultConfig.Protocol {
@@ -137,17 +184,39 @@ func setupClient(
 		if err != nil {
 			return nil, nil, cli.Exit(fmt.Errorf("cannot create MQTT transport: %w", err), 1)
 		}
+	case "mqtt5":
+		var err error
+		transporter, err = transport.NewMQTT5Transport(
+			config.DefaultConfig.ClientID,
+			config.DefaultConfig.Server,
+			tlsConfig,
+		)
+		if err != nil {
+			return nil, nil, cli.Exit(fmt.Errorf("cannot create MQTT v5 transport: %w", err), 1)
+		}
 	case "http":
 		var err error
 		transporter, err = transport.NewHTTPTransport(
-			config.DefaultConfig.ClientID, config.DefaultConfig.Server[0],
+			config.DefaultConfig.ClientID,
+			config.DefaultConfig.Server,
 			tlsConfig,
 			UserAgent,
-			time.Second*5,
+			config.DefaultConfig.HTTPPollingInterval,
 		)
 		if err != nil {
 			return nil, nil, cli.Exit(fmt.Errorf("cannot create HTTP transport: %w", err), 1)
 		}
+	case "websocket":
+		var err error
+		transporter, err = transport.NewWebSocketTransport(
+			config.DefaultConfig.ClientID,
+			config.DefaultConfig.Server[0],
+			tlsConfig,
+			UserAgent,
+		)
+		if err != nil {
+			return nil, nil, cli.Exit(fmt.Errorf("cannot create WebSocket transport: %w", err), 1)
+		}
 	case "none":
 		var err error
 		transporter, err = transport.NewNoopTransport()
@@ -167,6 +236,16 @@ func setupClient(
 		)
 	}
 	client := NewClient(dispatcher, transporter)
+	client.outboundQueue = outboundQueue
+	client.dedupCache = dedupCache
+	client.verifier = verifier
+	client.policy = authPolicy
+	if len(config.DefaultConfig.ForwardEvents) > 0 {
+		client.forwarder = work.NewEventForwarder(
+			config.DefaultConfig.ForwardEvents,
+			config.DefaultConfig.ForwardInterval,
+		)
+	}
 	if err := client.Connect(); err != nil {
 		return nil, nil, cli.Exit(fmt.Errorf("cannot connect client: %w", err), 1)
 	}
@@ -196,6 +275,158 @@ func setupMessageJournal(client *Client) error {
 	return nil
 }
 
+// setupOutboundQueue tries to set up an outbound queue database in the state
+// directory, if the outbound queue is enabled. Messages that cannot be
+// transmitted while the transport is disconnected are stored in the queue.
+func setupOutboundQueue() (*outboundqueue.OutboundQueue, error) {
+	if !config.DefaultConfig.OutboundQueue {
+		return nil, nil
+	}
+	if err := os.MkdirAll(constants.StateDir, 0750); err != nil {
+		return nil, cli.Exit(fmt.Errorf("cannot create directory: %w", err), 1)
+	}
+	queueFilePath := filepath.Join(constants.StateDir, "outbound-queue.db")
+	queue, err := outboundqueue.Open(
+		queueFilePath,
+		config.DefaultConfig.OutboundQueueMaxSize,
+		config.DefaultConfig.OutboundQueueMaxAge,
+	)
+	if err != nil {
+		return nil, cli.Exit(
+			fmt.Errorf(
+				"cannot initialize outbound queue database at '%v': %w",
+				queueFilePath,
+				err,
+			),
+			1,
+		)
+	}
+	log.Debugf("initialized outbound queue at '%v'", queueFilePath)
+	return queue, nil
+}
+
+// setupDeadLetterStore sets up a dead-letter database in the state directory,
+// if the dead-letter store is enabled. Messages that cannot be dispatched to a
+// worker after repeated attempts are stored in the database.
+func setupDeadLetterStore(dispatcher *work.Dispatcher) error {
+	if !config.DefaultConfig.DeadLetter {
+		return nil
+	}
+	if err := os.MkdirAll(constants.StateDir, 0750); err != nil {
+		return cli.Exit(fmt.Errorf("cannot create directory: %w", err), 1)
+	}
+	storeFilePath := filepath.Join(constants.StateDir, "dead-letters.db")
+	store, err := deadletter.Open(
+		storeFilePath,
+		config.DefaultConfig.DeadLetterMaxSize,
+		config.DefaultConfig.DeadLetterMaxAge,
+	)
+	if err != nil {
+		return cli.Exit(
+			fmt.Errorf(
+				"cannot initialize dead-letter database at '%v': %w",
+				storeFilePath,
+				err,
+			),
+			1,
+		)
+	}
+	dispatcher.DeadLetters = store
+	log.Debugf("initialized dead-letter store at '%v'", storeFilePath)
+	return nil
+}
+
+// setupScheduler sets up a scheduler database in the state directory. Data
+// messages with schedule metadata are held in it until they are due.
+func setupScheduler(dispatcher *work.Dispatcher) error {
+	if err := os.MkdirAll(constants.StateDir, 0750); err != nil {
+		return cli.Exit(fmt.Errorf("cannot create directory: %w", err), 1)
+	}
+	schedulerFilePath := filepath.Join(constants.StateDir, "schedule.db")
+	scheduler, err := schedule.Open(
+		schedulerFilePath,
+		dispatcher.Enqueue,
+		dispatcher.ScheduleFailed,
+		config.DefaultConfig.ScheduleMaxEntries,
+	)
+	if err != nil {
+		return cli.Exit(
+			fmt.Errorf(
+				"cannot initialize schedule database at '%v': %w",
+				schedulerFilePath,
+				err,
+			),
+			1,
+		)
+	}
+	dispatcher.Scheduler = scheduler
+	log.Debugf("initialized scheduler at '%v'", schedulerFilePath)
+	return nil
+}
+
+// setupDedupCache sets up a cache of received message IDs, if deduplication
+// is enabled. If persistence is enabled, the cache is stored in a database in
+// the state directory.
+func setupDedupCache() (*dedup.Cache, error) {
+	if !config.DefaultConfig.Dedup {
+		return nil, nil
+	}
+	if !config.DefaultConfig.DedupPersist {
+		return dedup.New(config.DefaultConfig.DedupMaxSize, config.DefaultConfig.DedupWindow), nil
+	}
+	if err := os.MkdirAll(constants.StateDir, 0750); err != nil {
+		return nil, cli.Exit(fmt.Errorf("cannot create directory: %w", err), 1)
+	}
+	dedupFilePath := filepath.Join(constants.StateDir, "dedup.db")
+	cache, err := dedup.Open(
+		dedupFilePath,
+		config.DefaultConfig.DedupMaxSize,
+		config.DefaultConfig.DedupWindow,
+	)
+	if err != nil {
+		return nil, cli.Exit(
+			fmt.Errorf("cannot initialize dedup database at '%v': %w", dedupFilePath, err),
+			1,
+		)
+	}
+	log.Debugf("initialized dedup database at '%v'", dedupFilePath)
+	return cache, nil
+}
+
+// setupSignatureVerifier sets up a verifier of message signatures, if any
+// trusted keys or certificate authorities are configured.
+func setupSignatureVerifier() (*signature.Verifier, error) {
+	if len(config.DefaultConfig.SignatureKey) == 0 &&
+		len(config.DefaultConfig.SignatureCARoot) == 0 &&
+		len(config.DefaultConfig.SignatureRequire) == 0 {
+		return nil, nil
+	}
+	// Required directives are compared with the scrubbed names of the
+	// workers a message is dispatched to.
+	var required []string
+	for _, name := range config.DefaultConfig.SignatureRequire {
+		name, err := work.ScrubName(name)
+		if err != nil {
+			log.Debug(err)
+		}
+		required = append(required, name)
+	}
+	verifier, err := signature.NewVerifier(
+		config.DefaultConfig.SignatureKey,
+		config.DefaultConfig.SignatureCARoot,
+		required,
+		config.DefaultConfig.SignatureReplayWindow,
+	)
+	if err != nil {
+		return nil, cli.Exit(fmt.Errorf("cannot initialize signature verifier: %w", err), 1)
+	}
+	log.Debugf(
+		"initialized signature verifier requiring signatures for %v",
+		config.DefaultConfig.SignatureRequire,
+	)
+	return verifier, nil
+}
+
 // setupTLS tries to set up new TLS config and HTTP client
 func setupTLS() (*http.Client, *tls.Config, error) {
 	tlsConfig, err := config.DefaultConfig.CreateTLSConfig()
@@ -278,6 +509,61 @@ func monitorTags(client *Client) {
 	}
 }
 
+// setupPolicy loads the authorization policy for messages received from the
+// server from the policy file in the configuration directory.
+func setupPolicy() (*policy.Policy, error) {
+	policyFilePath := filepath.Join(constants.ConfigDir, "policy.toml")
+	p, err := policy.Load(policyFilePath)
+	if err != nil {
+		return nil, cli.Exit(
+			fmt.Errorf("cannot load policy file '%v': %w", policyFilePath, err),
+			1,
+		)
+	}
+	return p, nil
+}
+
+// monitorConfigFile tries to monitor the file with the given name in the
+// configuration directory for changes, calling reload when it changes.
+func monitorConfigFile(name string, reload func() error) {
+	c := make(chan notify.EventInfo, 1)
+
+	fp := filepath.Join(constants.ConfigDir, name)
+
+	if err := notify.Watch(fp, c, notify.InCloseWrite, notify.InDelete); err != nil {
+		log.Infof("cannot start watching '%v': %v", fp, err)
+		return
+	}
+	defer notify.Stop(c)
+
+	for e := range c {
+		log.Debugf("received inotify event %v", e.Event())
+		switch e.Event() {
+		case notify.InCloseWrite, notify.InDelete:
+			if err := reload(); err != nil {
+				log.Errorf("cannot reload '%v': %v", fp, err)
+				continue
+			}
+			log.Infof("reloaded file '%v'", fp)
+		}
+	}
+}
+
+// setupRouter loads the routing table for messages received from the server
+// from the routes file in the configuration directory.
+func setupRouter(dispatcher *work.Dispatcher) error {
+	routesFilePath := filepath.Join(constants.ConfigDir, "routes.toml")
+	table, err := routing.Load(routesFilePath)
+	if err != nil {
+		return cli.Exit(
+			fmt.Errorf("cannot load routing table file '%v': %w", routesFilePath, err),
+			1,
+		)
+	}
+	dispatcher.Router = table
+	return nil
+}
+
 // monitorCertificate tries to monitor certificate file for changes
 func monitorCertificate(
 	TlSEvents chan *tls.Config,
@@ -366,12 +652,79 @@ func mainAction(c *cli.Context) error {
 	}
 
 	// Create Dispatcher service
+	switch config.DefaultConfig.DispatchQueueFull {
+	case work.QueueFullReject, work.QueueFullSpill:
+	default:
+		return cli.Exit(
+			fmt.Errorf(
+				"unsupported dispatch queue full action: %v",
+				config.DefaultConfig.DispatchQueueFull,
+			),
+			1,
+		)
+	}
 	dispatcher := work.NewDispatcher(httpClient)
 
+	// Create a dead-letter store if it is enabled in the config. Messages
+	// that cannot be dispatched after repeated attempts are moved to it.
+	if err := setupDeadLetterStore(dispatcher); err != nil {
+		return err
+	}
+
+	// Create a scheduler holding messages that request to be dispatched at
+	// a later time.
+	if err := setupScheduler(dispatcher); err != nil {
+		return err
+	}
+
+	// Load the routing table deciding which workers messages received from
+	// the server are dispatched to.
+	if err := setupRouter(dispatcher); err != nil {
+		return err
+	}
+
+	// Create an outbound queue if it is enabled in the config. The outbound
+	// queue stores messages that cannot be transmitted while the transport is
+	// disconnected and transmits them once the connection is restored.
+	outboundQueue, err := setupOutboundQueue()
+	if err != nil {
+		return err
+	}
+
+	// Create a cache of received message IDs if deduplication is enabled.
+	// Data messages already received are dropped instead of being dispatched
+	// to workers a second time.
+	dedupCache, err := setupDedupCache()
+	if err != nil {
+		return err
+	}
+
+	// Create a signature verifier if trusted signing keys are configured.
+	// Messages with invalid signatures, or without signatures when required,
+	// are rejected before they are processed.
+	verifier, err := setupSignatureVerifier()
+	if err != nil {
+		return err
+	}
+
+	// Load the authorization policy deciding which messages received from
+	// the server may be dispatched to workers.
+	authPolicy, err := setupPolicy()
+	if err != nil {
+		return err
+	}
+
 	// Create Transporter service (it could be HTTP or MQTT according to configuration)
 	// This also starts probably the most important goroutine waiting for messages
 	// from the Transporter
-	client, transporter, err := setupClient(dispatcher, tlsConfig)
+	client, transporter, err := setupClient(
+		dispatcher,
+		tlsConfig,
+		outboundQueue,
+		dedupCache,
+		verifier,
+		authPolicy,
+	)
 	if err != nil {
 		return cli.Exit(fmt.Errorf("cannot setup client: %w", err), 1)
 	}
@@ -408,6 +761,11 @@ func mainAction(c *cli.Context) error {
 	// publishes connection status messages when the file changes.
 	go monitorTags(client)
 
+	// Start goroutines that watch the policy and routing table files for
+	// write events and reload them when the files change.
+	go monitorConfigFile("policy.toml", client.policy.Reload)
+	go monitorConfigFile("routes.toml", dispatcher.Router.Reload)
+
 	// Start a goroutine that sends notifications to systemd
 	go systemdWatchDog()
 
@@ -488,7 +846,7 @@ func main() {
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:  config.FlagNameProtocol,
-			Usage: "Transmit data remotely using `PROTOCOL` ('mqtt', 'http' or 'none')",
+			Usage: "Transmit data using `PROTOCOL` ('mqtt', 'mqtt5', 'http', 'websocket', 'none')",
 			Value: "mqtt",
 		}),
 		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
@@ -527,6 +885,30 @@ func main() {
 			Usage:  "Wait for `DURATION` before cancelling an HTTP request",
 			Hidden: true,
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameHTTPPollingInterval,
+			Usage:  "Poll for new messages every `DURATION` when using the HTTP protocol",
+			Value:  5 * time.Second,
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameHTTPLongPollWait,
+			Usage:  "Ask the server to hold HTTP polling requests open for `DURATION`",
+			Value:  60 * time.Second,
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameHTTPMaxBackoff,
+			Usage:  "Wait at most `DURATION` before retrying a failed HTTP polling request",
+			Value:  5 * time.Minute,
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameHTTPFailoverCooldown,
+			Usage:  "Stop sending HTTP requests to a failed server for `DURATION`",
+			Value:  5 * time.Minute,
+			Hidden: true,
+		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:   config.FlagNameMQTTConnectRetry,
 			Usage:  "Enable automatic reconnection logic when the client initially connects",
@@ -563,10 +945,183 @@ func main() {
 			Value:  30 * time.Second,
 			Hidden: true,
 		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:   config.FlagNameMQTTFragmentSize,
+			Usage:  "Publish MQTT messages larger than `BYTES` as a series of chunks",
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameMQTTFragmentTimeout,
+			Usage:  "Discard fragmented messages not fully received within `DURATION`",
+			Value:  5 * time.Minute,
+			Hidden: true,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:  config.FlagNameMessageJournal,
 			Usage: "Record worker events and messages in the database `FILE`",
 		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:  config.FlagNameOutboundQueue,
+			Usage: "Queue messages for later transmission while disconnected",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:   config.FlagNameOutboundQueueMaxSize,
+			Usage:  "Hold at most `N` messages in the outbound queue",
+			Value:  1000,
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameOutboundQueueMaxAge,
+			Usage:  "Drop messages held in the outbound queue for longer than `DURATION`",
+			Value:  24 * time.Hour,
+			Hidden: true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:  config.FlagNameDedup,
+			Usage: "Drop data messages that have already been received",
+			Value: true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:  config.FlagNameDedupPersist,
+			Usage: "Remember received message IDs across restarts",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:   config.FlagNameDedupMaxSize,
+			Usage:  "Remember at most `N` received message IDs",
+			Value:  10000,
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameDedupWindow,
+			Usage:  "Remember received message IDs for `DURATION`",
+			Value:  24 * time.Hour,
+			Hidden: true,
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:      config.FlagNameSignatureKey,
+			Usage:     "Trust message signatures made by the public key in `FILE`",
+			TakesFile: true,
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:      config.FlagNameSignatureCARoot,
+			Usage:     "Trust message signing certificates issued by the CA in `FILE`",
+			TakesFile: true,
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:  config.FlagNameSignatureRequire,
+			Usage: "Reject unsigned messages for `DIRECTIVE` ('*' for all, '@control' for control)",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameSignatureReplayWindow,
+			Usage:  "Reject signed messages sent over `DURATION` from now or repeated within it",
+			Value:  1 * time.Hour,
+			Hidden: true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:   config.FlagNameDispatchConcurrency,
+			Usage:  "Dispatch up to `N` messages to each worker at the same time",
+			Value:  1,
+			Hidden: true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:   config.FlagNameDispatchQueueDepth,
+			Usage:  "Hold up to `N` messages for each worker waiting to be dispatched",
+			Value:  100,
+			Hidden: true,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  config.FlagNameDispatchQueueFull,
+			Usage: "Handle messages for a full dispatch queue with `ACTION` ('reject', 'spill')",
+			Value: work.QueueFullReject,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:   config.FlagNameDispatchMaxAttempts,
+			Usage:  "Attempt to dispatch a message to a worker up to `N` times",
+			Value:  5,
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameDispatchRetryDelay,
+			Usage:  "Wait `DURATION` before retrying to dispatch a message",
+			Value:  1 * time.Second,
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameActivationTimeout,
+			Usage:  "Wait up to `DURATION` for a worker started by D-Bus activation",
+			Value:  30 * time.Second,
+			Hidden: true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:   config.FlagNameContentCacheMaxSize,
+			Usage:  "Cache at most `N` megabytes of downloaded message content",
+			Value:  1024,
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameContentCacheMaxAge,
+			Usage:  "Remove cached message content unused for `DURATION`",
+			Value:  24 * time.Hour,
+			Hidden: true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:  config.FlagNameDeadLetter,
+			Usage: "Store messages that cannot be dispatched to a worker for later inspection",
+			Value: true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:   config.FlagNameDeadLetterMaxSize,
+			Usage:  "Hold at most `N` messages in the dead-letter store",
+			Value:  1000,
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameDeadLetterMaxAge,
+			Usage:  "Drop messages held in the dead-letter store for longer than `DURATION`",
+			Value:  7 * 24 * time.Hour,
+			Hidden: true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:   config.FlagNameScheduleMaxEntries,
+			Usage:  "Hold at most `N` messages scheduled to be dispatched later",
+			Value:  1000,
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  config.FlagNameMessageMaxAge,
+			Usage: "Drop messages sent more than `DURATION` ago instead of dispatching them",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameClockSkew,
+			Usage:  "Tolerate clocks differing by `DURATION` when checking message expiry",
+			Value:  5 * time.Minute,
+			Hidden: true,
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:  config.FlagNameForwardEvents,
+			Usage: "Forward events of the worker for `DIRECTIVE` to the server ('*' for all)",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  config.FlagNameForwardInterval,
+			Usage: "Forward at most one WORKING event per message every `DURATION`",
+			Value: 5 * time.Second,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameTransmitTimeout,
+			Usage:  "Sets the time to wait for a worker message to be sent to `DURATION`",
+			Value:  1 * time.Second,
+			Hidden: true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:  config.FlagNameTransmitAck,
+			Usage: "Wait for the server to acknowledge messages transmitted by workers",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   config.FlagNameTransmitAckTimeout,
+			Usage:  "Sets the time to wait for the server to acknowledge a message to `DURATION`",
+			Value:  30 * time.Second,
+			Hidden: true,
+		}),
 	}
 
 	app.EnableBashCompletion = true
@@ -24,13 +24,49 @@ const (
 	FlagNameFactsFile                = "facts-file"
 	FlagNameHTTPRetries              = "http-retries"
 	FlagNameHTTPTimeout              = "http-timeout"
+	FlagNameHTTPPollingInterval      = "http-polling-interval"
+	FlagNameHTTPLongPollWait         = "http-long-poll-wait"
+	FlagNameHTTPMaxBackoff           = "http-max-backoff"
+	FlagNameHTTPFailoverCooldown     = "http-failover-cooldown"
 	FlagNameMQTTConnectRetry         = "mqtt-connect-retry"
 	FlagNameMQTTConnectRetryInterval = "mqtt-connect-retry-interval"
 	FlagNameMQTTAutoReconnect        = "mqtt-auto-reconnect"
 	FlagNameMQTTReconnectDelay       = "mqtt-reconnect-delay"
 	FlagNameMQTTConnectTimeout       = "mqtt-connect-timeout"
 	FlagNameMQTTPublishTimeout       = "mqtt-publish-timeout"
+	FlagNameMQTTFragmentSize         = "mqtt-fragment-size"
+	FlagNameMQTTFragmentTimeout      = "mqtt-fragment-timeout"
 	FlagNameMessageJournal           = "message-journal"
+	FlagNameOutboundQueue            = "outbound-queue"
+	FlagNameOutboundQueueMaxSize     = "outbound-queue-max-size"
+	FlagNameOutboundQueueMaxAge      = "outbound-queue-max-age"
+	FlagNameDedup                    = "dedup"
+	FlagNameDedupPersist             = "dedup-persist"
+	FlagNameDedupMaxSize             = "dedup-max-size"
+	FlagNameDedupWindow              = "dedup-window"
+	FlagNameSignatureKey             = "signature-key"
+	FlagNameSignatureCARoot          = "signature-ca-root"
+	FlagNameSignatureRequire         = "signature-require"
+	FlagNameSignatureReplayWindow    = "signature-replay-window"
+	FlagNameDispatchConcurrency      = "dispatch-concurrency"
+	FlagNameDispatchQueueDepth       = "dispatch-queue-depth"
+	FlagNameDispatchQueueFull        = "dispatch-queue-full"
+	FlagNameDispatchMaxAttempts      = "dispatch-max-attempts"
+	FlagNameDispatchRetryDelay       = "dispatch-retry-delay"
+	FlagNameActivationTimeout        = "activation-timeout"
+	FlagNameContentCacheMaxSize      = "content-cache-max-size"
+	FlagNameContentCacheMaxAge       = "content-cache-max-age"
+	FlagNameDeadLetter               = "dead-letter"
+	FlagNameDeadLetterMaxSize        = "dead-letter-max-size"
+	FlagNameDeadLetterMaxAge         = "dead-letter-max-age"
+	FlagNameScheduleMaxEntries       = "schedule-max-entries"
+	FlagNameMessageMaxAge            = "message-max-age"
+	FlagNameClockSkew                = "clock-skew"
+	FlagNameForwardEvents            = "forward-events"
+	FlagNameForwardInterval          = "forward-events-interval"
+	FlagNameTransmitTimeout          = "transmit-timeout"
+	FlagNameTransmitAck              = "transmit-ack"
+	FlagNameTransmitAckTimeout       = "transmit-ack-timeout"
 )
 
 var DefaultConfig = Config{
@@ -46,7 +82,10 @@ type Config struct {
 	// transports.
 	ClientID string
 
-	// Server is a URI to which yggd connects in order to send and receive data.
+	// Server is a list of URIs to which yggd connects in order to send and
+	// receive data. The MQTT transports use every server as a broker. The HTTP
+	// transport uses the first healthy server, in the order given, failing over
+	// to the next one when a server fails.
 	Server []string
 
 	// CertFile is a path to a public certificate, optionally used along with
@@ -65,7 +104,7 @@ type Config struct {
 	PathPrefix string
 
 	// Protocol is the protocol used by yggd when connecting to Server. Can be
-	// either MQTT or HTTP.
+	// either MQTT, MQTT5, HTTP or WebSocket.
 	Protocol string
 
 	// DataHost is a hostname value to interject into all HTTP requests when
@@ -84,6 +123,27 @@ type Config struct {
 	// HTTP request.
 	HTTPTimeout time.Duration
 
+	// HTTPPollingInterval is the duration the HTTP transport waits between
+	// requests for new messages, unless the server holds requests open
+	// (long-polling) or streams messages as server-sent events.
+	HTTPPollingInterval time.Duration
+
+	// HTTPLongPollWait is the duration the HTTP transport asks the server to
+	// hold a request open while waiting for a new message. A value of zero
+	// disables long-polling.
+	HTTPLongPollWait time.Duration
+
+	// HTTPMaxBackoff is the maximum duration the HTTP transport waits before
+	// retrying after a failed request. The wait starts at the polling interval
+	// and doubles after each consecutive failure.
+	HTTPMaxBackoff time.Duration
+
+	// HTTPFailoverCooldown is the duration the HTTP transport stops sending
+	// requests to a server after it fails. Once the cooldown has passed, the
+	// transport fails back to the server if it is listed before the active
+	// server.
+	HTTPFailoverCooldown time.Duration
+
 	// MQTTConnectRetry is the MQTT client option to enable connection retry
 	// logic when performing the initial connection.
 	MQTTConnectRetry bool
@@ -108,9 +168,160 @@ type Config struct {
 	// connection to publish a message before giving up.
 	MQTTPublishTimeout time.Duration
 
+	// MQTTFragmentSize is the maximum size, in bytes, of a message published to
+	// an MQTT broker. Larger payloads are split into a manifest followed by
+	// numbered chunks, each no larger than MQTTFragmentSize. A value of zero
+	// disables fragmentation.
+	MQTTFragmentSize int
+
+	// MQTTFragmentTimeout is the duration the client will wait to receive all
+	// chunks of a fragmented message before discarding it.
+	MQTTFragmentTimeout time.Duration
+
 	// MessageJournal is used to enable the storage of worker events
 	// and message data in a SQLite file at the specified file path.
 	MessageJournal string
+
+	// OutboundQueue enables storing messages that cannot be transmitted while
+	// the transport is disconnected in a SQLite file in the state directory,
+	// transmitting them once the transport reconnects.
+	OutboundQueue bool
+
+	// OutboundQueueMaxSize is the maximum number of messages held in the
+	// outbound queue. When full, the oldest messages are dropped.
+	OutboundQueueMaxSize int
+
+	// OutboundQueueMaxAge is the duration a message is held in the outbound
+	// queue before it is dropped.
+	OutboundQueueMaxAge time.Duration
+
+	// Dedup enables dropping data messages with a message ID that has already
+	// been received, rather than dispatching them to workers again.
+	Dedup bool
+
+	// DedupPersist enables storing the IDs of received messages in a SQLite
+	// file in the state directory, so that duplicates are detected across
+	// restarts.
+	DedupPersist bool
+
+	// DedupMaxSize is the maximum number of message IDs remembered for
+	// detecting duplicates. When full, the oldest IDs are forgotten.
+	DedupMaxSize int
+
+	// DedupWindow is the duration a message ID is remembered for detecting
+	// duplicates.
+	DedupWindow time.Duration
+
+	// SignatureKey is the list of paths to PEM files containing public keys
+	// trusted to sign messages received from the server.
+	SignatureKey []string
+
+	// SignatureCARoot is the list of paths to PEM files containing
+	// certificate authorities trusted to issue message signing certificates.
+	SignatureCARoot []string
+
+	// SignatureRequire is the list of directives for which data messages are
+	// rejected unless they carry a valid signature. The value "*" requires
+	// signatures on all data messages and the value "@control" requires
+	// signatures on control messages.
+	SignatureRequire []string
+
+	// SignatureReplayWindow is the duration before or after the current time
+	// within which a signed message must have been sent. Signed messages sent
+	// outside the window, or with the ID of a signed message already received
+	// within it, are rejected, since their signature may have been captured
+	// from an earlier message. A value of zero disables both checks.
+	SignatureReplayWindow time.Duration
+
+	// DispatchConcurrency is the number of messages dispatched to the worker
+	// of each directive at the same time.
+	DispatchConcurrency int
+
+	// DispatchQueueDepth is the number of messages held for each directive
+	// while waiting to be dispatched.
+	DispatchQueueDepth int
+
+	// DispatchQueueFull decides what happens to a message for a directive
+	// whose dispatch queue is full: "reject" rejects the message, informing
+	// the server, and "spill" stores the message in the state directory until
+	// the queue has room.
+	DispatchQueueFull string
+
+	// DispatchMaxAttempts is the number of times dispatching a message
+	// received from the server is attempted before the message is moved to
+	// the dead-letter store.
+	DispatchMaxAttempts int
+
+	// DispatchRetryDelay is the duration to wait before retrying to dispatch
+	// a message. The delay doubles after each failed retry.
+	DispatchRetryDelay time.Duration
+
+	// ActivationTimeout is the duration to wait for a worker started with
+	// D-Bus activation to acquire its name on the bus.
+	ActivationTimeout time.Duration
+
+	// ContentCacheMaxSize is the maximum size in megabytes of the detached
+	// message content cached in the cache directory. When full, the least
+	// recently used content is removed. A value of zero disables the limit.
+	ContentCacheMaxSize int
+
+	// ContentCacheMaxAge is the duration after which cached detached message
+	// content that has not been used is removed. A value of zero disables the
+	// limit.
+	ContentCacheMaxAge time.Duration
+
+	// DeadLetter enables storing messages that could not be dispatched in a
+	// SQLite file in the state directory.
+	DeadLetter bool
+
+	// DeadLetterMaxSize is the maximum number of messages held in the
+	// dead-letter store. When full, the oldest messages are dropped.
+	DeadLetterMaxSize int
+
+	// DeadLetterMaxAge is the duration a message is held in the dead-letter
+	// store before it is dropped.
+	DeadLetterMaxAge time.Duration
+
+	// ScheduleMaxEntries is the maximum number of messages held by the
+	// scheduler. Messages scheduled while it is full are rejected.
+	ScheduleMaxEntries int
+
+	// MessageMaxAge is the duration after a message received from the server
+	// was sent at which it expires, unless the message expires sooner. A value
+	// of zero disables the limit.
+	MessageMaxAge time.Duration
+
+	// ClockSkew is the duration by which the clocks of the client and server
+	// are allowed to differ when deciding whether a message has expired. A
+	// message that expires is rejected if it was sent more than ClockSkew in
+	// the future. ClockSkew does not extend the lifetime of a message.
+	ClockSkew time.Duration
+
+	// ForwardEvents is the list of directives for which the BEGIN, WORKING
+	// and END events emitted by the worker are published to the server as
+	// "worker-event" Event messages. The value "*" forwards the events of all
+	// workers.
+	ForwardEvents []string
+
+	// ForwardInterval is the minimum duration between WORKING events
+	// forwarded to the server for the same message. WORKING events received
+	// sooner are dropped.
+	ForwardInterval time.Duration
+
+	// TransmitTimeout is the duration the dispatcher will wait for the client
+	// to send a message transmitted by a worker before returning an error.
+	TransmitTimeout time.Duration
+
+	// TransmitAck enables waiting for the server to acknowledge each data
+	// message transmitted by a worker. The server acknowledges a message by
+	// publishing a "response" or "event" control message with a "response_to"
+	// value matching the ID of the transmitted message. This is useful for
+	// transports (such as MQTT) that otherwise do not return a response.
+	TransmitAck bool
+
+	// TransmitAckTimeout is the duration the client will wait for the server
+	// to acknowledge a transmitted data message before giving up.
+	TransmitAckTimeout time.Duration
 }
 
 // CreateTLSConfig creates a tls.Config object from the current configuration.
@@ -0,0 +1,546 @@
+package content
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	internalhttp "github.com/redhatinsights/yggdrasil/internal/http"
+)
+
+const (
+	// MetadataKeySHA256 is the metadata key of the hex-encoded SHA-256 digest
+	// the detached content of a message must have.
+	MetadataKeySHA256 = "content-sha256"
+
+	// MetadataKeyLength is the metadata key of the length in bytes the
+	// detached content of a message must have.
+	MetadataKeyLength = "content-length"
+)
+
+var (
+	// ErrInvalidMetadata is returned when the content-sha256 or content-length
+	// metadata values of a message cannot be parsed.
+	ErrInvalidMetadata = errors.New("invalid content metadata")
+
+	// ErrMismatch is returned when downloaded content does not have the
+	// digest or length given in the metadata of a message.
+	ErrMismatch = errors.New("content does not match metadata")
+)
+
+var sha256Pattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// expectation holds the digest and length that content must have. An empty
+// digest or a negative length is not checked.
+type expectation struct {
+	digest string
+	length int64
+}
+
+// parseExpectation reads the expected digest and length of content from
+// message metadata.
+func parseExpectation(metadata map[string]string) (expectation, error) {
+	want := expectation{length: -1}
+
+	if value, has := metadata[MetadataKeySHA256]; has {
+		want.digest = strings.ToLower(strings.TrimSpace(value))
+		if !sha256Pattern.MatchString(want.digest) {
+			return want, fmt.Errorf("%w: %v: %v", ErrInvalidMetadata, MetadataKeySHA256, value)
+		}
+	}
+
+	if value, has := metadata[MetadataKeyLength]; has {
+		length, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || length < 0 {
+			return want, fmt.Errorf("%w: %v: %v", ErrInvalidMetadata, MetadataKeyLength, value)
+		}
+		want.length = length
+	}
+
+	return want, nil
+}
+
+// check returns an error wrapping ErrMismatch if digest or length differ from
+// the expected values.
+func (want expectation) check(digest string, length int64) error {
+	if want.digest != "" && want.digest != digest {
+		return fmt.Errorf(
+			"%w: %v is %v, want %v",
+			ErrMismatch,
+			MetadataKeySHA256,
+			digest,
+			want.digest,
+		)
+	}
+	if want.length >= 0 && want.length != length {
+		return fmt.Errorf(
+			"%w: %v is %v, want %v",
+			ErrMismatch,
+			MetadataKeyLength,
+			length,
+			want.length,
+		)
+	}
+	return nil
+}
+
+// entry records what is known about the content of a URL.
+type entry struct {
+	URL string `json:"url"`
+
+	// ETag and Digest identify the last complete download of the URL.
+	ETag   string `json:"etag,omitempty"`
+	Digest string `json:"digest,omitempty"`
+
+	// PartialETag identifies the content of an incomplete download of the URL.
+	PartialETag string `json:"partial_etag,omitempty"`
+}
+
+// keyLock is a mutex shared by the fetches of a single URL.
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Cache stores the detached content of messages downloaded from remote
+// locations. Complete downloads are stored under their SHA-256 digest, along
+// with the ETag of the URL they were downloaded from, so that content that has
+// not changed is not downloaded again. Incomplete downloads are kept and
+// resumed with a Range request the next time the content is fetched.
+//
+// Content that was not used for longer than the maximum age is removed, and
+// the least recently used content is removed while the cache is larger than
+// the maximum size. Content that is not pinned by a content-sha256 metadata
+// value is removed by Release once it has been dispatched.
+type Cache struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	locks   map[string]*keyLock
+	evictMu sync.Mutex
+}
+
+// NewCache creates a Cache that stores content in dir. The directory is
+// created when content is first fetched. The cache holds at most maxSize bytes
+// of content, and content that was not used for maxAge is removed. A value of
+// zero disables either limit.
+func NewCache(dir string, maxSize int64, maxAge time.Duration) *Cache {
+	return &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		now:     time.Now,
+		locks:   make(map[string]*keyLock),
+	}
+}
+
+// Fetch returns an open file holding the content at url, downloading it with
+// client unless an up to date copy is cached. If metadata includes the
+// content-sha256 or content-length keys, the content is checked against them
+// and an error wrapping ErrMismatch is returned if it does not match. The
+// caller must close the returned file.
+func (c *Cache) Fetch(
+	client *internalhttp.Client,
+	url string,
+	metadata map[string]string,
+) (*os.File, error) {
+	want, err := parseExpectation(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	key := sha256Hex(url)
+	unlock := c.lock(key)
+	defer unlock()
+
+	for _, dir := range []string{"blobs", "urls", "partial"} {
+		if err := os.MkdirAll(filepath.Join(c.dir, dir), 0700); err != nil {
+			return nil, fmt.Errorf("cannot create cache directory: %w", err)
+		}
+	}
+
+	// Content with a known digest that is already cached is not downloaded
+	// again, wherever it was downloaded from.
+	if want.digest != "" {
+		f, err := c.openBlob(want.digest, want)
+		if err == nil || errors.Is(err, ErrMismatch) {
+			log.Debugf("using cached content %v for %v", want.digest, url)
+			c.evict()
+			return f, err
+		}
+	}
+
+	e, err := c.readEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	e.URL = url
+
+	headers := make(map[string]string)
+	if e.ETag != "" && c.hasBlob(e.Digest) {
+		headers["If-None-Match"] = e.ETag
+	}
+
+	partial := filepath.Join(c.dir, "partial", key)
+	var offset int64
+	if info, err := os.Stat(partial); err == nil && e.PartialETag != "" {
+		offset = info.Size()
+	}
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%v-", offset)
+		headers["If-Range"] = e.PartialETag
+	}
+
+	resp, err := client.Get(url, headers)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Errorf("cannot close response body: %v", err)
+		}
+	}()
+
+	etag := resp.Header.Get("ETag")
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if headers["If-None-Match"] == "" {
+			return nil, fmt.Errorf("unexpected response status: %v", resp.Status)
+		}
+		log.Debugf("using cached content %v for %v", e.Digest, url)
+		f, err := c.openBlob(e.Digest, want)
+		c.evict()
+		return f, err
+	case http.StatusOK:
+		offset = 0
+	case http.StatusPartialContent:
+		start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			c.removePartial(key, e)
+			return nil, fmt.Errorf(
+				"unexpected content range: %v",
+				resp.Header.Get("Content-Range"),
+			)
+		}
+		if etag == "" {
+			etag = e.PartialETag
+		}
+		log.Debugf("resuming download of %v at byte %v", url, offset)
+	case http.StatusRequestedRangeNotSatisfiable:
+		c.removePartial(key, e)
+		return nil, fmt.Errorf("cannot resume download: %v", resp.Status)
+	default:
+		return nil, fmt.Errorf("unexpected response status: %v", resp.Status)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(partial, flags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open partial download: %w", err)
+	}
+
+	// Record the ETag of the partial download before it is written, so that
+	// an interrupted download can be resumed.
+	e.PartialETag = etag
+	if err := c.writeEntry(key, e); err != nil {
+		log.Errorf("cannot write cache entry: %v", err)
+	}
+
+	// Content longer than the expected length is not downloaded in full.
+	var body io.Reader = resp.Body
+	if want.length >= 0 {
+		body = io.LimitReader(resp.Body, want.length-offset+1)
+	}
+	_, copyErr := io.Copy(f, body)
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return nil, fmt.Errorf("cannot download content: %w", copyErr)
+	}
+
+	digest, length, err := hashFile(partial)
+	if err != nil {
+		return nil, err
+	}
+	if err := want.check(digest, length); err != nil {
+		c.removePartial(key, e)
+		return nil, err
+	}
+
+	blob := filepath.Join(c.dir, "blobs", digest)
+	if err := os.Rename(partial, blob); err != nil {
+		return nil, fmt.Errorf("cannot store content: %w", err)
+	}
+	e.ETag = etag
+	e.Digest = digest
+	e.PartialETag = ""
+	if err := c.writeEntry(key, e); err != nil {
+		log.Errorf("cannot write cache entry: %v", err)
+	}
+	log.Debugf("cached content %v for %v", digest, url)
+
+	// The blob is opened before the cache is trimmed, so that the content
+	// can be read even if it is removed to make room.
+	result, err := c.openBlob(digest, want)
+	c.evict()
+	return result, err
+}
+
+// Release removes the cached content of url once it has been used to dispatch
+// a message, whether or not the dispatch succeeded, unless metadata pins it
+// with the content-sha256 key. Content without a digest pin cannot be shared
+// with other messages, so it is not kept after dispatch.
+func (c *Cache) Release(url string, metadata map[string]string) {
+	want, err := parseExpectation(metadata)
+	if err != nil || want.digest != "" {
+		return
+	}
+
+	key := sha256Hex(url)
+	unlock := c.lock(key)
+	defer unlock()
+
+	e, err := c.readEntry(key)
+	if err != nil {
+		log.Errorf("cannot release cached content of %v: %v", url, err)
+		return
+	}
+	if e.Digest == "" {
+		return
+	}
+	c.removeBlob(e.Digest)
+	e.ETag = ""
+	e.Digest = ""
+	if err := c.writeEntry(key, e); err != nil {
+		log.Errorf("cannot write cache entry: %v", err)
+	}
+	log.Debugf("released cached content of %v", url)
+}
+
+// evict removes the content and incomplete downloads that were not used for
+// longer than the maximum age, and then the least recently used content until
+// the cache is no larger than the maximum size.
+func (c *Cache) evict() {
+	c.evictMu.Lock()
+	defer c.evictMu.Unlock()
+
+	now := c.now()
+	if c.maxAge > 0 {
+		entries, err := os.ReadDir(filepath.Join(c.dir, "partial"))
+		if err != nil {
+			log.Errorf("cannot read partial downloads: %v", err)
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || now.Sub(info.ModTime()) <= c.maxAge {
+				continue
+			}
+			file := filepath.Join(c.dir, "partial", entry.Name())
+			if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Errorf("cannot remove partial download: %v", err)
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(c.dir, "blobs"))
+	if err != nil {
+		log.Errorf("cannot read cached content: %v", err)
+		return
+	}
+	blobs := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if c.maxAge > 0 && now.Sub(info.ModTime()) > c.maxAge {
+			log.Debugf("removing expired cached content %v", info.Name())
+			c.removeBlob(info.Name())
+			continue
+		}
+		blobs = append(blobs, info)
+	}
+
+	if c.maxSize <= 0 {
+		return
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].ModTime().After(blobs[j].ModTime())
+	})
+	var size int64
+	for _, info := range blobs {
+		size += info.Size()
+		if size > c.maxSize {
+			log.Debugf("removing least recently used cached content %v", info.Name())
+			c.removeBlob(info.Name())
+		}
+	}
+}
+
+// removeBlob removes the cached content with digest.
+func (c *Cache) removeBlob(digest string) {
+	if err := os.Remove(filepath.Join(c.dir, "blobs", digest)); err != nil &&
+		!errors.Is(err, os.ErrNotExist) {
+		log.Errorf("cannot remove cached content: %v", err)
+	}
+}
+
+// lock locks the mutex shared by fetches of the URL with key, returning a
+// function that unlocks it.
+func (c *Cache) lock(key string) func() {
+	c.mu.Lock()
+	l, has := c.locks[key]
+	if !has {
+		l = &keyLock{}
+		c.locks[key] = l
+	}
+	l.refs++
+	c.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		c.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(c.locks, key)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// hasBlob returns true if content with digest is cached.
+func (c *Cache) hasBlob(digest string) bool {
+	if digest == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(c.dir, "blobs", digest))
+	return err == nil
+}
+
+// openBlob opens the cached content with digest, checking that its length is
+// the expected one. The modification time of the content is updated, so that
+// the least recently used content is evicted first.
+func (c *Cache) openBlob(digest string, want expectation) (*os.File, error) {
+	blob := filepath.Join(c.dir, "blobs", digest)
+	f, err := os.Open(blob)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open cached content: %w", err)
+	}
+	info, err := f.Stat()
+	if err == nil {
+		err = want.check(digest, info.Size())
+	}
+	if err != nil {
+		if err := f.Close(); err != nil {
+			log.Errorf("cannot close cached content: %v", err)
+		}
+		return nil, err
+	}
+	now := c.now()
+	if err := os.Chtimes(blob, now, now); err != nil {
+		log.Errorf("cannot update cached content time: %v", err)
+	}
+	return f, nil
+}
+
+// removePartial removes the incomplete download of the URL with key.
+func (c *Cache) removePartial(key string, e entry) {
+	if err := os.Remove(filepath.Join(c.dir, "partial", key)); err != nil &&
+		!errors.Is(err, os.ErrNotExist) {
+		log.Errorf("cannot remove partial download: %v", err)
+	}
+	e.PartialETag = ""
+	if err := c.writeEntry(key, e); err != nil {
+		log.Errorf("cannot write cache entry: %v", err)
+	}
+}
+
+// readEntry reads the entry of the URL with key. A missing entry is empty.
+func (c *Cache) readEntry(key string) (entry, error) {
+	var e entry
+	data, err := os.ReadFile(filepath.Join(c.dir, "urls", key+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return e, nil
+	}
+	if err != nil {
+		return e, fmt.Errorf("cannot read cache entry: %w", err)
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		log.Errorf("ignoring invalid cache entry %v: %v", key, err)
+		return entry{}, nil
+	}
+	return e, nil
+}
+
+// writeEntry writes the entry of the URL with key.
+func (c *Cache) writeEntry(key string, e entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("cannot marshal cache entry: %w", err)
+	}
+	file := filepath.Join(c.dir, "urls", key+".json")
+	if err := os.WriteFile(file+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("cannot write cache entry: %w", err)
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// contentRangeStart returns the first byte position of a Content-Range header
+// value of the form "bytes first-last/length".
+func contentRangeStart(value string) (int64, error) {
+	spec, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, fmt.Errorf("invalid content range: %v", value)
+	}
+	first, _, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, fmt.Errorf("invalid content range: %v", value)
+	}
+	return strconv.ParseInt(first, 10, 64)
+}
+
+// hashFile returns the hex-encoded SHA-256 digest and the length of a file.
+func hashFile(file string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot open file: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Errorf("cannot close file: %v", err)
+		}
+	}()
+
+	h := sha256.New()
+	length, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot read file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), length, nil
+}
+
+// sha256Hex returns the hex-encoded SHA-256 digest of s.
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,318 @@
+package content
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	internalhttp "github.com/redhatinsights/yggdrasil/internal/http"
+)
+
+const testContent = "hello world\n"
+
+// testDigest is the SHA-256 digest of testContent.
+const testDigest = "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"
+
+// server serves testContent with an ETag, recording the headers of each
+// request it receives.
+type server struct {
+	mu       sync.Mutex
+	requests []http.Header
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Header.Clone())
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", `"v1"`)
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(testContent))
+}
+
+func fetch(t *testing.T, c *Cache, url string, metadata map[string]string) (string, error) {
+	t.Helper()
+	client := internalhttp.NewHTTPClient(&tls.Config{}, "test")
+	f, err := c.Fetch(client, url, metadata)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), nil
+}
+
+func TestFetch(t *testing.T) {
+	tests := []struct {
+		description string
+		input       map[string]string
+		wantError   error
+	}{
+		{
+			description: "no metadata",
+		},
+		{
+			description: "matching metadata",
+			input: map[string]string{
+				MetadataKeySHA256: strings.ToUpper(testDigest),
+				MetadataKeyLength: "12",
+			},
+		},
+		{
+			description: "digest mismatch",
+			input:       map[string]string{MetadataKeySHA256: strings.Repeat("0", 64)},
+			wantError:   ErrMismatch,
+		},
+		{
+			description: "length mismatch",
+			input:       map[string]string{MetadataKeyLength: "5"},
+			wantError:   ErrMismatch,
+		},
+		{
+			description: "invalid digest",
+			input:       map[string]string{MetadataKeySHA256: "abc"},
+			wantError:   ErrInvalidMetadata,
+		},
+		{
+			description: "invalid length",
+			input:       map[string]string{MetadataKeyLength: "-1"},
+			wantError:   ErrInvalidMetadata,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			ts := httptest.NewServer(&server{})
+			defer ts.Close()
+
+			c := NewCache(t.TempDir(), 0, 0)
+			got, err := fetch(t, c, ts.URL, test.input)
+			if test.wantError != nil {
+				if !errors.Is(err, test.wantError) {
+					t.Fatalf("%v != %v", err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != testContent {
+				t.Errorf("%v", cmp.Diff(got, testContent))
+			}
+		})
+	}
+}
+
+func TestFetchCached(t *testing.T) {
+	s := &server{}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := NewCache(t.TempDir(), 0, 0)
+
+	for i := 0; i < 2; i++ {
+		got, err := fetch(t, c, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != testContent {
+			t.Errorf("%v", cmp.Diff(got, testContent))
+		}
+	}
+	if len(s.requests) != 2 {
+		t.Fatalf("unexpected requests: %v", s.requests)
+	}
+	if got := s.requests[1].Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("If-None-Match = %v", got)
+	}
+
+	// Content with a cached digest is not downloaded again.
+	metadata := map[string]string{MetadataKeySHA256: testDigest}
+	if _, err := fetch(t, c, ts.URL+"/other", metadata); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.requests) != 2 {
+		t.Errorf("unexpected requests: %v", s.requests)
+	}
+}
+
+func TestFetchResume(t *testing.T) {
+	s := &server{}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := NewCache(t.TempDir(), 0, 0)
+
+	// Leave an interrupted download of the first five bytes in the cache.
+	key := sha256Hex(ts.URL)
+	for _, dir := range []string{"urls", "partial"} {
+		if err := os.MkdirAll(filepath.Join(c.dir, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.writeEntry(key, entry{URL: ts.URL, PartialETag: `"v1"`}); err != nil {
+		t.Fatal(err)
+	}
+	partial := filepath.Join(c.dir, "partial", key)
+	if err := os.WriteFile(partial, []byte(testContent[:5]), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fetch(t, c, ts.URL, map[string]string{MetadataKeySHA256: testDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != testContent {
+		t.Errorf("%v", cmp.Diff(got, testContent))
+	}
+	if got := s.requests[0].Get("Range"); got != "bytes=5-" {
+		t.Errorf("Range = %v", got)
+	}
+	if _, err := os.Stat(partial); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("partial download was not removed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.dir, "blobs", testDigest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte(testContent)) {
+		t.Errorf("%v", cmp.Diff(string(data), testContent))
+	}
+}
+
+func TestRelease(t *testing.T) {
+	tests := []struct {
+		description string
+		input       map[string]string
+		want        bool
+	}{
+		{
+			description: "unpinned",
+			want:        false,
+		},
+		{
+			description: "pinned",
+			input:       map[string]string{MetadataKeySHA256: testDigest},
+			want:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			ts := httptest.NewServer(&server{})
+			defer ts.Close()
+
+			c := NewCache(t.TempDir(), 0, 0)
+			if _, err := fetch(t, c, ts.URL, test.input); err != nil {
+				t.Fatal(err)
+			}
+			c.Release(ts.URL, test.input)
+
+			if got := c.hasBlob(testDigest); got != test.want {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEvict(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Each blob holds ten bytes and was last used the given time ago.
+	blobs := map[string]time.Duration{
+		"a": time.Minute,
+		"b": 2 * time.Minute,
+		"c": 3 * time.Minute,
+		"d": 2 * time.Hour,
+	}
+
+	tests := []struct {
+		description string
+		maxSize     int64
+		maxAge      time.Duration
+		want        []string
+		wantPartial bool
+	}{
+		{
+			description: "no limits",
+			want:        []string{"a", "b", "c", "d"},
+			wantPartial: true,
+		},
+		{
+			description: "max size",
+			maxSize:     25,
+			want:        []string{"a", "b"},
+			wantPartial: true,
+		},
+		{
+			description: "max age",
+			maxAge:      time.Hour,
+			want:        []string{"a", "b", "c"},
+		},
+		{
+			description: "max size and age",
+			maxSize:     10,
+			maxAge:      time.Hour,
+			want:        []string{"a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := NewCache(t.TempDir(), test.maxSize, test.maxAge)
+			c.now = func() time.Time { return now }
+
+			for _, dir := range []string{"blobs", "partial"} {
+				if err := os.MkdirAll(filepath.Join(c.dir, dir), 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, age := range blobs {
+				file := filepath.Join(c.dir, "blobs", name)
+				if err := os.WriteFile(file, make([]byte, 10), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(file, now.Add(-age), now.Add(-age)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			partial := filepath.Join(c.dir, "partial", "e")
+			if err := os.WriteFile(partial, make([]byte, 10), 0600); err != nil {
+				t.Fatal(err)
+			}
+			stale := now.Add(-2 * time.Hour)
+			if err := os.Chtimes(partial, stale, stale); err != nil {
+				t.Fatal(err)
+			}
+
+			c.evict()
+
+			entries, err := os.ReadDir(filepath.Join(c.dir, "blobs"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v", cmp.Diff(got, test.want))
+			}
+			_, err = os.Stat(partial)
+			if gotPartial := err == nil; gotPartial != test.wantPartial {
+				t.Errorf("partial download kept: %v != %v", gotPartial, test.wantPartial)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package deadletter
+
+import (
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/redhatinsights/yggdrasil"
+)
+
+//go:embed migrations/*.sql
+var embeddedMigrationData embed.FS
+
+// Store is a persistent store of messages that could not be dispatched to a
+// worker after repeated attempts. Messages are stored in a SQLite database so
+// they can be inspected, requeued for dispatch or purged later.
+type Store struct {
+	database *sql.DB
+	maxSize  int
+	maxAge   time.Duration
+}
+
+// Entry is a single message stored in the dead-letter store.
+type Entry struct {
+	ID        int64
+	MessageID string
+	Directive string
+	Failed    time.Time
+	Attempts  int
+	Error     string
+	Message   yggdrasil.Data
+}
+
+// Open initializes a dead-letter sqlite database at databaseFilePath. The
+// store holds at most maxSize entries, dropping the oldest entries when full.
+// Entries older than maxAge are discarded. A zero value for either limit
+// disables that limit.
+func Open(databaseFilePath string, maxSize int, maxAge time.Duration) (*Store, error) {
+	db, err := sql.Open("sqlite3", databaseFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("database object not created: %w", err)
+	}
+	if err = migrateDeadLetterDB(db, databaseFilePath); err != nil {
+		return nil, fmt.Errorf("database migration error: %w", err)
+	}
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("dead-letter database not connected: %w", err)
+	}
+
+	return &Store{database: db, maxSize: maxSize, maxAge: maxAge}, nil
+}
+
+// migrateDeadLetterDB handles the migration of the dead-letter database and
+// ensures the schema is up to date on each session start.
+func migrateDeadLetterDB(db *sql.DB, databaseFilePath string) error {
+	databaseDriver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if err != nil {
+		return fmt.Errorf("database driver not initialized: %w", err)
+	}
+	migrationDriver, err := iofs.New(embeddedMigrationData, "migrations")
+	if err != nil {
+		return fmt.Errorf("embedded migration data not found: %w", err)
+	}
+	migration, err := migrate.NewWithInstance(
+		"iofs",
+		migrationDriver,
+		databaseFilePath,
+		databaseDriver,
+	)
+	if err != nil {
+		return fmt.Errorf("database migration not initialized: %w", err)
+	}
+	if err = migration.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("database migration failed: %w", err)
+	}
+	return nil
+}
+
+// Add stores msg, which could not be dispatched after the given number of
+// attempts because of cause. If the store is full, the oldest entries are
+// dropped to make room.
+func (s *Store) Add(msg yggdrasil.Data, attempts int, cause error) error {
+	if err := s.prune(); err != nil {
+		return err
+	}
+
+	if s.maxSize > 0 {
+		result, err := s.database.Exec(
+			`DELETE FROM dead_letters WHERE id NOT IN `+
+				`(SELECT id FROM dead_letters ORDER BY id DESC LIMIT ?)`,
+			s.maxSize-1,
+		)
+		if err != nil {
+			return fmt.Errorf("cannot drop oldest entries from 'dead_letters' table: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Warnf("dead-letter store is full: dropped %v oldest entries", n)
+		}
+	}
+
+	encodedMessage, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("cannot marshal message: %w", err)
+	}
+
+	var causeText string
+	if cause != nil {
+		causeText = cause.Error()
+	}
+
+	result, err := s.database.Exec(
+		`INSERT INTO dead_letters (message_id, directive, failed, attempts, error, message) `+
+			`values (?,?,?,?,?,?)`,
+		msg.MessageID,
+		msg.Directive,
+		time.Now().UTC(),
+		attempts,
+		causeText,
+		encodedMessage,
+	)
+	if err != nil {
+		return fmt.Errorf("could not insert entry into 'dead_letters' table: %w", err)
+	}
+
+	entryID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("could not select last insert ID for 'dead_letters' table: %w", err)
+	}
+
+	log.Debugf("new dead-letter entry (id: %v) added: '%v'", entryID, msg.MessageID)
+
+	return nil
+}
+
+// Get returns the entry with the given id. If there is no such entry, a nil
+// entry is returned.
+func (s *Store) Get(id int64) (*Entry, error) {
+	if err := s.prune(); err != nil {
+		return nil, err
+	}
+
+	row := s.database.QueryRow(
+		`SELECT id, message_id, directive, failed, attempts, error, message `+
+			`FROM dead_letters WHERE id=?`,
+		id,
+	)
+
+	entry, err := scanEntry(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Remove deletes the entry with the given id from the store.
+func (s *Store) Remove(id int64) error {
+	if _, err := s.database.Exec(`DELETE FROM dead_letters WHERE id=?`, id); err != nil {
+		return fmt.Errorf("cannot delete entry from 'dead_letters' table: %w", err)
+	}
+	return nil
+}
+
+// Purge deletes the entries with the given ids from the store, or every entry
+// if no ids are given. It returns the number of entries deleted.
+func (s *Store) Purge(ids ...int64) (int64, error) {
+	query := `DELETE FROM dead_letters`
+	args := make([]interface{}, 0, len(ids))
+	if len(ids) > 0 {
+		query += ` WHERE id IN (?` + strings.Repeat(`,?`, len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+
+	result, err := s.database.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("cannot delete entries from 'dead_letters' table: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("cannot count deleted entries: %w", err)
+	}
+	return n, nil
+}
+
+// GetEntries retrieves a list of all the entries in the store, oldest first,
+// in a format suitable for displaying to a user.
+func (s *Store) GetEntries() ([]map[string]string, error) {
+	if err := s.prune(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.database.Query(
+		`SELECT id, message_id, directive, failed, attempts, error, message ` +
+			`FROM dead_letters ORDER BY id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot execute query to retrieve dead-letter entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []map[string]string{}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry.Summary())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot iterate queried dead-letter entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// prune deletes all entries that have been in the store longer than the
+// maximum age.
+func (s *Store) prune() error {
+	if s.maxAge <= 0 {
+		return nil
+	}
+	result, err := s.database.Exec(
+		`DELETE FROM dead_letters WHERE failed<?`,
+		time.Now().UTC().Add(-s.maxAge),
+	)
+	if err != nil {
+		return fmt.Errorf("cannot delete expired entries from 'dead_letters' table: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Warnf("dead-letter store: dropped %v expired entries", n)
+	}
+	return nil
+}
+
+// Summary returns the entry, without the message content, in a format
+// suitable for displaying to a user.
+func (e *Entry) Summary() map[string]string {
+	encodedMetadata, err := json.Marshal(e.Message.Metadata)
+	if err != nil {
+		log.Errorf("cannot marshal metadata: %v", err)
+	}
+	return map[string]string{
+		"id":          strconv.FormatInt(e.ID, 10),
+		"message_id":  e.MessageID,
+		"directive":   e.Directive,
+		"failed":      e.Failed.String(),
+		"attempts":    strconv.Itoa(e.Attempts),
+		"error":       e.Error,
+		"response_to": e.Message.ResponseTo,
+		"metadata":    string(encodedMetadata),
+		"size":        strconv.Itoa(len(e.Message.Content)),
+	}
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanEntry scans the columns of a single dead-letter row into an Entry.
+func scanEntry(s scanner) (*Entry, error) {
+	var entry Entry
+	var causeText sql.NullString
+	var encodedMessage []byte
+
+	err := s.Scan(
+		&entry.ID,
+		&entry.MessageID,
+		&entry.Directive,
+		&entry.Failed,
+		&entry.Attempts,
+		&causeText,
+		&encodedMessage,
+	)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot scan dead-letter entry columns: %w", err)
+	}
+	entry.Error = causeText.String
+	if err := json.Unmarshal(encodedMessage, &entry.Message); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal message: %w", err)
+	}
+
+	return &entry, nil
+}
@@ -0,0 +1,172 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/redhatinsights/yggdrasil"
+)
+
+func TestAdd(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "dead-letters.db"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := yggdrasil.Data{
+		Type:      yggdrasil.MessageTypeData,
+		MessageID: "1234",
+		Directive: "echo",
+		Metadata:  map[string]string{"a": "b"},
+		Content:   json.RawMessage(`{"hello":"world"}`),
+	}
+	if err := store.Add(msg, 3, errors.New("worker not found")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.GetEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%v != %v", len(entries), 1)
+	}
+	want := map[string]string{
+		"id":          "1",
+		"message_id":  "1234",
+		"directive":   "echo",
+		"attempts":    "3",
+		"error":       "worker not found",
+		"response_to": "",
+		"metadata":    `{"a":"b"}`,
+		"size":        "17",
+	}
+	got := entries[0]
+	delete(got, "failed")
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+
+	entry, err := store.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(entry.Message, msg) {
+		t.Errorf("%v", cmp.Diff(entry.Message, msg))
+	}
+
+	entry, err = store.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	tests := []struct {
+		description string
+		input       []int64
+		want        int64
+		wantIDs     []string
+	}{
+		{
+			description: "all",
+			want:        3,
+			wantIDs:     []string{},
+		},
+		{
+			description: "some",
+			input:       []int64{1, 3, 4},
+			want:        2,
+			wantIDs:     []string{"2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			store, err := Open(filepath.Join(t.TempDir(), "dead-letters.db"), 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{"a", "b", "c"} {
+				msg := yggdrasil.Data{MessageID: id, Content: json.RawMessage(`{}`)}
+				if err := store.Add(msg, 1, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := store.Purge(test.input...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("%v != %v", got, test.want)
+			}
+
+			entries, err := store.GetEntries()
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotIDs := []string{}
+			for _, entry := range entries {
+				gotIDs = append(gotIDs, entry["id"])
+			}
+			if !cmp.Equal(gotIDs, test.wantIDs) {
+				t.Errorf("%v", cmp.Diff(gotIDs, test.wantIDs))
+			}
+		})
+	}
+}
+
+func TestAddFull(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "dead-letters.db"), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		msg := yggdrasil.Data{MessageID: id, Content: json.RawMessage(`{}`)}
+		if err := store.Add(msg, 1, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := store.GetEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, entry := range entries {
+		got = append(got, entry["message_id"])
+	}
+	want := []string{"b", "c"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "dead-letters.db"), 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := yggdrasil.Data{MessageID: "a", Content: json.RawMessage(`{}`)}
+	if err := store.Add(msg, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	entries, err := store.GetEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%v != %v", len(entries), 0)
+	}
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    message_id TEXT NOT NULL,
+    directive TEXT NOT NULL,
+    failed DATETIME NOT NULL,
+    attempts INTEGER NOT NULL,
+    error TEXT,
+    message BLOB
+);
\ No newline at end of file
@@ -0,0 +1,212 @@
+package dedup
+
+import (
+	"container/list"
+	"database/sql"
+	"embed"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/*.sql
+var embeddedMigrationData embed.FS
+
+// entry records when a message ID was first seen.
+type entry struct {
+	messageID string
+	received  time.Time
+}
+
+// Cache is a bounded, time-windowed set of message IDs that have already been
+// received. It is used to detect duplicate deliveries of the same message. The
+// cache holds at most maxSize IDs, forgetting the oldest IDs when full, and
+// forgets IDs once they are older than the window. A cache may optionally be
+// persisted in a SQLite database so that it survives restarts.
+type Cache struct {
+	mu       sync.Mutex
+	entries  *list.List
+	index    map[string]*list.Element
+	maxSize  int
+	window   time.Duration
+	database *sql.DB
+	now      func() time.Time
+}
+
+// New creates an in-memory cache holding at most maxSize message IDs for the
+// duration of window. A zero value for either limit disables that limit.
+func New(maxSize int, window time.Duration) *Cache {
+	return &Cache{
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+		maxSize: maxSize,
+		window:  window,
+		now:     time.Now,
+	}
+}
+
+// Open creates a cache like New, persisting the cache in a sqlite database at
+// databaseFilePath. Message IDs already stored in the database are loaded into
+// the cache.
+func Open(databaseFilePath string, maxSize int, window time.Duration) (*Cache, error) {
+	db, err := sql.Open("sqlite3", databaseFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("database object not created: %w", err)
+	}
+	if err = migrateDedupDB(db, databaseFilePath); err != nil {
+		return nil, fmt.Errorf("database migration error: %w", err)
+	}
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("dedup database not connected: %w", err)
+	}
+
+	c := New(maxSize, window)
+	c.database = db
+
+	rows, err := db.Query(`SELECT message_id, received FROM seen ORDER BY received`)
+	if err != nil {
+		return nil, fmt.Errorf("cannot execute query to retrieve seen messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.messageID, &e.received); err != nil {
+			return nil, fmt.Errorf("cannot scan seen message columns: %w", err)
+		}
+		c.index[e.messageID] = c.entries.PushBack(e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot iterate seen messages: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.prune(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// migrateDedupDB handles the migration of the dedup database and ensures the
+// schema is up to date on each session start.
+func migrateDedupDB(db *sql.DB, databaseFilePath string) error {
+	databaseDriver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if err != nil {
+		return fmt.Errorf("database driver not initialized: %w", err)
+	}
+	migrationDriver, err := iofs.New(embeddedMigrationData, "migrations")
+	if err != nil {
+		return fmt.Errorf("embedded migration data not found: %w", err)
+	}
+	migration, err := migrate.NewWithInstance(
+		"iofs",
+		migrationDriver,
+		databaseFilePath,
+		databaseDriver,
+	)
+	if err != nil {
+		return fmt.Errorf("database migration not initialized: %w", err)
+	}
+	if err = migration.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("database migration failed: %w", err)
+	}
+	return nil
+}
+
+// Seen records messageID as received, returning true if it had already been
+// received within the cache window. An empty message ID is never considered
+// a duplicate.
+func (c *Cache) Seen(messageID string) (bool, error) {
+	if messageID == "" {
+		return false, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.prune(); err != nil {
+		return false, err
+	}
+
+	if _, has := c.index[messageID]; has {
+		return true, nil
+	}
+
+	e := entry{messageID: messageID, received: c.now().UTC()}
+	c.index[messageID] = c.entries.PushBack(e)
+
+	if c.database != nil {
+		_, err := c.database.Exec(
+			`INSERT OR REPLACE INTO seen (message_id, received) values (?,?)`,
+			e.messageID,
+			e.received,
+		)
+		if err != nil {
+			return false, fmt.Errorf("could not insert entry into 'seen' table: %w", err)
+		}
+	}
+
+	return false, c.prune()
+}
+
+// Forget removes messageID from the cache, so that the message is no longer
+// considered a duplicate when it is received again. It is used when a message
+// recorded by Seen could not be handled, so that the server can send it again.
+func (c *Cache) Forget(messageID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, has := c.index[messageID]
+	if !has {
+		return nil
+	}
+	c.entries.Remove(element)
+	delete(c.index, messageID)
+
+	if c.database != nil {
+		if _, err := c.database.Exec(`DELETE FROM seen WHERE message_id=?`, messageID); err != nil {
+			return fmt.Errorf("cannot delete entry from 'seen' table: %w", err)
+		}
+	}
+	return nil
+}
+
+// prune forgets the oldest message IDs until the cache holds no more than
+// maxSize IDs and no ID is older than the window. c.mu must be held by the
+// caller.
+func (c *Cache) prune() error {
+	cutoff := c.now().UTC().Add(-c.window)
+
+	var removed []string
+	for front := c.entries.Front(); front != nil; front = c.entries.Front() {
+		e := front.Value.(entry)
+		expired := c.window > 0 && e.received.Before(cutoff)
+		full := c.maxSize > 0 && c.entries.Len() > c.maxSize
+		if !expired && !full {
+			break
+		}
+		c.entries.Remove(front)
+		delete(c.index, e.messageID)
+		removed = append(removed, e.messageID)
+	}
+
+	if len(removed) == 0 || c.database == nil {
+		return nil
+	}
+
+	log.Tracef("forgetting %v seen message IDs", len(removed))
+	for _, messageID := range removed {
+		if _, err := c.database.Exec(`DELETE FROM seen WHERE message_id=?`, messageID); err != nil {
+			return fmt.Errorf("cannot delete entry from 'seen' table: %w", err)
+		}
+	}
+	return nil
+}
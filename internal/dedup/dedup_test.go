@@ -0,0 +1,132 @@
+package dedup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSeen(t *testing.T) {
+	type step struct {
+		advance   time.Duration
+		messageID string
+		forget    bool
+		want      bool
+	}
+	tests := []struct {
+		description string
+		maxSize     int
+		window      time.Duration
+		steps       []step
+	}{
+		{
+			description: "duplicate",
+			steps: []step{
+				{messageID: "a", want: false},
+				{messageID: "b", want: false},
+				{messageID: "a", want: true},
+			},
+		},
+		{
+			description: "empty message ID",
+			steps: []step{
+				{messageID: "", want: false},
+				{messageID: "", want: false},
+			},
+		},
+		{
+			description: "forget",
+			steps: []step{
+				{messageID: "a", want: false},
+				{messageID: "a", forget: true},
+				{messageID: "a", want: false},
+				{messageID: "a", want: true},
+			},
+		},
+		{
+			description: "forget oldest when full",
+			maxSize:     2,
+			steps: []step{
+				{messageID: "a", want: false},
+				{messageID: "b", want: false},
+				{messageID: "c", want: false},
+				{messageID: "a", want: false},
+				{messageID: "c", want: true},
+			},
+		},
+		{
+			description: "forget after window",
+			window:      time.Minute,
+			steps: []step{
+				{messageID: "a", want: false},
+				{advance: 30 * time.Second, messageID: "a", want: true},
+				{advance: 31 * time.Second, messageID: "a", want: false},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			now := time.Now()
+			cache := New(test.maxSize, test.window)
+			cache.now = func() time.Time { return now }
+
+			var got []bool
+			var want []bool
+			for _, step := range test.steps {
+				now = now.Add(step.advance)
+				if step.forget {
+					if err := cache.Forget(step.messageID); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				seen, err := cache.Seen(step.messageID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, seen)
+				want = append(want, step.want)
+			}
+
+			if !cmp.Equal(got, want) {
+				t.Errorf("%v", cmp.Diff(got, want))
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	databaseFilePath := filepath.Join(t.TempDir(), "dedup.db")
+
+	cache, err := Open(databaseFilePath, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, messageID := range []string{"a", "b", "c"} {
+		if _, err := cache.Seen(messageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reopen the database, as happens when yggd restarts.
+	cache, err = Open(databaseFilePath, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for _, messageID := range []string{"c", "b", "a"} {
+		seen, err := cache.Seen(messageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[messageID] = seen
+	}
+	want := map[string]bool{"a": false, "b": true, "c": true}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS seen;
\ No newline at end of file
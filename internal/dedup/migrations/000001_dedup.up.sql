@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS seen (
+    message_id TEXT NOT NULL PRIMARY KEY,
+    received DATETIME NOT NULL
+);
\ No newline at end of file
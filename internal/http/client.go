@@ -38,11 +38,15 @@ func NewHTTPClient(config *tls.Config, ua string) *Client {
 	}
 }
 
-func (c *Client) Get(url string) (*http.Response, error) {
+func (c *Client) Get(url string, headers map[string]string) (*http.Response, error) {
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create HTTP request: %w", err)
 	}
+
+	for k, v := range headers {
+		req.Header.Add(k, strings.TrimSpace(v))
+	}
 	req.Header.Add("User-Agent", c.userAgent)
 
 	log.Debugf("sending HTTP request: %v %v", req.Method, req.URL)
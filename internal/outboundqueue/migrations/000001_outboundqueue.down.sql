@@ -0,0 +1 @@
+DROP TABLE IF EXISTS queue;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS queue (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    message_id TEXT NOT NULL,
+    queued DATETIME NOT NULL,
+    dest TEXT NOT NULL,
+    metadata TEXT,
+    data BLOB
+);
\ No newline at end of file
@@ -0,0 +1,321 @@
+package outboundqueue
+
+import (
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/*.sql
+var embeddedMigrationData embed.FS
+
+// OutboundQueue is a persistent, first-in-first-out queue of messages that
+// could not be transmitted over the network. Messages are stored in a SQLite
+// database so they survive restarts and are flushed, in order, once the
+// transport is able to transmit them again.
+type OutboundQueue struct {
+	database *sql.DB
+	maxSize  int
+	maxAge   time.Duration
+	flushMu  sync.Mutex
+}
+
+// Entry is a single message stored in the outbound queue.
+type Entry struct {
+	ID        int64
+	MessageID string
+	Queued    time.Time
+	Dest      string
+	Metadata  map[string]string
+	Data      []byte
+}
+
+// Open initializes an outbound queue sqlite database at databaseFilePath. The
+// queue holds at most maxSize entries, dropping the oldest entries when full.
+// Entries older than maxAge are discarded. A zero value for either limit
+// disables that limit.
+func Open(databaseFilePath string, maxSize int, maxAge time.Duration) (*OutboundQueue, error) {
+	db, err := sql.Open("sqlite3", databaseFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("database object not created: %w", err)
+	}
+	if err = migrateOutboundQueueDB(db, databaseFilePath); err != nil {
+		return nil, fmt.Errorf("database migration error: %w", err)
+	}
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("outbound queue database not connected: %w", err)
+	}
+
+	return &OutboundQueue{database: db, maxSize: maxSize, maxAge: maxAge}, nil
+}
+
+// migrateOutboundQueueDB handles the migration of the outbound queue database
+// and ensures the schema is up to date on each session start.
+func migrateOutboundQueueDB(db *sql.DB, databaseFilePath string) error {
+	databaseDriver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if err != nil {
+		return fmt.Errorf("database driver not initialized: %w", err)
+	}
+	migrationDriver, err := iofs.New(embeddedMigrationData, "migrations")
+	if err != nil {
+		return fmt.Errorf("embedded migration data not found: %w", err)
+	}
+	migration, err := migrate.NewWithInstance(
+		"iofs",
+		migrationDriver,
+		databaseFilePath,
+		databaseDriver,
+	)
+	if err != nil {
+		return fmt.Errorf("database migration not initialized: %w", err)
+	}
+	if err = migration.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("database migration failed: %w", err)
+	}
+	return nil
+}
+
+// Push appends a message to the end of the queue. If the queue is full, the
+// oldest entries are dropped to make room.
+func (q *OutboundQueue) Push(
+	messageID string,
+	dest string,
+	metadata map[string]string,
+	data []byte,
+) error {
+	if err := q.prune(); err != nil {
+		return err
+	}
+
+	if q.maxSize > 0 {
+		result, err := q.database.Exec(
+			`DELETE FROM queue WHERE id NOT IN (SELECT id FROM queue ORDER BY id DESC LIMIT ?)`,
+			q.maxSize-1,
+		)
+		if err != nil {
+			return fmt.Errorf("cannot drop oldest entries from 'queue' table: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Warnf("outbound queue is full: dropped %v oldest entries", n)
+		}
+	}
+
+	encodedMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("cannot marshal metadata: %w", err)
+	}
+
+	result, err := q.database.Exec(
+		`INSERT INTO queue (message_id, queued, dest, metadata, data) values (?,?,?,?,?)`,
+		messageID,
+		time.Now().UTC(),
+		dest,
+		string(encodedMetadata),
+		data,
+	)
+	if err != nil {
+		return fmt.Errorf("could not insert entry into 'queue' table: %w", err)
+	}
+
+	entryID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("could not select last insert ID for 'queue' table: %w", err)
+	}
+
+	log.Debugf("new outbound queue entry (id: %v) added: '%v'", entryID, messageID)
+
+	return nil
+}
+
+// PushIfNotEmpty appends a message to the end of the queue only if the queue
+// already holds other messages, reporting whether the message was queued. The
+// decision is made while no Flush is in progress, so that a message is never
+// left behind in a queue that a flush has just emptied.
+func (q *OutboundQueue) PushIfNotEmpty(
+	messageID string,
+	dest string,
+	metadata map[string]string,
+	data []byte,
+) (bool, error) {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	n, err := q.Len()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	if err := q.Push(messageID, dest, metadata, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Peek returns the oldest entry in the queue without removing it. If the queue
+// is empty, a nil entry is returned.
+func (q *OutboundQueue) Peek() (*Entry, error) {
+	if err := q.prune(); err != nil {
+		return nil, err
+	}
+
+	row := q.database.QueryRow(
+		`SELECT id, message_id, queued, dest, metadata, data FROM queue ORDER BY id LIMIT 1`,
+	)
+
+	entry, err := scanEntry(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Remove deletes the entry with the given id from the queue.
+func (q *OutboundQueue) Remove(id int64) error {
+	if _, err := q.database.Exec(`DELETE FROM queue WHERE id=?`, id); err != nil {
+		return fmt.Errorf("cannot delete entry from 'queue' table: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of entries currently in the queue.
+func (q *OutboundQueue) Len() (int, error) {
+	if err := q.prune(); err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err := q.database.QueryRow(`SELECT COUNT(*) FROM queue`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("cannot count entries in 'queue' table: %w", err)
+	}
+	return count, nil
+}
+
+// Flush calls f for each entry in the queue, oldest first, removing each entry
+// once f returns successfully. Flushing stops at the first error returned by f,
+// leaving the failed entry at the front of the queue.
+func (q *OutboundQueue) Flush(f func(e Entry) error) error {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	for {
+		entry, err := q.Peek()
+		if err != nil {
+			return fmt.Errorf("cannot get next entry: %w", err)
+		}
+		if entry == nil {
+			return nil
+		}
+		if err := f(*entry); err != nil {
+			return fmt.Errorf("cannot flush entry '%v': %w", entry.MessageID, err)
+		}
+		if err := q.Remove(entry.ID); err != nil {
+			return err
+		}
+	}
+}
+
+// GetEntries retrieves a list of all the entries in the queue, oldest first,
+// in a format suitable for displaying to a user.
+func (q *OutboundQueue) GetEntries() ([]map[string]string, error) {
+	if err := q.prune(); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.database.Query(
+		`SELECT id, message_id, queued, dest, metadata, data FROM queue ORDER BY id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot execute query to retrieve queue entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []map[string]string{}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		encodedMetadata, err := json.Marshal(entry.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal metadata: %w", err)
+		}
+		entries = append(entries, map[string]string{
+			"message_id": entry.MessageID,
+			"queued":     entry.Queued.String(),
+			"dest":       entry.Dest,
+			"metadata":   string(encodedMetadata),
+			"size":       strconv.Itoa(len(entry.Data)),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot iterate queried queue entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// prune deletes all entries that have been in the queue longer than the
+// maximum age.
+func (q *OutboundQueue) prune() error {
+	if q.maxAge <= 0 {
+		return nil
+	}
+	result, err := q.database.Exec(
+		`DELETE FROM queue WHERE queued<?`,
+		time.Now().UTC().Add(-q.maxAge),
+	)
+	if err != nil {
+		return fmt.Errorf("cannot delete expired entries from 'queue' table: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Warnf("outbound queue: dropped %v expired entries", n)
+	}
+	return nil
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanEntry scans the columns of a single queue row into an Entry.
+func scanEntry(s scanner) (*Entry, error) {
+	var entry Entry
+	var encodedMetadata string
+
+	err := s.Scan(
+		&entry.ID,
+		&entry.MessageID,
+		&entry.Queued,
+		&entry.Dest,
+		&encodedMetadata,
+		&entry.Data,
+	)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot scan queue entry columns: %w", err)
+	}
+	if err := json.Unmarshal([]byte(encodedMetadata), &entry.Metadata); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal metadata: %w", err)
+	}
+
+	return &entry, nil
+}
@@ -0,0 +1,199 @@
+package outboundqueue
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type pushInput struct {
+	messageID string
+	dest      string
+	metadata  map[string]string
+	data      []byte
+}
+
+func TestFlush(t *testing.T) {
+	tests := []struct {
+		description string
+		maxSize     int
+		entries     []pushInput
+		failAt      string
+		want        []string
+		wantLen     int
+	}{
+		{
+			description: "flush empty queue",
+			want:        nil,
+		},
+		{
+			description: "flush in order",
+			entries: []pushInput{
+				{messageID: "a", dest: "data", data: []byte("1")},
+				{messageID: "b", dest: "control", data: []byte("2")},
+				{messageID: "c", dest: "data", metadata: map[string]string{"k": "v"}},
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			description: "flush stops on error",
+			entries: []pushInput{
+				{messageID: "a", dest: "data"},
+				{messageID: "b", dest: "data"},
+				{messageID: "c", dest: "data"},
+			},
+			failAt:  "b",
+			want:    []string{"a", "b"},
+			wantLen: 2,
+		},
+		{
+			description: "drop oldest when full",
+			maxSize:     2,
+			entries: []pushInput{
+				{messageID: "a", dest: "data"},
+				{messageID: "b", dest: "data"},
+				{messageID: "c", dest: "data"},
+			},
+			want: []string{"b", "c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			queue, err := Open(filepath.Join(t.TempDir(), "queue.db"), test.maxSize, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, entry := range test.entries {
+				err := queue.Push(entry.messageID, entry.dest, entry.metadata, entry.data)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			err = queue.Flush(func(e Entry) error {
+				got = append(got, e.MessageID)
+				if e.MessageID == test.failAt {
+					return fmt.Errorf("failed")
+				}
+				return nil
+			})
+			if test.failAt == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.failAt != "" && err == nil {
+				t.Errorf("expected error")
+			}
+
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v", cmp.Diff(got, test.want))
+			}
+
+			gotLen, err := queue.Len()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotLen != test.wantLen {
+				t.Errorf("%v != %v", gotLen, test.wantLen)
+			}
+		})
+	}
+}
+
+func TestPeek(t *testing.T) {
+	queue, err := Open(filepath.Join(t.TempDir(), "queue.db"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := queue.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Errorf("expected nil entry, got %#v", got)
+	}
+
+	if err := queue.Push("a", "data", map[string]string{"k": "v"}, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = queue.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Entry{
+		ID:        1,
+		MessageID: "a",
+		Dest:      "data",
+		Metadata:  map[string]string{"k": "v"},
+		Data:      []byte("hello"),
+	}
+	if !cmp.Equal(got, want, cmp.FilterPath(func(p cmp.Path) bool {
+		return p.String() == "Queued"
+	}, cmp.Ignore())) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	queue, err := Open(filepath.Join(t.TempDir(), "queue.db"), 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := queue.Push("a", "data", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	got, err := queue.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 0 {
+		t.Errorf("%v != %v", got, 0)
+	}
+}
+
+func TestPushIfNotEmpty(t *testing.T) {
+	queue, err := Open(filepath.Join(t.TempDir(), "queue.db"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := queue.PushIfNotEmpty("a", "data", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued {
+		t.Errorf("message queued in an empty queue")
+	}
+
+	if err := queue.Push("b", "data", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	queued, err = queue.PushIfNotEmpty("c", "data", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !queued {
+		t.Errorf("message not queued behind older messages")
+	}
+
+	var got []string
+	if err := queue.Flush(func(e Entry) error {
+		got = append(got, e.MessageID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "c"}; !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+}
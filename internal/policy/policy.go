@@ -0,0 +1,243 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/pelletier/go-toml"
+)
+
+// ErrDenied is returned when a message is not authorized by the policy.
+var ErrDenied = errors.New("denied by policy")
+
+// document is the TOML representation of a policy file.
+//
+// An example policy file that only permits the "echo" directive to be
+// dispatched remotely, at most 10 times a minute and only with a "job" metadata
+// value consisting of digits:
+//
+//	remote = false
+//
+//	[directive.echo]
+//	remote = true
+//	rate_limit = 10
+//	rate_interval = "1m"
+//
+//	[directive.echo.metadata]
+//	job = "[0-9]+"
+type document struct {
+	Remote    *bool                   `toml:"remote"`
+	Directive map[string]ruleDocument `toml:"directive"`
+}
+
+// ruleDocument is the TOML representation of the rule for a single directive.
+type ruleDocument struct {
+	Remote       *bool             `toml:"remote"`
+	Metadata     map[string]string `toml:"metadata"`
+	RateLimit    int               `toml:"rate_limit"`
+	RateInterval string            `toml:"rate_interval"`
+}
+
+// rule restricts remote dispatch of messages to a single directive.
+type rule struct {
+	remote       bool
+	metadata     map[string]*regexp.Regexp
+	rateLimit    int
+	rateInterval time.Duration
+}
+
+// Policy decides which data messages received from the server may be
+// dispatched to workers. Each directive can be restricted to local dispatch
+// only, can require metadata values matching regular expressions and can be
+// rate limited. Messages dispatched locally are not subject to the policy.
+type Policy struct {
+	mu       sync.Mutex
+	file     string
+	remote   bool
+	rules    map[string]rule
+	received map[string][]time.Time
+	now      func() time.Time
+}
+
+// New creates a Policy that permits every message.
+func New() *Policy {
+	return &Policy{
+		remote:   true,
+		rules:    make(map[string]rule),
+		received: make(map[string][]time.Time),
+		now:      time.Now,
+	}
+}
+
+// Load creates a Policy from the TOML file at path. If the file does not
+// exist, the Policy permits every message until the file is created and the
+// policy is reloaded.
+func Load(file string) (*Policy, error) {
+	p := New()
+	p.file = file
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload reads the policy file again, replacing the current rules. Rate limits
+// are reset. If the file no longer exists, every message is permitted. If the
+// file cannot be parsed, the current rules are kept and an error is returned.
+func (p *Policy) Reload() error {
+	if p.file == "" {
+		return nil
+	}
+
+	var remote bool
+	var rules map[string]rule
+
+	f, err := os.Open(p.file)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		remote = true
+		rules = make(map[string]rule)
+	case err != nil:
+		return fmt.Errorf("cannot open '%v' for reading: %w", p.file, err)
+	default:
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Errorf("cannot close policy file: %v", err)
+			}
+		}()
+		remote, rules, err = readPolicy(f)
+		if err != nil {
+			return fmt.Errorf("cannot read policy file: %w", err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remote = remote
+	p.rules = rules
+	p.received = make(map[string][]time.Time)
+
+	log.Debugf("loaded policy with %v directive rules", len(rules))
+
+	return nil
+}
+
+// Authorize returns an error wrapping ErrDenied if a message received from the
+// server for directive with the given metadata may not be dispatched. A
+// permitted message counts towards the directive's rate limit.
+func (p *Policy) Authorize(directive string, metadata map[string]string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, has := p.rules[directive]
+	if !has {
+		r.remote = p.remote
+	}
+
+	if !r.remote {
+		return fmt.Errorf("%w: directive '%v' is local only", ErrDenied, directive)
+	}
+
+	for key, pattern := range r.metadata {
+		value, has := metadata[key]
+		if !has {
+			return fmt.Errorf("%w: missing metadata key '%v'", ErrDenied, key)
+		}
+		if !pattern.MatchString(value) {
+			return fmt.Errorf(
+				"%w: metadata value '%v' for key '%v' does not match '%v'",
+				ErrDenied,
+				value,
+				key,
+				pattern,
+			)
+		}
+	}
+
+	if r.rateLimit > 0 {
+		now := p.now()
+		cutoff := now.Add(-r.rateInterval)
+		received := p.received[directive]
+		for len(received) > 0 && !received[0].After(cutoff) {
+			received = received[1:]
+		}
+		if len(received) >= r.rateLimit {
+			p.received[directive] = received
+			return fmt.Errorf(
+				"%w: rate limit of %v messages per %v exceeded",
+				ErrDenied,
+				r.rateLimit,
+				r.rateInterval,
+			)
+		}
+		p.received[directive] = append(received, now)
+	}
+
+	return nil
+}
+
+// readPolicy reads a TOML-encoded policy from its input, returning the default
+// remote permission and the rules for each directive.
+func readPolicy(in io.Reader) (bool, map[string]rule, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return false, nil, fmt.Errorf("cannot read input: %w", err)
+	}
+
+	var doc document
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return false, nil, fmt.Errorf("cannot parse TOML: %w", err)
+	}
+
+	remote := true
+	if doc.Remote != nil {
+		remote = *doc.Remote
+	}
+
+	rules := make(map[string]rule)
+	for directive, d := range doc.Directive {
+		r := rule{
+			remote:       remote,
+			metadata:     make(map[string]*regexp.Regexp),
+			rateLimit:    d.RateLimit,
+			rateInterval: time.Minute,
+		}
+		if d.Remote != nil {
+			r.remote = *d.Remote
+		}
+		for key, value := range d.Metadata {
+			pattern, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return false, nil, fmt.Errorf(
+					"cannot compile metadata pattern for directive '%v': %w",
+					directive,
+					err,
+				)
+			}
+			r.metadata[key] = pattern
+		}
+		if d.RateInterval != "" {
+			interval, err := time.ParseDuration(d.RateInterval)
+			if err != nil {
+				return false, nil, fmt.Errorf(
+					"cannot parse rate interval for directive '%v': %w",
+					directive,
+					err,
+				)
+			}
+			r.rateInterval = interval
+		}
+		if r.rateLimit < 0 || r.rateInterval <= 0 {
+			return false, nil, fmt.Errorf("invalid rate limit for directive '%v'", directive)
+		}
+		rules[directive] = r
+	}
+
+	return remote, rules, nil
+}
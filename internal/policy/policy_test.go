@@ -0,0 +1,188 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAuthorize(t *testing.T) {
+	type step struct {
+		advance   time.Duration
+		directive string
+		metadata  map[string]string
+		want      bool
+	}
+	tests := []struct {
+		description string
+		input       string
+		steps       []step
+	}{
+		{
+			description: "empty",
+			input:       ``,
+			steps: []step{
+				{directive: "echo", want: true},
+			},
+		},
+		{
+			description: "local only",
+			input: strings.Join([]string{
+				`[directive.echo]`,
+				`remote = false`,
+			}, "\n"),
+			steps: []step{
+				{directive: "echo", want: false},
+				{directive: "other", want: true},
+			},
+		},
+		{
+			description: "deny by default",
+			input: strings.Join([]string{
+				`remote = false`,
+				`[directive.echo]`,
+				`remote = true`,
+				`[directive.ping]`,
+			}, "\n"),
+			steps: []step{
+				{directive: "echo", want: true},
+				{directive: "ping", want: false},
+				{directive: "other", want: false},
+			},
+		},
+		{
+			description: "metadata",
+			input: strings.Join([]string{
+				`[directive.echo.metadata]`,
+				`job = "[0-9]+"`,
+			}, "\n"),
+			steps: []step{
+				{directive: "echo", metadata: map[string]string{"job": "123"}, want: true},
+				{directive: "echo", metadata: map[string]string{"job": "123a"}, want: false},
+				{directive: "echo", want: false},
+			},
+		},
+		{
+			description: "rate limit",
+			input: strings.Join([]string{
+				`[directive.echo]`,
+				`rate_limit = 2`,
+				`rate_interval = "1m"`,
+			}, "\n"),
+			steps: []step{
+				{directive: "echo", want: true},
+				{advance: 10 * time.Second, directive: "echo", want: true},
+				{advance: 10 * time.Second, directive: "echo", want: false},
+				{directive: "other", want: true},
+				{advance: 41 * time.Second, directive: "echo", want: true},
+				{directive: "echo", want: false},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			remote, rules, err := readPolicy(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			p := New()
+			p.remote = remote
+			p.rules = rules
+			p.now = func() time.Time { return now }
+
+			var got []bool
+			var want []bool
+			for _, step := range test.steps {
+				now = now.Add(step.advance)
+				err := p.Authorize(step.directive, step.metadata)
+				if err != nil && !errors.Is(err, ErrDenied) {
+					t.Fatal(err)
+				}
+				got = append(got, err == nil)
+				want = append(want, step.want)
+			}
+
+			if !cmp.Equal(got, want) {
+				t.Errorf("%v", cmp.Diff(got, want))
+			}
+		})
+	}
+}
+
+func TestReadPolicyInvalid(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+	}{
+		{
+			description: "invalid TOML",
+			input:       `[directive.echo`,
+		},
+		{
+			description: "invalid pattern",
+			input:       strings.Join([]string{`[directive.echo.metadata]`, `job = "("`}, "\n"),
+		},
+		{
+			description: "invalid interval",
+			input:       strings.Join([]string{`[directive.echo]`, `rate_interval = "soon"`}, "\n"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if _, _, err := readPolicy(strings.NewReader(test.input)); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.toml")
+
+	p, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Authorize("echo", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte("remote = false\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Authorize("echo", nil); !errors.Is(err, ErrDenied) {
+		t.Fatalf("%v != %v", err, ErrDenied)
+	}
+
+	// An invalid file keeps the current rules.
+	if err := os.WriteFile(file, []byte("remote = "), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := p.Authorize("echo", nil); !errors.Is(err, ErrDenied) {
+		t.Fatalf("%v != %v", err, ErrDenied)
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Authorize("echo", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
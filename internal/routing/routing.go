@@ -0,0 +1,253 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/pelletier/go-toml"
+)
+
+// document is the TOML representation of a routing table file.
+//
+// An example routing table that dispatches messages for the "old_echo"
+// directive to the "echo" worker, messages for the "package" directive with an
+// "rpm" content type to both the "rpm" and "audit" workers, and messages for
+// the "package" directive whose worker is not available to the "catchall"
+// worker:
+//
+//	[directive.old_echo]
+//	workers = ["echo"]
+//
+//	[directive.package]
+//	fallback = "catchall"
+//
+//	[[directive.package.route]]
+//	workers = ["rpm", "audit"]
+//
+//	[directive.package.route.metadata]
+//	content-type = "application/x-rpm"
+type document struct {
+	Fallback  string                       `toml:"fallback"`
+	Directive map[string]directiveDocument `toml:"directive"`
+}
+
+// directiveDocument is the TOML representation of the routes for a single
+// directive.
+type directiveDocument struct {
+	Workers  []string        `toml:"workers"`
+	Fallback string          `toml:"fallback"`
+	Route    []routeDocument `toml:"route"`
+}
+
+// routeDocument is the TOML representation of a single route.
+type routeDocument struct {
+	Workers  []string          `toml:"workers"`
+	Metadata map[string]string `toml:"metadata"`
+}
+
+// directiveRoutes holds the routes for a single directive.
+type directiveRoutes struct {
+	workers  []string
+	fallback string
+	routes   []route
+}
+
+// route sends messages with metadata values matching regular expressions to
+// a list of workers.
+type route struct {
+	workers  []string
+	metadata map[string]*regexp.Regexp
+}
+
+// Table decides which workers the data messages received from the server are
+// dispatched to. A directive can be an alias for another worker, can be sent
+// to several workers at once, and can be routed to different workers depending
+// on metadata values. A fallback worker receives messages whose worker is not
+// available.
+type Table struct {
+	mu         sync.RWMutex
+	file       string
+	fallback   string
+	directives map[string]directiveRoutes
+}
+
+// New creates a Table that dispatches every message to the worker named by
+// its directive.
+func New() *Table {
+	return &Table{
+		directives: make(map[string]directiveRoutes),
+	}
+}
+
+// Load creates a Table from the TOML file at path. If the file does not
+// exist, every message is dispatched to the worker named by its directive
+// until the file is created and the table is reloaded.
+func Load(file string) (*Table, error) {
+	t := New()
+	t.file = file
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload reads the routing table file again, replacing the current routes. If
+// the file no longer exists, every route is removed. If the file cannot be
+// parsed, the current routes are kept and an error is returned.
+func (t *Table) Reload() error {
+	if t.file == "" {
+		return nil
+	}
+
+	var fallback string
+	var directives map[string]directiveRoutes
+
+	f, err := os.Open(t.file)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		directives = make(map[string]directiveRoutes)
+	case err != nil:
+		return fmt.Errorf("cannot open '%v' for reading: %w", t.file, err)
+	default:
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Errorf("cannot close routing table file: %v", err)
+			}
+		}()
+		fallback, directives, err = readTable(f)
+		if err != nil {
+			return fmt.Errorf("cannot read routing table file: %w", err)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fallback = fallback
+	t.directives = directives
+
+	log.Debugf("loaded routing table with routes for %v directives", len(directives))
+
+	return nil
+}
+
+// Route returns the workers a message for directive with the given metadata
+// is dispatched to, and the worker that receives the message instead of any
+// of them that is not available, if any. The routes of the directive are
+// tried in order, and the workers of the first route whose metadata patterns
+// all match are returned. If no route matches, the workers of the directive
+// are returned.
+func (t *Table) Route(directive string, metadata map[string]string) ([]string, string) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	d, has := t.directives[directive]
+	if !has {
+		return []string{directive}, t.fallback
+	}
+
+	fallback := d.fallback
+	if fallback == "" {
+		fallback = t.fallback
+	}
+
+	for _, r := range d.routes {
+		if r.match(metadata) {
+			return r.workers, fallback
+		}
+	}
+	return d.workers, fallback
+}
+
+// match returns true if every metadata pattern of the route matches the value
+// of its key in metadata.
+func (r route) match(metadata map[string]string) bool {
+	for key, pattern := range r.metadata {
+		value, has := metadata[key]
+		if !has || !pattern.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// readTable reads a TOML-encoded routing table from its input, returning the
+// default fallback worker and the routes for each directive.
+func readTable(in io.Reader) (string, map[string]directiveRoutes, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot read input: %w", err)
+	}
+
+	var doc document
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return "", nil, fmt.Errorf("cannot parse TOML: %w", err)
+	}
+
+	directives := make(map[string]directiveRoutes)
+	for directive, d := range doc.Directive {
+		routes := directiveRoutes{
+			workers:  d.Workers,
+			fallback: d.Fallback,
+		}
+		if len(routes.workers) == 0 {
+			routes.workers = []string{directive}
+		}
+		if err := checkWorkers(routes.workers); err != nil {
+			return "", nil, fmt.Errorf("invalid workers for directive '%v': %w", directive, err)
+		}
+
+		for i, r := range d.Route {
+			if len(r.Workers) == 0 {
+				return "", nil, fmt.Errorf(
+					"route %v for directive '%v' has no workers",
+					i+1,
+					directive,
+				)
+			}
+			if err := checkWorkers(r.Workers); err != nil {
+				return "", nil, fmt.Errorf(
+					"invalid workers for route %v for directive '%v': %w",
+					i+1,
+					directive,
+					err,
+				)
+			}
+			rt := route{
+				workers:  r.Workers,
+				metadata: make(map[string]*regexp.Regexp),
+			}
+			for key, value := range r.Metadata {
+				pattern, err := regexp.Compile("^(?:" + value + ")$")
+				if err != nil {
+					return "", nil, fmt.Errorf(
+						"cannot compile metadata pattern for route %v for directive '%v': %w",
+						i+1,
+						directive,
+						err,
+					)
+				}
+				rt.metadata[key] = pattern
+			}
+			routes.routes = append(routes.routes, rt)
+		}
+
+		directives[directive] = routes
+	}
+
+	return doc.Fallback, directives, nil
+}
+
+// checkWorkers returns an error if any of the worker names is empty.
+func checkWorkers(workers []string) error {
+	for _, worker := range workers {
+		if worker == "" {
+			return fmt.Errorf("empty worker name")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package routing
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRoute(t *testing.T) {
+	type want struct {
+		workers  []string
+		fallback string
+	}
+	tests := []struct {
+		description string
+		input       string
+		directive   string
+		metadata    map[string]string
+		want        want
+	}{
+		{
+			description: "empty",
+			input:       ``,
+			directive:   "echo",
+			want:        want{workers: []string{"echo"}},
+		},
+		{
+			description: "alias",
+			input: strings.Join([]string{
+				`[directive.old_echo]`,
+				`workers = ["echo"]`,
+			}, "\n"),
+			directive: "old_echo",
+			want:      want{workers: []string{"echo"}},
+		},
+		{
+			description: "fan-out",
+			input: strings.Join([]string{
+				`[directive.echo]`,
+				`workers = ["echo", "audit"]`,
+			}, "\n"),
+			directive: "echo",
+			want:      want{workers: []string{"echo", "audit"}},
+		},
+		{
+			description: "metadata match",
+			input: strings.Join([]string{
+				`[[directive.package.route]]`,
+				`workers = ["rpm", "audit"]`,
+				`[directive.package.route.metadata]`,
+				`content-type = "application/x-rpm"`,
+				`[[directive.package.route]]`,
+				`workers = ["deb"]`,
+			}, "\n"),
+			directive: "package",
+			metadata:  map[string]string{"content-type": "application/x-rpm"},
+			want:      want{workers: []string{"rpm", "audit"}},
+		},
+		{
+			description: "metadata mismatch",
+			input: strings.Join([]string{
+				`[directive.package]`,
+				`workers = ["dnf"]`,
+				`[[directive.package.route]]`,
+				`workers = ["rpm"]`,
+				`[directive.package.route.metadata]`,
+				`content-type = "application/x-rpm"`,
+			}, "\n"),
+			directive: "package",
+			metadata:  map[string]string{"content-type": "application/x-rpm-signed"},
+			want:      want{workers: []string{"dnf"}},
+		},
+		{
+			description: "default fallback",
+			input:       `fallback = "catchall"`,
+			directive:   "echo",
+			want:        want{workers: []string{"echo"}, fallback: "catchall"},
+		},
+		{
+			description: "directive fallback",
+			input: strings.Join([]string{
+				`fallback = "catchall"`,
+				`[directive.echo]`,
+				`fallback = "echo_v1"`,
+			}, "\n"),
+			directive: "echo",
+			want:      want{workers: []string{"echo"}, fallback: "echo_v1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			fallback, directives, err := readTable(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			table := New()
+			table.fallback = fallback
+			table.directives = directives
+
+			var got want
+			got.workers, got.fallback = table.Route(test.directive, test.metadata)
+
+			if !cmp.Equal(got, test.want, cmp.AllowUnexported(want{})) {
+				t.Errorf("%v", cmp.Diff(got, test.want, cmp.AllowUnexported(want{})))
+			}
+		})
+	}
+}
+
+func TestReadTableInvalid(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+	}{
+		{
+			description: "invalid TOML",
+			input:       `[directive.echo`,
+		},
+		{
+			description: "empty worker",
+			input:       strings.Join([]string{`[directive.echo]`, `workers = [""]`}, "\n"),
+		},
+		{
+			description: "route without workers",
+			input:       strings.Join([]string{`[[directive.echo.route]]`}, "\n"),
+		},
+		{
+			description: "invalid pattern",
+			input: strings.Join([]string{
+				`[[directive.echo.route]]`,
+				`workers = ["echo"]`,
+				`[directive.echo.route.metadata]`,
+				`job = "("`,
+			}, "\n"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if _, _, err := readTable(strings.NewReader(test.input)); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "routes.toml")
+
+	table, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := table.Route("old_echo", nil); !cmp.Equal(got, []string{"old_echo"}) {
+		t.Fatalf("%v", cmp.Diff(got, []string{"old_echo"}))
+	}
+
+	data := []byte("[directive.old_echo]\nworkers = [\"echo\"]\n")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := table.Route("old_echo", nil); !cmp.Equal(got, []string{"echo"}) {
+		t.Fatalf("%v", cmp.Diff(got, []string{"echo"}))
+	}
+
+	// An invalid file keeps the current routes.
+	if err := os.WriteFile(file, []byte("fallback = "), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Reload(); err == nil {
+		t.Fatal("expected error")
+	}
+	if got, _ := table.Route("old_echo", nil); !cmp.Equal(got, []string{"echo"}) {
+		t.Fatalf("%v", cmp.Diff(got, []string{"echo"}))
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := table.Route("old_echo", nil); !cmp.Equal(got, []string{"old_echo"}) {
+		t.Fatalf("%v", cmp.Diff(got, []string{"old_echo"}))
+	}
+}
@@ -0,0 +1,107 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// 2024-01-03 is a Wednesday.
+	from := time.Date(2024, 1, 3, 10, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		description string
+		input       string
+		want        time.Time
+		wantError   bool
+	}{
+		{
+			description: "every minute",
+			input:       "* * * * *",
+			want:        time.Date(2024, 1, 3, 10, 31, 0, 0, time.UTC),
+		},
+		{
+			description: "daily",
+			input:       "0 2 * * *",
+			want:        time.Date(2024, 1, 4, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "step",
+			input:       "*/20 * * * *",
+			want:        time.Date(2024, 1, 3, 10, 40, 0, 0, time.UTC),
+		},
+		{
+			description: "list and range",
+			input:       "15 9,22-23 * * *",
+			want:        time.Date(2024, 1, 3, 22, 15, 0, 0, time.UTC),
+		},
+		{
+			description: "day of week",
+			input:       "0 3 * * 6",
+			want:        time.Date(2024, 1, 6, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "sunday as 7",
+			input:       "0 3 * * 7",
+			want:        time.Date(2024, 1, 7, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "day of month or day of week",
+			input:       "0 0 5 * 4",
+			want:        time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "month",
+			input:       "0 0 1 3 *",
+			want:        time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "leap day",
+			input:       "0 0 29 2 *",
+			want:        time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "never",
+			input:       "0 0 31 2 *",
+		},
+		{
+			description: "too few fields",
+			input:       "0 0 * *",
+			wantError:   true,
+		},
+		{
+			description: "out of range",
+			input:       "60 * * * *",
+			wantError:   true,
+		},
+		{
+			description: "invalid step",
+			input:       "*/0 * * * *",
+			wantError:   true,
+		},
+		{
+			description: "reversed range",
+			input:       "* 5-2 * * *",
+			wantError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c, err := ParseCron(test.input)
+			if test.wantError {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := c.Next(from)
+			if !got.Equal(test.want) {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS scheduled;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS scheduled (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    message_id TEXT NOT NULL,
+    directive TEXT NOT NULL,
+    received DATETIME NOT NULL,
+    next INTEGER NOT NULL,
+    due INTEGER NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    cron TEXT,
+    message BLOB
+);
\ No newline at end of file
@@ -0,0 +1,485 @@
+package schedule
+
+import (
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/redhatinsights/yggdrasil"
+)
+
+const (
+	// MetadataKeyNotBefore is the data message metadata key holding the
+	// earliest time, in RFC 3339 format, at which the message is dispatched.
+	MetadataKeyNotBefore = "not-before"
+
+	// MetadataKeyCron is the data message metadata key holding a cron
+	// expression. The message is dispatched each time the expression
+	// matches, until it is cancelled.
+	MetadataKeyCron = "cron"
+
+	// retryInterval is the duration to wait before trying again to dispatch
+	// a due message that could not be dispatched.
+	retryInterval = time.Minute
+
+	// maxDispatchAttempts is the number of attempts made to dispatch a due
+	// message before it is given up on.
+	maxDispatchAttempts = 10
+)
+
+// ErrInvalidSchedule is returned when the schedule metadata of a message
+// cannot be parsed.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// ErrFull is returned when a message cannot be scheduled because the
+// scheduler holds the maximum number of entries.
+var ErrFull = errors.New("scheduler is full")
+
+//go:embed migrations/*.sql
+var embeddedMigrationData embed.FS
+
+// Scheduler holds data messages that are dispatched at a later time, as
+// requested by their "not-before" and "cron" metadata values. Messages are
+// stored in a SQLite database so they survive restarts.
+type Scheduler struct {
+	database   *sql.DB
+	dispatch   func(data yggdrasil.Data) error
+	failed     func(data yggdrasil.Data, attempts int, err error)
+	maxEntries int
+	wake       chan struct{}
+	now        func() time.Time
+}
+
+// Entry is a single message held by the scheduler. Next is the time at which
+// the message is dispatched and Due the time at which it was due, which is
+// earlier than Next if dispatching the message has to be tried again. Attempts
+// is the number of failed attempts to dispatch the message since it was due.
+type Entry struct {
+	ID        int64
+	MessageID string
+	Directive string
+	Received  time.Time
+	Next      time.Time
+	Due       time.Time
+	Attempts  int
+	Cron      string
+	Message   yggdrasil.Data
+}
+
+// Scheduled returns true if data carries metadata requesting that it is
+// dispatched later.
+func Scheduled(data yggdrasil.Data) bool {
+	_, notBefore := data.Metadata[MetadataKeyNotBefore]
+	_, cron := data.Metadata[MetadataKeyCron]
+	return notBefore || cron
+}
+
+// Open initializes a scheduler sqlite database at databaseFilePath. Due
+// messages are passed to dispatch, without their schedule metadata, once the
+// scheduler is started. A message that dispatch fails to accept after
+// repeated attempts is removed and passed to failed, if it is not nil, with
+// the number of attempts and the last error. If maxEntries is greater than
+// zero, the scheduler holds at most maxEntries messages.
+func Open(
+	databaseFilePath string,
+	dispatch func(data yggdrasil.Data) error,
+	failed func(data yggdrasil.Data, attempts int, err error),
+	maxEntries int,
+) (*Scheduler, error) {
+	db, err := sql.Open("sqlite3", databaseFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("database object not created: %w", err)
+	}
+	if err = migrateScheduleDB(db, databaseFilePath); err != nil {
+		return nil, fmt.Errorf("database migration error: %w", err)
+	}
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("schedule database not connected: %w", err)
+	}
+
+	return &Scheduler{
+		database:   db,
+		dispatch:   dispatch,
+		failed:     failed,
+		maxEntries: maxEntries,
+		wake:       make(chan struct{}, 1),
+		now:        time.Now,
+	}, nil
+}
+
+// migrateScheduleDB handles the migration of the schedule database and
+// ensures the schema is up to date on each session start.
+func migrateScheduleDB(db *sql.DB, databaseFilePath string) error {
+	databaseDriver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if err != nil {
+		return fmt.Errorf("database driver not initialized: %w", err)
+	}
+	migrationDriver, err := iofs.New(embeddedMigrationData, "migrations")
+	if err != nil {
+		return fmt.Errorf("embedded migration data not found: %w", err)
+	}
+	migration, err := migrate.NewWithInstance(
+		"iofs",
+		migrationDriver,
+		databaseFilePath,
+		databaseDriver,
+	)
+	if err != nil {
+		return fmt.Errorf("database migration not initialized: %w", err)
+	}
+	if err = migration.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("database migration failed: %w", err)
+	}
+	return nil
+}
+
+// Start starts a goroutine dispatching messages as they become due.
+func (s *Scheduler) Start() {
+	go s.loop()
+}
+
+// loop dispatches due messages, then waits until the next message is due or
+// a message is added.
+func (s *Scheduler) loop() {
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		}
+
+		next, err := s.runDue()
+		if err != nil {
+			log.Errorf("cannot dispatch scheduled messages: %v", err)
+			next = s.now().Add(retryInterval)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(next.Sub(s.now()))
+		}
+	}
+}
+
+// Add stores data to be dispatched at the time requested by its schedule
+// metadata, returning that time. If the time has already passed, the message
+// is dispatched immediately. If the scheduler is full, ErrFull is returned.
+func (s *Scheduler) Add(data yggdrasil.Data) (time.Time, error) {
+	next, err := firstRun(data, s.now())
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	encodedMessage, err := json.Marshal(data)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot marshal message: %w", err)
+	}
+
+	// The entry is only inserted if the table has room for it, so that the
+	// number of entries is checked and changed in one statement.
+	maxEntries := int64(s.maxEntries)
+	if maxEntries <= 0 {
+		maxEntries = math.MaxInt64
+	}
+	result, err := s.database.Exec(
+		`INSERT INTO scheduled (message_id, directive, received, next, due, cron, message) `+
+			`SELECT ?,?,?,?,?,?,? WHERE (SELECT COUNT(*) FROM scheduled) < ?`,
+		data.MessageID,
+		data.Directive,
+		s.now().UTC(),
+		next.Unix(),
+		next.Unix(),
+		data.Metadata[MetadataKeyCron],
+		encodedMessage,
+		maxEntries,
+	)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not insert entry into 'scheduled' table: %w", err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot count inserted entries: %w", err)
+	}
+	if inserted == 0 {
+		return time.Time{}, fmt.Errorf("%w: %v messages are scheduled", ErrFull, s.maxEntries)
+	}
+
+	entryID, err := result.LastInsertId()
+	if err != nil {
+		return time.Time{}, fmt.Errorf(
+			"could not select last insert ID for 'scheduled' table: %w",
+			err,
+		)
+	}
+	log.Debugf("new scheduled entry (id: %v) added: '%v'", entryID, data.MessageID)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return next, nil
+}
+
+// firstRun returns the first time at which data is dispatched: the time given
+// by its "not-before" metadata value, or now, adjusted to the next time
+// matching its "cron" metadata value.
+func firstRun(data yggdrasil.Data, now time.Time) (time.Time, error) {
+	start := now
+	if value, has := data.Metadata[MetadataKeyNotBefore]; has {
+		notBefore, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf(
+				"%w: cannot parse %v: %v",
+				ErrInvalidSchedule,
+				MetadataKeyNotBefore,
+				err,
+			)
+		}
+		start = notBefore
+	}
+
+	expr, has := data.Metadata[MetadataKeyCron]
+	if !has {
+		return start, nil
+	}
+	c, err := ParseCron(expr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+	if start.Before(now) {
+		start = now
+	}
+	// Next returns a time strictly after its argument; step back so that a
+	// start time matching the expression is used.
+	next := c.Next(start.Add(-time.Nanosecond))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf(
+			"%w: cron expression '%v' never matches",
+			ErrInvalidSchedule,
+			expr,
+		)
+	}
+	return next, nil
+}
+
+// runDue dispatches every message that is due and returns the time at which
+// the next message is due, or the zero time if no messages are scheduled.
+func (s *Scheduler) runDue() (time.Time, error) {
+	now := s.now()
+
+	entries, err := s.query(`WHERE next <= ? ORDER BY next, id`, now.Unix())
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, entry := range entries {
+		if err := s.dispatchEntry(entry, now); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	var next sql.NullInt64
+	if err := s.database.QueryRow(`SELECT MIN(next) FROM scheduled`).Scan(&next); err != nil {
+		return time.Time{}, fmt.Errorf("cannot select next scheduled time: %w", err)
+	}
+	if !next.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(next.Int64, 0), nil
+}
+
+// dispatchEntry dispatches the message of entry, then removes the entry or,
+// if its cron expression matches again, schedules its next run. If the
+// message cannot be dispatched, it is tried again later, up to
+// maxDispatchAttempts times before the entry is removed. The message is sent
+// as of the time it was due, unless it was sent later, so that its TTL counts
+// from the time it was due rather than from when it was dispatched.
+func (s *Scheduler) dispatchEntry(entry *Entry, now time.Time) error {
+	msg := entry.Message
+	if entry.Due.After(msg.Sent) {
+		msg.Sent = entry.Due
+	}
+	msg.Metadata = make(map[string]string, len(entry.Message.Metadata))
+	for k, v := range entry.Message.Metadata {
+		if k == MetadataKeyNotBefore || k == MetadataKeyCron {
+			continue
+		}
+		msg.Metadata[k] = v
+	}
+
+	next := time.Time{}
+	due := entry.Due
+	attempts := 0
+	if err := s.dispatch(msg); err != nil {
+		attempts = entry.Attempts + 1
+		if attempts < maxDispatchAttempts {
+			log.Errorf(
+				"cannot dispatch scheduled message %v (attempt %v of %v): %v",
+				entry.MessageID,
+				attempts,
+				maxDispatchAttempts,
+				err,
+			)
+			next = now.Add(retryInterval)
+		} else {
+			log.Errorf(
+				"cannot dispatch scheduled message %v after %v attempts, removing it: %v",
+				entry.MessageID,
+				attempts,
+				err,
+			)
+			if s.failed != nil {
+				s.failed(msg, attempts, err)
+			}
+		}
+	} else if entry.Cron != "" {
+		c, err := ParseCron(entry.Cron)
+		if err != nil {
+			log.Errorf("cannot reschedule message %v: %v", entry.MessageID, err)
+		} else {
+			next = c.Next(now)
+			due = next
+		}
+	}
+
+	if next.IsZero() {
+		if _, err := s.Cancel(entry.ID); err != nil {
+			return err
+		}
+		return nil
+	}
+	_, err := s.database.Exec(
+		`UPDATE scheduled SET next=?, due=?, attempts=? WHERE id=?`,
+		next.Unix(),
+		due.Unix(),
+		attempts,
+		entry.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("cannot update entry in 'scheduled' table: %w", err)
+	}
+	return nil
+}
+
+// Cancel removes the entry with the given id. It returns false if there is no
+// such entry.
+func (s *Scheduler) Cancel(id int64) (bool, error) {
+	result, err := s.database.Exec(`DELETE FROM scheduled WHERE id=?`, id)
+	if err != nil {
+		return false, fmt.Errorf("cannot delete entry from 'scheduled' table: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("cannot count deleted entries: %w", err)
+	}
+	return n > 0, nil
+}
+
+// CancelMessage removes the entries holding the message with the given
+// message ID. It returns false if there are no such entries.
+func (s *Scheduler) CancelMessage(messageID string) (bool, error) {
+	result, err := s.database.Exec(`DELETE FROM scheduled WHERE message_id=?`, messageID)
+	if err != nil {
+		return false, fmt.Errorf("cannot delete entries from 'scheduled' table: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("cannot count deleted entries: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetEntries retrieves a list of all the scheduled entries, in the order they
+// are due, in a format suitable for displaying to a user.
+func (s *Scheduler) GetEntries() ([]map[string]string, error) {
+	entries, err := s.query(`ORDER BY next, id`)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := []map[string]string{}
+	for _, entry := range entries {
+		summaries = append(summaries, entry.Summary())
+	}
+	return summaries, nil
+}
+
+// Summary returns the entry, without the message content, in a format
+// suitable for displaying to a user.
+func (e *Entry) Summary() map[string]string {
+	return map[string]string{
+		"id":          strconv.FormatInt(e.ID, 10),
+		"message_id":  e.MessageID,
+		"directive":   e.Directive,
+		"received":    e.Received.String(),
+		"next":        e.Next.String(),
+		"attempts":    strconv.Itoa(e.Attempts),
+		"cron":        e.Cron,
+		"response_to": e.Message.ResponseTo,
+	}
+}
+
+// query selects the entries matching the clause, which is appended to the
+// SELECT statement.
+func (s *Scheduler) query(clause string, args ...interface{}) ([]*Entry, error) {
+	rows, err := s.database.Query(
+		`SELECT id, message_id, directive, received, next, due, attempts, cron, message `+
+			`FROM scheduled `+clause,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot execute query to retrieve scheduled entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []*Entry{}
+	for rows.Next() {
+		var entry Entry
+		var next int64
+		var due int64
+		var cron sql.NullString
+		var encodedMessage []byte
+
+		err := rows.Scan(
+			&entry.ID,
+			&entry.MessageID,
+			&entry.Directive,
+			&entry.Received,
+			&next,
+			&due,
+			&entry.Attempts,
+			&cron,
+			&encodedMessage,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("cannot scan scheduled entry columns: %w", err)
+		}
+		entry.Next = time.Unix(next, 0)
+		entry.Due = time.Unix(due, 0)
+		entry.Cron = cron.String
+		if err := json.Unmarshal(encodedMessage, &entry.Message); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal message: %w", err)
+		}
+		entries = append(entries, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot iterate queried scheduled entries: %w", err)
+	}
+
+	return entries, nil
+}
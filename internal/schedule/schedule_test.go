@@ -0,0 +1,353 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/redhatinsights/yggdrasil"
+)
+
+// recorder records the messages dispatched by a Scheduler and the messages it
+// failed to dispatch.
+type recorder struct {
+	dispatched []yggdrasil.Data
+	failed     []yggdrasil.Data
+	attempts   int
+	err        error
+}
+
+func (r *recorder) dispatch(data yggdrasil.Data) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.dispatched = append(r.dispatched, data)
+	return nil
+}
+
+func (r *recorder) fail(data yggdrasil.Data, attempts int, err error) {
+	r.failed = append(r.failed, data)
+	r.attempts = attempts
+}
+
+func openScheduler(t *testing.T, r *recorder, now *time.Time) *Scheduler {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "schedule.db"), r.dispatch, r.fail, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func TestFirstRun(t *testing.T) {
+	now := time.Date(2024, 1, 3, 10, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		description string
+		input       map[string]string
+		want        time.Time
+		wantError   error
+	}{
+		{
+			description: "not before",
+			input:       map[string]string{MetadataKeyNotBefore: "2024-01-05T02:00:00Z"},
+			want:        time.Date(2024, 1, 5, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "cron",
+			input:       map[string]string{MetadataKeyCron: "0 2 * * *"},
+			want:        time.Date(2024, 1, 4, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "cron matching not before",
+			input: map[string]string{
+				MetadataKeyNotBefore: "2024-01-05T02:00:00Z",
+				MetadataKeyCron:      "0 2 * * *",
+			},
+			want: time.Date(2024, 1, 5, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "cron with past not before",
+			input: map[string]string{
+				MetadataKeyNotBefore: "2023-01-01T00:00:00Z",
+				MetadataKeyCron:      "0 2 * * *",
+			},
+			want: time.Date(2024, 1, 4, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "invalid not before",
+			input:       map[string]string{MetadataKeyNotBefore: "tomorrow"},
+			wantError:   ErrInvalidSchedule,
+		},
+		{
+			description: "invalid cron",
+			input:       map[string]string{MetadataKeyCron: "at noon"},
+			wantError:   ErrInvalidSchedule,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := firstRun(yggdrasil.Data{Metadata: test.input}, now)
+			if test.wantError != nil {
+				if !errors.Is(err, test.wantError) {
+					t.Fatalf("%v != %v", err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRunDue(t *testing.T) {
+	now := time.Date(2024, 1, 3, 10, 30, 0, 0, time.UTC)
+	r := &recorder{}
+	s := openScheduler(t, r, &now)
+
+	messages := []yggdrasil.Data{
+		{
+			MessageID: "once",
+			Directive: "echo",
+			Metadata: map[string]string{
+				MetadataKeyNotBefore: "2024-01-03T11:00:00Z",
+				"a":                  "b",
+			},
+			Content: json.RawMessage(`{}`),
+		},
+		{
+			MessageID: "hourly",
+			Directive: "echo",
+			Metadata:  map[string]string{MetadataKeyCron: "45 * * * *"},
+			Content:   json.RawMessage(`{}`),
+		},
+	}
+	for _, msg := range messages {
+		if _, err := s.Add(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next, err := s.runDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.dispatched) != 0 {
+		t.Fatalf("unexpected dispatch: %v", r.dispatched)
+	}
+	if want := time.Date(2024, 1, 3, 10, 45, 0, 0, time.UTC); !next.Equal(want) {
+		t.Fatalf("%v != %v", next, want)
+	}
+
+	now = time.Date(2024, 1, 3, 11, 0, 0, 0, time.UTC)
+	next, err = s.runDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 3, 11, 45, 0, 0, time.UTC); !next.Equal(want) {
+		t.Fatalf("%v != %v", next, want)
+	}
+
+	got := []string{}
+	for _, msg := range r.dispatched {
+		got = append(got, msg.MessageID)
+	}
+	if !cmp.Equal(got, []string{"hourly", "once"}) {
+		t.Errorf("%v", cmp.Diff(got, []string{"hourly", "once"}))
+	}
+	if !cmp.Equal(r.dispatched[1].Metadata, map[string]string{"a": "b"}) {
+		t.Errorf("%v", cmp.Diff(r.dispatched[1].Metadata, map[string]string{"a": "b"}))
+	}
+	if !r.dispatched[1].Sent.Equal(now) {
+		t.Errorf("%v != %v", r.dispatched[1].Sent, now)
+	}
+
+	entries, err := s.GetEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0]["message_id"] != "hourly" {
+		t.Errorf("unexpected entries: %v", entries)
+	}
+}
+
+func TestRunDueRetry(t *testing.T) {
+	now := time.Date(2024, 1, 3, 10, 30, 0, 0, time.UTC)
+	r := &recorder{err: errors.New("dispatch queue is full")}
+	s := openScheduler(t, r, &now)
+
+	msg := yggdrasil.Data{
+		MessageID: "once",
+		Metadata:  map[string]string{MetadataKeyNotBefore: "2024-01-03T10:00:00Z"},
+		Content:   json.RawMessage(`{}`),
+	}
+	if _, err := s.Add(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := s.runDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(retryInterval); !next.Equal(want) {
+		t.Errorf("%v != %v", next, want)
+	}
+
+	// The retried message is sent as of the time it was due, so that its TTL
+	// is not extended by the retries.
+	r.err = nil
+	now = next
+	if _, err := s.runDue(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.dispatched) != 1 {
+		t.Fatalf("%v != %v", len(r.dispatched), 1)
+	}
+	if want := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC); !r.dispatched[0].Sent.Equal(want) {
+		t.Errorf("%v != %v", r.dispatched[0].Sent, want)
+	}
+}
+
+func TestRunDueAttempts(t *testing.T) {
+	now := time.Date(2024, 1, 3, 10, 30, 0, 0, time.UTC)
+	r := &recorder{err: errors.New("no worker for directive: echo")}
+	s := openScheduler(t, r, &now)
+
+	msg := yggdrasil.Data{
+		MessageID: "hourly",
+		Directive: "echo",
+		Metadata:  map[string]string{MetadataKeyCron: "0 * * * *"},
+		Content:   json.RawMessage(`{}`),
+	}
+	if _, err := s.Add(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// The message is given up on after the last attempt, even though its
+	// cron expression matches again.
+	now = time.Date(2024, 1, 3, 11, 0, 0, 0, time.UTC)
+	for i := 0; i < maxDispatchAttempts; i++ {
+		if len(r.failed) != 0 {
+			t.Fatalf("message failed after %v attempts", i)
+		}
+		next, err := s.runDue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = next
+	}
+
+	if len(r.failed) != 1 || r.failed[0].MessageID != "hourly" {
+		t.Fatalf("unexpected failed messages: %v", r.failed)
+	}
+	if r.attempts != maxDispatchAttempts {
+		t.Errorf("%v != %v", r.attempts, maxDispatchAttempts)
+	}
+	entries, err := s.GetEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("unexpected entries: %v", entries)
+	}
+}
+
+func TestRunDueSent(t *testing.T) {
+	now := time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)
+	r := &recorder{}
+	s := openScheduler(t, r, &now)
+
+	// A message sent after its not-before time keeps its sent time.
+	sent := time.Date(2024, 1, 3, 11, 0, 0, 0, time.UTC)
+	msg := yggdrasil.Data{
+		MessageID: "late",
+		Sent:      sent,
+		TTL:       60,
+		Metadata:  map[string]string{MetadataKeyNotBefore: "2024-01-03T10:00:00Z"},
+		Content:   json.RawMessage(`{}`),
+	}
+	if _, err := s.Add(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.runDue(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.dispatched) != 1 {
+		t.Fatalf("%v != %v", len(r.dispatched), 1)
+	}
+	if !r.dispatched[0].Sent.Equal(sent) {
+		t.Errorf("%v != %v", r.dispatched[0].Sent, sent)
+	}
+	if r.dispatched[0].TTL != 60 {
+		t.Errorf("%v != %v", r.dispatched[0].TTL, 60)
+	}
+}
+
+func TestAddFull(t *testing.T) {
+	now := time.Date(2024, 1, 3, 10, 30, 0, 0, time.UTC)
+	s := openScheduler(t, &recorder{}, &now)
+
+	for _, id := range []string{"1", "2", "3"} {
+		msg := yggdrasil.Data{
+			MessageID: id,
+			Metadata:  map[string]string{MetadataKeyCron: "0 2 * * *"},
+			Content:   json.RawMessage(`{}`),
+		}
+		_, err := s.Add(msg)
+		if id == "3" {
+			if !errors.Is(err, ErrFull) {
+				t.Errorf("%v != %v", err, ErrFull)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCancelMessage(t *testing.T) {
+	now := time.Date(2024, 1, 3, 10, 30, 0, 0, time.UTC)
+	s := openScheduler(t, &recorder{}, &now)
+
+	msg := yggdrasil.Data{
+		MessageID: "1234",
+		Metadata:  map[string]string{MetadataKeyCron: "0 2 * * *"},
+		Content:   json.RawMessage(`{}`),
+	}
+	if _, err := s.Add(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.CancelMessage("5678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got {
+		t.Errorf("cancelled unknown message")
+	}
+	got, err = s.CancelMessage("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got {
+		t.Errorf("message was not cancelled")
+	}
+
+	entries, err := s.GetEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("unexpected entries: %v", entries)
+	}
+}
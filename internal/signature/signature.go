@@ -0,0 +1,406 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redhatinsights/yggdrasil"
+)
+
+const (
+	// MetadataKeySignature is the data message metadata key that may carry
+	// the signature when the envelope "signature" field is empty.
+	MetadataKeySignature = "signature"
+
+	// MetadataKeySigningCertificate is the data message metadata key that may
+	// carry the signing certificate when the envelope "signing_certificate"
+	// field is empty.
+	MetadataKeySigningCertificate = "signing-certificate"
+
+	// ControlDirective is the name used in the list of required directives to
+	// require signatures on control messages.
+	ControlDirective = "@control"
+
+	// AnyDirective is the name used in the list of required directives to
+	// require signatures on all data messages.
+	AnyDirective = "*"
+)
+
+// ErrUnsigned is returned when a message that requires a signature is not
+// signed.
+var ErrUnsigned = errors.New("message is not signed")
+
+// ErrInvalid is returned when a message signature cannot be verified.
+var ErrInvalid = errors.New("invalid signature")
+
+// ErrReplayed is returned when a signed message was sent outside the replay
+// window, or a signed message with the same ID was already received within it,
+// so that its signature may have been captured and sent again.
+var ErrReplayed = errors.New("signed message replayed")
+
+// Verifier verifies detached signatures of messages received from the server.
+// A message may be signed by one of a set of trusted public keys, or by a key
+// whose certificate is issued by a trusted certificate authority. Ed25519 and
+// ECDSA keys are supported.
+type Verifier struct {
+	keys     []crypto.PublicKey
+	roots    *x509.CertPool
+	required []string
+	window   time.Duration
+	now      func() time.Time
+
+	// seen holds the sent time of each signed message received within the
+	// replay window, keyed by message ID.
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewVerifier creates a Verifier trusting the public keys in the PEM files
+// keyFiles and certificates issued by the certificate authorities in the PEM
+// files caFiles. Signatures are required on messages sent to the directives
+// listed in required. A message with a signature that cannot be verified is
+// rejected regardless of whether its directive requires a signature. A signed
+// message is also rejected if it was sent more than window before or after
+// the current time, or if a signed message with the same ID was already
+// received. A window of zero disables both checks.
+func NewVerifier(
+	keyFiles []string,
+	caFiles []string,
+	required []string,
+	window time.Duration,
+) (*Verifier, error) {
+	v := Verifier{
+		required: required,
+		window:   window,
+		now:      time.Now,
+		seen:     make(map[string]time.Time),
+	}
+
+	for _, file := range keyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read key file: %w", err)
+		}
+		keys, err := parsePublicKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse key file '%v': %w", file, err)
+		}
+		v.keys = append(v.keys, keys...)
+	}
+
+	if len(caFiles) > 0 {
+		v.roots = x509.NewCertPool()
+		for _, file := range caFiles {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read CA file: %w", err)
+			}
+			if !v.roots.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("cannot parse CA file '%v'", file)
+			}
+		}
+	}
+
+	if len(v.required) > 0 && len(v.keys) == 0 && v.roots == nil {
+		return nil, fmt.Errorf("signatures are required but no trusted keys are configured")
+	}
+
+	return &v, nil
+}
+
+// VerifyData verifies the signature of a data message. targets are the
+// scrubbed names of every worker the message may be dispatched to, including
+// its scrubbed directive. A signature is required if any of them is listed in
+// the required directives.
+func (v *Verifier) VerifyData(msg *yggdrasil.Data, targets []string) error {
+	return v.verifyData(msg, targets, true)
+}
+
+// VerifyStoredData verifies the signature of a data message that was verified
+// when it was received and has been stored since, such as a message requeued
+// from the dead-letter store. It is verified like VerifyData, except that the
+// message is not checked against the replay window, since it is not received
+// from the server again.
+func (v *Verifier) VerifyStoredData(msg *yggdrasil.Data, targets []string) error {
+	return v.verifyData(msg, targets, false)
+}
+
+// verifyData verifies the signature of a data message, checking it against the
+// replay window if replay is true.
+func (v *Verifier) verifyData(msg *yggdrasil.Data, targets []string, replay bool) error {
+	sig := msg.Signature
+	if sig == "" {
+		sig = msg.Metadata[MetadataKeySignature]
+	}
+	cert := msg.SigningCertificate
+	if cert == "" {
+		cert = msg.Metadata[MetadataKeySigningCertificate]
+	}
+
+	required := slices.Contains(v.required, AnyDirective) ||
+		slices.ContainsFunc(targets, func(target string) bool {
+			return slices.Contains(v.required, target)
+		})
+
+	return v.verify(
+		SignedBytes(
+			msg.Type,
+			msg.MessageID,
+			msg.ResponseTo,
+			msg.Directive,
+			msg.Sent,
+			msg.TTL,
+			msg.Metadata,
+			msg.Content,
+		),
+		sig,
+		cert,
+		required,
+		replay,
+		msg.MessageID,
+		msg.Sent,
+	)
+}
+
+// VerifyControl verifies the signature of a control message.
+func (v *Verifier) VerifyControl(msg *yggdrasil.Control) error {
+	return v.verify(
+		SignedBytes(msg.Type, msg.MessageID, msg.ResponseTo, "", msg.Sent, 0, nil, msg.Content),
+		msg.Signature,
+		msg.SigningCertificate,
+		slices.Contains(v.required, ControlDirective),
+		true,
+		msg.MessageID,
+		msg.Sent,
+	)
+}
+
+// SignedBytes returns the bytes covered by a message signature: the message
+// type, ID, response ID, directive, sent time in RFC 3339 format in UTC, TTL
+// in seconds and metadata, each followed by a newline, followed by the message
+// content exactly as it appears in the message. The metadata is encoded as a
+// JSON object with its keys sorted, leaving out the keys that carry the
+// signature and signing certificate.
+func SignedBytes(
+	messageType yggdrasil.MessageType,
+	messageID string,
+	responseTo string,
+	directive string,
+	sent time.Time,
+	ttl int,
+	metadata map[string]string,
+	content []byte,
+) []byte {
+	signedMetadata := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		if key == MetadataKeySignature || key == MetadataKeySigningCertificate {
+			continue
+		}
+		signedMetadata[key] = value
+	}
+	// Marshalling a map of strings cannot fail, and sorts the keys.
+	encodedMetadata, _ := json.Marshal(signedMetadata)
+
+	var buf bytes.Buffer
+	for _, field := range []string{
+		string(messageType),
+		messageID,
+		responseTo,
+		directive,
+		sent.UTC().Format(time.RFC3339Nano),
+		strconv.Itoa(ttl),
+		string(encodedMetadata),
+	} {
+		buf.WriteString(field)
+		buf.WriteByte('\n')
+	}
+	buf.Write(content)
+	return buf.Bytes()
+}
+
+// verify checks the base64 encoded signature sig of signed. If certPEM is not
+// empty, the signature must be made by the key of that certificate, which must
+// be issued by a trusted certificate authority. Otherwise the signature must be
+// made by one of the trusted keys. If replay is true, a valid signature of a
+// message sent outside the replay window, or of a message whose ID was already
+// received, is rejected.
+func (v *Verifier) verify(
+	signed []byte,
+	sig string,
+	certPEM string,
+	required bool,
+	replay bool,
+	messageID string,
+	sent time.Time,
+) error {
+	if sig == "" {
+		if required {
+			return ErrUnsigned
+		}
+		return nil
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("%w: cannot decode signature: %v", ErrInvalid, err)
+	}
+
+	if certPEM != "" {
+		cert, err := v.verifyCertificate([]byte(certPEM))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if !verifySignature(cert.PublicKey, signed, signature) {
+			return fmt.Errorf("%w: signature does not match certificate", ErrInvalid)
+		}
+		if !replay {
+			return nil
+		}
+		return v.checkReplay(messageID, sent)
+	}
+
+	for _, key := range v.keys {
+		if verifySignature(key, signed, signature) {
+			if !replay {
+				return nil
+			}
+			return v.checkReplay(messageID, sent)
+		}
+	}
+	return fmt.Errorf("%w: signature does not match a trusted key", ErrInvalid)
+}
+
+// checkReplay returns ErrReplayed if sent is further than the replay window
+// from the current time, or if messageID was already received within it.
+// Message IDs sent before the start of the window are forgotten, since a
+// message sent then is rejected regardless.
+func (v *Verifier) checkReplay(messageID string, sent time.Time) error {
+	if v.window <= 0 {
+		return nil
+	}
+	now := v.now()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for id, t := range v.seen {
+		if now.Sub(t) > v.window {
+			delete(v.seen, id)
+		}
+	}
+
+	if age := now.Sub(sent); age > v.window || age < -v.window {
+		return fmt.Errorf("%w: sent at %v", ErrReplayed, sent)
+	}
+	if _, has := v.seen[messageID]; has {
+		return fmt.Errorf("%w: message %v already received", ErrReplayed, messageID)
+	}
+	v.seen[messageID] = sent
+	return nil
+}
+
+// verifyCertificate parses the PEM encoded certificate, verifying that it is
+// issued by a trusted certificate authority.
+func (v *Verifier) verifyCertificate(certPEM []byte) (*x509.Certificate, error) {
+	if v.roots == nil {
+		return nil, fmt.Errorf("no trusted certificate authorities are configured")
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("cannot decode signing certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse signing certificate: %w", err)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     v.roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify signing certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// verifySignature reports whether signature is a valid signature of signed by
+// key. ECDSA signatures are ASN.1 encoded and made over a digest of signed
+// using the hash function that matches the size of the curve.
+func verifySignature(key crypto.PublicKey, signed []byte, signature []byte) bool {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, signed, signature)
+	case *ecdsa.PublicKey:
+		var digest []byte
+		switch k.Curve {
+		case elliptic.P384():
+			sum := sha512.Sum384(signed)
+			digest = sum[:]
+		case elliptic.P521():
+			sum := sha512.Sum512(signed)
+			digest = sum[:]
+		default:
+			sum := sha256.Sum256(signed)
+			digest = sum[:]
+		}
+		return ecdsa.VerifyASN1(k, digest, signature)
+	}
+	return false
+}
+
+// parsePublicKeys parses every PUBLIC KEY and CERTIFICATE block in data,
+// returning the Ed25519 and ECDSA public keys they contain.
+func parsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key crypto.PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			k, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse public key: %w", err)
+			}
+			key = k
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse certificate: %w", err)
+			}
+			key = cert.PublicKey
+		default:
+			continue
+		}
+
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found")
+	}
+	return keys, nil
+}
@@ -0,0 +1,544 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redhatinsights/yggdrasil"
+)
+
+// writePEM writes a single PEM block to a file in dir, returning its path.
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// marshalPublicKey returns the PKIX encoding of key.
+func marshalPublicKey(t *testing.T, key crypto.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// signECDSA returns the base64 encoded ECDSA P-256 signature of signed.
+func signECDSA(t *testing.T, key *ecdsa.PrivateKey, signed []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(signed)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestVerifyData(t *testing.T) {
+	dir := t.TempDir()
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, untrusted, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a CA and a signing certificate issued by it.
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(
+		rand.Reader,
+		&caTemplate,
+		&caTemplate,
+		&caKey.PublicKey,
+		caKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerTemplate := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signerDER, err := x509.CreateCertificate(
+		rand.Reader,
+		&signerTemplate,
+		caCert,
+		&signerKey.PublicKey,
+		caKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signerDER}))
+
+	verifier, err := NewVerifier(
+		[]string{
+			writePEM(t, dir, "ed25519.pem", "PUBLIC KEY", marshalPublicKey(t, edPublic)),
+			writePEM(t, dir, "ecdsa.pem", "PUBLIC KEY", marshalPublicKey(t, &ecKey.PublicKey)),
+		},
+		[]string{writePEM(t, dir, "ca.pem", "CERTIFICATE", caDER)},
+		[]string{"echo", "foo_bar"},
+		time.Hour,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each message has its own ID, so that none of them is rejected as
+	// replaying another.
+	var id int
+	newMessage := func(directive string) yggdrasil.Data {
+		id++
+		return yggdrasil.Data{
+			Type:      yggdrasil.MessageTypeData,
+			MessageID: strconv.Itoa(id),
+			Sent:      time.Now(),
+			Directive: directive,
+			Metadata:  map[string]string{"not-before": "2024-01-01T00:00:00Z"},
+			Content:   json.RawMessage(`{"hello":"world"}`),
+			TTL:       60,
+		}
+	}
+	signedBytes := func(msg yggdrasil.Data) []byte {
+		return SignedBytes(
+			msg.Type,
+			msg.MessageID,
+			msg.ResponseTo,
+			msg.Directive,
+			msg.Sent,
+			msg.TTL,
+			msg.Metadata,
+			msg.Content,
+		)
+	}
+	sign := func(msg yggdrasil.Data) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(edPrivate, signedBytes(msg)))
+	}
+
+	tests := []struct {
+		description string
+		input       func() yggdrasil.Data
+		targets     []string
+		wantError   error
+	}{
+		{
+			description: "ed25519 signature",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Signature = base64.StdEncoding.EncodeToString(
+					ed25519.Sign(edPrivate, signedBytes(msg)),
+				)
+				return msg
+			},
+		},
+		{
+			description: "ecdsa signature in metadata",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Metadata[MetadataKeySignature] = signECDSA(t, ecKey, signedBytes(msg))
+				return msg
+			},
+		},
+		{
+			description: "certificate signature",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Signature = signECDSA(t, signerKey, signedBytes(msg))
+				msg.SigningCertificate = signerPEM
+				return msg
+			},
+		},
+		{
+			description: "untrusted key",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Signature = base64.StdEncoding.EncodeToString(
+					ed25519.Sign(untrusted, signedBytes(msg)),
+				)
+				return msg
+			},
+			wantError: ErrInvalid,
+		},
+		{
+			description: "modified content",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Signature = base64.StdEncoding.EncodeToString(
+					ed25519.Sign(edPrivate, signedBytes(msg)),
+				)
+				msg.Content = json.RawMessage(`{"hello":"mars"}`)
+				return msg
+			},
+			wantError: ErrInvalid,
+		},
+		{
+			description: "modified directive",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Signature = base64.StdEncoding.EncodeToString(
+					ed25519.Sign(edPrivate, signedBytes(msg)),
+				)
+				msg.Directive = "other"
+				return msg
+			},
+			wantError: ErrInvalid,
+		},
+		{
+			description: "modified metadata",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Signature = sign(msg)
+				msg.Metadata["not-before"] = "2025-01-01T00:00:00Z"
+				return msg
+			},
+			wantError: ErrInvalid,
+		},
+		{
+			description: "added metadata",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Signature = sign(msg)
+				msg.Metadata["cron"] = "* * * * *"
+				return msg
+			},
+			wantError: ErrInvalid,
+		},
+		{
+			description: "modified sent",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Signature = sign(msg)
+				msg.Sent = msg.Sent.Add(time.Minute)
+				return msg
+			},
+			wantError: ErrInvalid,
+		},
+		{
+			description: "sent in another time zone",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Signature = sign(msg)
+				msg.Sent = msg.Sent.In(time.FixedZone("UTC+2", 2*60*60))
+				return msg
+			},
+		},
+		{
+			description: "modified ttl",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Signature = sign(msg)
+				msg.TTL = 0
+				return msg
+			},
+			wantError: ErrInvalid,
+		},
+		{
+			description: "replayed",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Sent = time.Now().Add(-2 * time.Hour)
+				msg.Signature = sign(msg)
+				return msg
+			},
+			wantError: ErrReplayed,
+		},
+		{
+			description: "sent in the future",
+			input: func() yggdrasil.Data {
+				msg := newMessage("echo")
+				msg.Sent = time.Now().Add(2 * time.Hour)
+				msg.Signature = sign(msg)
+				return msg
+			},
+			wantError: ErrReplayed,
+		},
+		{
+			description: "unsigned required",
+			input: func() yggdrasil.Data {
+				return newMessage("echo")
+			},
+			wantError: ErrUnsigned,
+		},
+		{
+			description: "unsigned dashed directive required",
+			input: func() yggdrasil.Data {
+				return newMessage("foo-bar")
+			},
+			targets:   []string{"foo_bar"},
+			wantError: ErrUnsigned,
+		},
+		{
+			description: "unsigned alias of required",
+			input: func() yggdrasil.Data {
+				return newMessage("old-echo")
+			},
+			targets:   []string{"old_echo", "echo"},
+			wantError: ErrUnsigned,
+		},
+		{
+			description: "unsigned old not required",
+			input: func() yggdrasil.Data {
+				msg := newMessage("other")
+				msg.Sent = time.Now().Add(-2 * time.Hour)
+				return msg
+			},
+		},
+		{
+			description: "unsigned not required",
+			input: func() yggdrasil.Data {
+				return newMessage("other")
+			},
+		},
+		{
+			description: "invalid not required",
+			input: func() yggdrasil.Data {
+				msg := newMessage("other")
+				msg.Signature = "not base64"
+				return msg
+			},
+			wantError: ErrInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			msg := test.input()
+			targets := test.targets
+			if targets == nil {
+				targets = []string{msg.Directive}
+			}
+			err := verifier.VerifyData(&msg, targets)
+			if !errors.Is(err, test.wantError) {
+				t.Errorf("%v != %v", err, test.wantError)
+			}
+		})
+	}
+}
+
+func TestVerifyControl(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writePEM(t, t.TempDir(), "key.pem", "PUBLIC KEY", marshalPublicKey(t, public))
+
+	newMessage := func(signed bool, sent time.Time) yggdrasil.Control {
+		msg := yggdrasil.Control{
+			Type:      yggdrasil.MessageTypeCommand,
+			MessageID: "1234",
+			Sent:      sent,
+			Content:   json.RawMessage(`{"command":"disconnect"}`),
+		}
+		if signed {
+			msg.Signature = base64.StdEncoding.EncodeToString(
+				ed25519.Sign(
+					private,
+					SignedBytes(
+						msg.Type,
+						msg.MessageID,
+						msg.ResponseTo,
+						"",
+						msg.Sent,
+						0,
+						nil,
+						msg.Content,
+					),
+				),
+			)
+		}
+		return msg
+	}
+
+	tests := []struct {
+		description string
+		required    []string
+		input       yggdrasil.Control
+		wantError   error
+	}{
+		{
+			description: "signed",
+			required:    []string{ControlDirective},
+			input:       newMessage(true, time.Now()),
+		},
+		{
+			description: "replayed",
+			required:    []string{ControlDirective},
+			input:       newMessage(true, time.Now().Add(-2*time.Hour)),
+			wantError:   ErrReplayed,
+		},
+		{
+			description: "unsigned required",
+			required:    []string{ControlDirective},
+			input:       newMessage(false, time.Now()),
+			wantError:   ErrUnsigned,
+		},
+		{
+			description: "unsigned not required",
+			required:    []string{AnyDirective},
+			input:       newMessage(false, time.Now()),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			verifier, err := NewVerifier([]string{keyFile}, nil, test.required, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = verifier.VerifyControl(&test.input)
+			if !errors.Is(err, test.wantError) {
+				t.Errorf("%v != %v", err, test.wantError)
+			}
+		})
+	}
+}
+
+func TestVerifyReplayedID(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writePEM(t, t.TempDir(), "key.pem", "PUBLIC KEY", marshalPublicKey(t, public))
+
+	verifier, err := NewVerifier([]string{keyFile}, nil, []string{ControlDirective}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	msg := yggdrasil.Control{
+		Type:      yggdrasil.MessageTypeCommand,
+		MessageID: "1234",
+		Sent:      now,
+		Content:   json.RawMessage(`{"command":"disconnect"}`),
+	}
+	msg.Signature = base64.StdEncoding.EncodeToString(
+		ed25519.Sign(
+			private,
+			SignedBytes(msg.Type, msg.MessageID, msg.ResponseTo, "", msg.Sent, 0, nil, msg.Content),
+		),
+	)
+
+	if err := verifier.VerifyControl(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifyControl(&msg); !errors.Is(err, ErrReplayed) {
+		t.Errorf("%v != %v", err, ErrReplayed)
+	}
+
+	// Once the message is outside the window its ID is forgotten, and the
+	// message is still rejected by its sent time.
+	now = now.Add(2 * time.Hour)
+	if err := verifier.VerifyControl(&msg); !errors.Is(err, ErrReplayed) {
+		t.Errorf("%v != %v", err, ErrReplayed)
+	}
+	verifier.mu.Lock()
+	defer verifier.mu.Unlock()
+	if len(verifier.seen) != 0 {
+		t.Errorf("%v != %v", len(verifier.seen), 0)
+	}
+}
+
+func TestVerifyStoredData(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writePEM(t, t.TempDir(), "key.pem", "PUBLIC KEY", marshalPublicKey(t, public))
+
+	verifier, err := NewVerifier([]string{keyFile}, nil, []string{"echo"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := yggdrasil.Data{
+		Type:      yggdrasil.MessageTypeData,
+		MessageID: "1234",
+		Sent:      time.Now().Add(-2 * time.Hour),
+		Directive: "echo",
+		Content:   json.RawMessage(`{}`),
+	}
+	msg.Signature = base64.StdEncoding.EncodeToString(
+		ed25519.Sign(
+			private,
+			SignedBytes(
+				msg.Type,
+				msg.MessageID,
+				msg.ResponseTo,
+				msg.Directive,
+				msg.Sent,
+				msg.TTL,
+				msg.Metadata,
+				msg.Content,
+			),
+		),
+	)
+
+	// A stored message is not checked against the replay window, and may be
+	// verified more than once.
+	for i := 0; i < 2; i++ {
+		if err := verifier.VerifyStoredData(&msg, []string{"echo"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := verifier.VerifyData(&msg, []string{"echo"}); !errors.Is(err, ErrReplayed) {
+		t.Errorf("%v != %v", err, ErrReplayed)
+	}
+
+	msg.Signature = ""
+	if err := verifier.VerifyStoredData(&msg, []string{"echo"}); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("%v != %v", err, ErrUnsigned)
+	}
+}
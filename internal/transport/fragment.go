@@ -0,0 +1,263 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/google/uuid"
+)
+
+const (
+	fragmentTypeManifest = "fragment-manifest"
+	fragmentTypeChunk    = "fragment"
+
+	// fragmentMaxPayloadSize is the largest fragmented payload that is
+	// reassembled.
+	fragmentMaxPayloadSize = 32 * 1024 * 1024
+
+	// fragmentMaxPendingSize is the largest number of bytes of chunk data held
+	// for all the fragmented payloads that are not complete.
+	fragmentMaxPendingSize = 64 * 1024 * 1024
+)
+
+// fragment is the envelope of a message published as part of a fragmented
+// payload. A fragmented payload is published as a manifest, describing the
+// complete payload, followed by a number of chunks. Chunk data is base64
+// encoded by the JSON encoder.
+type fragment struct {
+	Type      string `json:"type"`
+	MessageID string `json:"message_id"`
+	Index     int    `json:"index,omitempty"`
+	Fragments int    `json:"fragments,omitempty"`
+	Size      int    `json:"size,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	Data      []byte `json:"data,omitempty"`
+}
+
+// fragmentPayload splits data into a manifest followed by numbered chunks so
+// that no published message is larger than maxSize. If data is no larger than
+// maxSize, or maxSize is zero, data is returned as the only message.
+func fragmentPayload(data []byte, maxSize int) ([][]byte, error) {
+	if maxSize <= 0 || len(data) <= maxSize {
+		return [][]byte{data}, nil
+	}
+
+	var message struct {
+		MessageID string `json:"message_id"`
+	}
+	_ = json.Unmarshal(data, &message)
+	if message.MessageID == "" {
+		message.MessageID = uuid.New().String()
+	}
+
+	// The envelope of a chunk is no larger than that of an empty chunk with an
+	// index of len(data), plus the data field. Base64 encodes every 3 bytes of
+	// chunk data as 4 bytes.
+	envelope, err := json.Marshal(fragment{
+		Type:      fragmentTypeChunk,
+		MessageID: message.MessageID,
+		Index:     len(data),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal chunk: %w", err)
+	}
+	overhead := len(envelope) + len(`,"data":""`)
+	chunkSize := (maxSize - overhead) / 4 * 3
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("fragment size %v is too small", maxSize)
+	}
+
+	sum := sha256.Sum256(data)
+	count := (len(data) + chunkSize - 1) / chunkSize
+
+	manifest, err := json.Marshal(fragment{
+		Type:      fragmentTypeManifest,
+		MessageID: message.MessageID,
+		Fragments: count,
+		Size:      len(data),
+		SHA256:    hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal manifest: %w", err)
+	}
+
+	payloads := [][]byte{manifest}
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk, err := json.Marshal(fragment{
+			Type:      fragmentTypeChunk,
+			MessageID: message.MessageID,
+			Index:     i,
+			Data:      data[i*chunkSize : end],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal chunk: %w", err)
+		}
+		payloads = append(payloads, chunk)
+	}
+
+	return payloads, nil
+}
+
+// pendingPayload is a fragmented payload that has not been fully received.
+type pendingPayload struct {
+	manifest *fragment
+	chunks   map[int][]byte
+	size     int
+	timer    *time.Timer
+}
+
+// reassembler collects the manifest and chunks of fragmented payloads,
+// keyed by message ID, returning each payload once it is complete. Payloads
+// that are not complete within the timeout, that are larger than maxPayload
+// bytes, or whose chunks would take the data held for all payloads over
+// maxPending bytes are discarded.
+type reassembler struct {
+	mu         sync.Mutex
+	pending    map[string]*pendingPayload
+	size       int
+	timeout    time.Duration
+	maxPayload int
+	maxPending int
+}
+
+func newReassembler(timeout time.Duration) *reassembler {
+	return &reassembler{
+		pending:    make(map[string]*pendingPayload),
+		timeout:    timeout,
+		maxPayload: fragmentMaxPayloadSize,
+		maxPending: fragmentMaxPendingSize,
+	}
+}
+
+// Add adds a received message to the reassembler. If the message is not part
+// of a fragmented payload, it is returned unchanged. If the message completes a
+// fragmented payload, the reassembled payload is returned. Otherwise, Add
+// returns false until the remaining parts of the payload are received.
+func (r *reassembler) Add(data []byte) ([]byte, bool, error) {
+	var f fragment
+	if err := json.Unmarshal(data, &f); err != nil {
+		return data, true, nil
+	}
+	if f.Type != fragmentTypeManifest && f.Type != fragmentTypeChunk {
+		return data, true, nil
+	}
+	if f.MessageID == "" {
+		return nil, false, fmt.Errorf("fragment is missing message ID")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, has := r.pending[f.MessageID]
+	if !has {
+		p = &pendingPayload{chunks: make(map[int][]byte)}
+		messageID := f.MessageID
+		if r.timeout > 0 {
+			p.timer = time.AfterFunc(r.timeout, func() {
+				r.mu.Lock()
+				defer r.mu.Unlock()
+				if r.pending[messageID] == p {
+					r.discard(messageID)
+					log.Errorf("discarding incomplete fragmented message %v: timeout", messageID)
+				}
+			})
+		}
+		r.pending[f.MessageID] = p
+	}
+
+	switch f.Type {
+	case fragmentTypeManifest:
+		if f.Fragments <= 0 || f.Size < 0 {
+			r.discard(f.MessageID)
+			return nil, false, fmt.Errorf("invalid manifest for message %v", f.MessageID)
+		}
+		if f.Size > r.maxPayload || p.size > f.Size {
+			r.discard(f.MessageID)
+			return nil, false, fmt.Errorf(
+				"message %v is too large: %v bytes",
+				f.MessageID,
+				max(f.Size, p.size),
+			)
+		}
+		p.manifest = &f
+	case fragmentTypeChunk:
+		if f.Index < 0 || (p.manifest != nil && f.Index >= p.manifest.Fragments) {
+			r.discard(f.MessageID)
+			return nil, false, fmt.Errorf(
+				"invalid chunk index %v for message %v",
+				f.Index,
+				f.MessageID,
+			)
+		}
+		size := p.size - len(p.chunks[f.Index]) + len(f.Data)
+		limit := r.maxPayload
+		if p.manifest != nil {
+			limit = p.manifest.Size
+		}
+		if size > limit {
+			r.discard(f.MessageID)
+			return nil, false, fmt.Errorf("message %v is too large: %v bytes", f.MessageID, size)
+		}
+		if r.size+size-p.size > r.maxPending {
+			r.discard(f.MessageID)
+			return nil, false, fmt.Errorf(
+				"discarding message %v: more than %v bytes of fragments are pending",
+				f.MessageID,
+				r.maxPending,
+			)
+		}
+		r.size += size - p.size
+		p.size = size
+		p.chunks[f.Index] = f.Data
+	}
+
+	if p.manifest == nil || len(p.chunks) < p.manifest.Fragments {
+		return nil, false, nil
+	}
+	r.discard(f.MessageID)
+
+	var buf bytes.Buffer
+	for i := 0; i < p.manifest.Fragments; i++ {
+		chunk, has := p.chunks[i]
+		if !has {
+			return nil, false, fmt.Errorf("missing chunk %v for message %v", i, f.MessageID)
+		}
+		buf.Write(chunk)
+	}
+	if buf.Len() != p.manifest.Size {
+		return nil, false, fmt.Errorf(
+			"size mismatch for message %v: %v != %v",
+			f.MessageID,
+			buf.Len(),
+			p.manifest.Size,
+		)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if hex.EncodeToString(sum[:]) != p.manifest.SHA256 {
+		return nil, false, fmt.Errorf("checksum mismatch for message %v", f.MessageID)
+	}
+
+	return buf.Bytes(), true, nil
+}
+
+// discard stops tracking the payload with the given message ID. r.mu must be
+// held by the caller.
+func (r *reassembler) discard(messageID string) {
+	if p, has := r.pending[messageID]; has {
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		r.size -= p.size
+		delete(r.pending, messageID)
+	}
+}
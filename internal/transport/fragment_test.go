@@ -0,0 +1,250 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFragmentPayload(t *testing.T) {
+	large := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(large)
+
+	tests := []struct {
+		description string
+		data        []byte
+		maxSize     int
+		wantCount   int
+	}{
+		{
+			description: "disabled",
+			data:        large,
+			wantCount:   1,
+		},
+		{
+			description: "small payload",
+			data:        []byte(`{"message_id":"1234"}`),
+			maxSize:     1024,
+			wantCount:   1,
+		},
+		{
+			description: "large payload",
+			data:        large,
+			maxSize:     1024,
+			wantCount:   7,
+		},
+		{
+			description: "long message ID",
+			data: []byte(
+				`{"message_id":"` + strings.Repeat("a", 512) + `","content":"` +
+					strings.Repeat("b", 2048) + `"}`,
+			),
+			maxSize:   1024,
+			wantCount: 9,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := fragmentPayload(test.data, test.maxSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != test.wantCount {
+				t.Fatalf("%v != %v", len(got), test.wantCount)
+			}
+			for _, payload := range got {
+				if test.maxSize > 0 && len(payload) > test.maxSize {
+					t.Errorf("payload size %v exceeds %v", len(payload), test.maxSize)
+				}
+			}
+
+			r := newReassembler(time.Minute)
+			var result []byte
+			for i, payload := range got {
+				data, complete, err := r.Add(payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if complete != (i == len(got)-1) {
+					t.Fatalf("payload %v: unexpected completion state %v", i, complete)
+				}
+				result = data
+			}
+			if !bytes.Equal(result, test.data) {
+				t.Errorf("reassembled payload does not match")
+			}
+		})
+	}
+}
+
+func TestReassemble(t *testing.T) {
+	// A manifest followed by three chunks.
+	data := bytes.Repeat([]byte("012345"), 150)
+	payloads, err := fragmentPayload(data, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) != 4 {
+		t.Fatalf("%v != %v", len(payloads), 4)
+	}
+
+	corrupt := func(payload []byte) []byte {
+		var f fragment
+		if err := json.Unmarshal(payload, &f); err != nil {
+			t.Fatal(err)
+		}
+		f.Data[0] = 'x'
+		b, err := json.Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		description string
+		input       [][]byte
+		want        []byte
+		wantError   bool
+	}{
+		{
+			description: "chunks before manifest",
+			input:       append(append([][]byte{}, payloads[1:]...), payloads[0]),
+			want:        data,
+		},
+		{
+			description: "chunks out of order",
+			input:       [][]byte{payloads[0], payloads[3], payloads[2], payloads[1]},
+			want:        data,
+		},
+		{
+			description: "checksum mismatch",
+			input:       [][]byte{payloads[0], payloads[1], payloads[2], corrupt(payloads[3])},
+			wantError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := newReassembler(time.Minute)
+			var got []byte
+			var gotError bool
+			for _, payload := range test.input {
+				data, complete, err := r.Add(payload)
+				if err != nil {
+					gotError = true
+					break
+				}
+				if complete {
+					got = data
+				}
+			}
+			if gotError != test.wantError {
+				t.Fatalf("%v != %v", gotError, test.wantError)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("reassembled payload does not match")
+			}
+		})
+	}
+}
+
+func TestReassembleTimeout(t *testing.T) {
+	payloads, err := fragmentPayload(bytes.Repeat([]byte("a"), 1024), 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newReassembler(10 * time.Millisecond)
+	if _, _, err := r.Add(payloads[0]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) != 0 {
+		t.Errorf("%v != %v", len(r.pending), 0)
+	}
+}
+
+func TestReassembleLimits(t *testing.T) {
+	data := bytes.Repeat([]byte("012345"), 150)
+	payloads, err := fragmentPayload(data, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := fragmentPayload(bytes.Repeat([]byte("543210"), 150), 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		maxPayload  int
+		maxPending  int
+		input       [][]byte
+		wantError   bool
+	}{
+		{
+			description: "within limits",
+			maxPayload:  len(data),
+			maxPending:  len(data),
+			input:       payloads,
+		},
+		{
+			description: "manifest over payload limit",
+			maxPayload:  len(data) - 1,
+			maxPending:  len(data),
+			input:       payloads[:1],
+			wantError:   true,
+		},
+		{
+			description: "chunks over payload limit before manifest",
+			maxPayload:  len(data) / 2,
+			maxPending:  len(data),
+			input:       payloads[1:],
+			wantError:   true,
+		},
+		{
+			description: "chunks over pending limit",
+			maxPayload:  len(data),
+			maxPending:  len(data) + len(data)/2,
+			input:       append(append([][]byte{}, payloads[1:]...), other[1:]...),
+			wantError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := newReassembler(time.Minute)
+			r.maxPayload = test.maxPayload
+			r.maxPending = test.maxPending
+			var gotError bool
+			for _, payload := range test.input {
+				if _, _, err := r.Add(payload); err != nil {
+					gotError = true
+					break
+				}
+			}
+			if gotError != test.wantError {
+				t.Fatalf("%v != %v", gotError, test.wantError)
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			size := 0
+			for _, p := range r.pending {
+				size += p.size
+			}
+			if r.size != size {
+				t.Errorf("%v != %v", r.size, size)
+			}
+		})
+	}
+}
@@ -1,10 +1,12 @@
 package transport
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -25,14 +27,17 @@ type HTTPResponse struct {
 }
 
 // HTTP is a Transporter that sends and receives data and control
-// messages by sending HTTP requests to a URL.
+// messages by sending HTTP requests to a URL. When configured with more than one
+// server, requests are sent to the first healthy server, failing over to the
+// next server when a server cannot be reached or responds with a server error.
 type HTTP struct {
 	clientID        string
 	client          *internalhttp.Client
-	server          string
+	servers         *serverPool
 	dataHandler     RxHandlerFunc
 	pollingInterval time.Duration
 	disconnected    atomic.Value
+	cancel          atomic.Value
 	userAgent       string
 	isTLS           atomic.Value
 	events          chan TransporterEvent
@@ -41,11 +46,15 @@ type HTTP struct {
 
 func NewHTTPTransport(
 	clientID string,
-	server string,
+	servers []string,
 	tlsConfig *tls.Config,
 	userAgent string,
 	pollingInterval time.Duration,
 ) (*HTTP, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("cannot create HTTP transport: missing server")
+	}
+
 	disconnected := atomic.Value{}
 	disconnected.Store(false)
 	isTls := atomic.Value{}
@@ -55,7 +64,7 @@ func NewHTTPTransport(
 		client:          internalhttp.NewHTTPClient(tlsConfig.Clone(), userAgent),
 		pollingInterval: pollingInterval,
 		disconnected:    disconnected,
-		server:          server,
+		servers:         newServerPool(servers, config.DefaultConfig.HTTPFailoverCooldown),
 		userAgent:       userAgent,
 		isTLS:           isTls,
 		events:          make(chan TransporterEvent),
@@ -65,6 +74,9 @@ func NewHTTPTransport(
 func (t *HTTP) Connect() error {
 	t.disconnected.Store(false)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel.Store(cancel)
+
 	go func() {
 		for event := range t.events {
 			if t.eventHandler == nil {
@@ -74,66 +86,169 @@ func (t *HTTP) Connect() error {
 		}
 	}()
 
-	go func() {
-		for {
-			if t.disconnected.Load().(bool) {
-				return
-			}
-			resp, err := t.client.Get(t.getUrl("in", "control"))
-			if err != nil {
-				log.Tracef("cannot get HTTP request: %v", err)
-			}
-			if resp != nil {
-				data, err := io.ReadAll(resp.Body)
-				if err != nil {
-					log.Errorf("cannot read response body: %v", err)
-					continue
-				}
-				if t.dataHandler != nil {
-					metadata := make(map[string]interface{})
-					for k, v := range resp.Header {
-						metadata[k] = v
-					}
-					_ = t.dataHandler("control", metadata, data)
-				}
-				resp.Body.Close()
-			}
-			time.Sleep(t.pollingInterval)
+	go t.receive(ctx, "control")
+	go t.receive(ctx, "data")
+
+	t.events <- TransporterEventConnected
+
+	return nil
+}
+
+// receive requests messages for channel until the transport is disconnected.
+// Between requests it waits for the duration returned by poll. After a failed
+// request, it waits an increasing amount of time, starting at the polling
+// interval and doubling up to the configured maximum backoff.
+func (t *HTTP) receive(ctx context.Context, channel string) {
+	var backoff time.Duration
+	var lastEventID string
+
+	for {
+		if t.disconnected.Load().(bool) {
+			return
 		}
-	}()
 
-	go func() {
-		for {
-			if t.disconnected.Load().(bool) {
+		wait, err := t.poll(ctx, channel, &lastEventID)
+		if err != nil {
+			if ctx.Err() != nil {
 				return
 			}
-			resp, err := t.client.Get(t.getUrl("in", "data"))
-			if err != nil {
-				log.Tracef("cannot get HTTP request: %v", err)
-			}
+			backoff = nextBackoff(backoff, t.pollingInterval, config.DefaultConfig.HTTPMaxBackoff)
+			log.Errorf("cannot receive %v messages: %v: retrying in %v", channel, err, backoff)
+			wait = backoff
+		} else {
+			backoff = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// poll sends a single request for messages on channel, passing any messages
+// received to the data handler. The mode of the exchange is negotiated with the
+// server through headers. The request advertises support for server-sent
+// events and long-polling; the server either streams events (responding with a
+// "text/event-stream" content type), holds the request until a message is
+// available (responding with a "Preference-Applied: wait" header), or responds
+// immediately. A "204 No Content" response indicates that no message is
+// available. poll returns the duration to wait before the next request.
+func (t *HTTP) poll(
+	ctx context.Context,
+	channel string,
+	lastEventID *string,
+) (time.Duration, error) {
+	server := t.servers.Active()
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		t.getUrl(server, "in", channel),
+		nil,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create HTTP request: %w", err)
+	}
+	req.Header.Add("User-Agent", t.userAgent)
+	req.Header.Add("Accept", "text/event-stream, application/json;q=0.9")
+	if wait := config.DefaultConfig.HTTPLongPollWait; wait > 0 {
+		req.Header.Add("Prefer", fmt.Sprintf("wait=%d", int(wait.Seconds())))
+	}
+	if *lastEventID != "" {
+		req.Header.Add("Last-Event-ID", *lastEventID)
+	}
+
+	log.Debugf("sending HTTP request: %v %v", req.Method, req.URL)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			t.servers.Failed(server, err)
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
 
-			if resp != nil {
-				data, err := io.ReadAll(resp.Body)
-				if err != nil {
-					log.Errorf("cannot read response body: %v", err)
-					continue
-				}
-				if t.dataHandler != nil {
-					metadata := make(map[string]interface{})
-					for k, v := range resp.Header {
-						metadata[k] = v
-					}
-					_ = t.dataHandler("data", metadata, data)
-				}
-				resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		err := fmt.Errorf("%v", http.StatusText(resp.StatusCode))
+		t.servers.Failed(server, err)
+		return 0, err
+	}
+	t.servers.Succeeded(server)
+
+	metadata := make(map[string]interface{})
+	for k, v := range resp.Header {
+		metadata[k] = v
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return t.nextPoll(resp), nil
+	case resp.StatusCode >= 300:
+		return 0, fmt.Errorf("%v", http.StatusText(resp.StatusCode))
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		var retry time.Duration
+		err := readEvents(resp.Body, func(e sseEvent) error {
+			*lastEventID = e.ID
+			retry = e.Retry
+			// Events may name the channel they belong to, allowing a single
+			// stream to deliver both data and control messages.
+			addr := channel
+			if e.Event == "data" || e.Event == "control" {
+				addr = e.Event
+			}
+			if t.dataHandler != nil {
+				_ = t.dataHandler(addr, metadata, e.Data)
 			}
-			time.Sleep(t.pollingInterval)
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("cannot read event stream: %w", err)
 		}
-	}()
+		// The server closed the stream; reconnect after the delay it requested,
+		// if any.
+		return retry, nil
+	}
 
-	t.events <- TransporterEventConnected
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read response body: %w", err)
+	}
+	// A long-poll request that timed out without a message may be answered
+	// with an empty body rather than 204 No Content.
+	if len(data) == 0 {
+		return t.nextPoll(resp), nil
+	}
+	if t.dataHandler != nil {
+		_ = t.dataHandler(channel, metadata, data)
+	}
 
-	return nil
+	return t.nextPoll(resp), nil
+}
+
+// nextPoll returns the duration to wait before the next request. The next
+// request is sent immediately if the server held the request open.
+func (t *HTTP) nextPoll(resp *http.Response) time.Duration {
+	if strings.HasPrefix(resp.Header.Get("Preference-Applied"), "wait") {
+		return 0
+	}
+	return t.pollingInterval
+}
+
+// nextBackoff doubles the previous backoff, starting at initial and capped at
+// limit. A limit of zero leaves the backoff uncapped.
+func nextBackoff(prev time.Duration, initial time.Duration, limit time.Duration) time.Duration {
+	next := prev * 2
+	if next < initial {
+		next = initial
+	}
+	if limit > 0 && next > limit {
+		next = limit
+	}
+	return next
 }
 
 // ReloadTLSConfig creates a new HTTP client with the provided TLS config.
@@ -146,6 +261,9 @@ func (t *HTTP) ReloadTLSConfig(tlsConfig *tls.Config) error {
 func (t *HTTP) Disconnect(quiesce uint) {
 	time.Sleep(time.Millisecond * time.Duration(quiesce))
 	t.disconnected.Store(true)
+	if cancel, ok := t.cancel.Load().(context.CancelFunc); ok {
+		cancel()
+	}
 	t.events <- TransporterEventDisconnected
 }
 
@@ -157,13 +275,34 @@ func (t *HTTP) Tx(
 	if t.disconnected.Load().(bool) {
 		return TxResponseErr, nil, nil, fmt.Errorf("cannot perform Tx: transport is disconnected")
 	}
-	url := t.getUrl("out", addr)
 	headers := map[string]string{
 		"Content-Type": "application/json",
 	}
-	resp, err := t.client.Post(url, headers, data)
-	if err != nil && resp == nil {
-		return TxResponseErr, nil, nil, fmt.Errorf("cannot perform HTTP request: %w", err)
+
+	// Try each server in turn, failing over to the next server on connection
+	// errors or server errors. The response from the last server tried is
+	// returned.
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		server := t.servers.Active()
+		resp, err = t.client.Post(t.getUrl(server, "out", addr), headers, data)
+		if err != nil {
+			t.servers.Failed(server, err)
+			if attempt < t.servers.Len() {
+				continue
+			}
+			return TxResponseErr, nil, nil, fmt.Errorf("cannot perform HTTP request: %w", err)
+		}
+		if resp.StatusCode < 500 {
+			t.servers.Succeeded(server)
+			break
+		}
+		t.servers.Failed(server, fmt.Errorf("%v", http.StatusText(resp.StatusCode)))
+		if attempt >= t.servers.Len() {
+			break
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
 	}
 
 	responseCode = resp.StatusCode
@@ -196,12 +335,18 @@ func (t *HTTP) SetEventHandler(f EventHandlerFunc) error {
 	return nil
 }
 
-func (t *HTTP) getUrl(direction string, channel string) string {
+// ActiveServer returns the address of the server requests are currently sent
+// to.
+func (t *HTTP) ActiveServer() string {
+	return t.servers.Active()
+}
+
+func (t *HTTP) getUrl(server string, direction string, channel string) string {
 	protocol := "http"
 	if t.isTLS.Load().(bool) {
 		protocol = "https"
 	}
 	path := filepath.Join(config.DefaultConfig.PathPrefix, channel, t.clientID, direction)
 
-	return fmt.Sprintf("%s://%s/%s", protocol, t.server, path)
+	return fmt.Sprintf("%s://%s/%s", protocol, server, path)
 }
@@ -10,12 +10,16 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/redhatinsights/yggdrasil/internal/config"
 	"github.com/redhatinsights/yggdrasil/internal/transport"
 )
 
@@ -161,7 +165,7 @@ func TestTx(t *testing.T) {
 		t.Run(test.description, func(t *testing.T) {
 			httpTransport, err := transport.NewHTTPTransport(
 				test.clientID,
-				test.serverAddr,
+				[]string{test.serverAddr},
 				nil,
 				"testUA",
 				time.Second,
@@ -196,3 +200,171 @@ func TestTx(t *testing.T) {
 		})
 	}
 }
+
+func TestReceive(t *testing.T) {
+	tests := []struct {
+		description string
+		handler     http.HandlerFunc
+		want        []string
+	}{
+		{
+			description: "no content",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			want: nil,
+		},
+		{
+			description: "empty body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Preference-Applied", "wait=60")
+				w.WriteHeader(http.StatusOK)
+			},
+			want: nil,
+		},
+		{
+			description: "long-poll",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Prefer") != "wait=60" {
+					t.Errorf("unexpected Prefer header: %v", r.Header.Get("Prefer"))
+				}
+				w.Header().Set("Preference-Applied", "wait=60")
+				_, _ = fmt.Fprint(w, `{"message_id":"1"}`)
+			},
+			want: []string{`{"message_id":"1"}`},
+		},
+		{
+			description: "server-sent events",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = fmt.Fprint(w, "id: 1\ndata: {\"message_id\":\"1\"}\n\n")
+				_, _ = fmt.Fprint(w, "id: 2\ndata: {\"message_id\":\"2\"}\n\n")
+			},
+			want: []string{`{"message_id":"1"}`, `{"message_id":"2"}`},
+		},
+		{
+			description: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = fmt.Fprint(w, `{"message_id":"1"}`)
+			},
+			want: nil,
+		},
+	}
+
+	config.DefaultConfig.HTTPLongPollWait = time.Minute
+	defer func() { config.DefaultConfig.HTTPLongPollWait = 0 }()
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var served atomic.Int32
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/yggdrasil/data/test/in" {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				// Serve the response under test once, then no content.
+				if served.Add(1) > 1 {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				test.handler(w, r)
+			}
+			srv := httptest.NewServer(http.HandlerFunc(handler))
+			defer srv.Close()
+
+			httpTransport, err := transport.NewHTTPTransport(
+				"test",
+				[]string{strings.TrimPrefix(srv.URL, "http://")},
+				nil,
+				"testUA",
+				10*time.Millisecond,
+			)
+			if err != nil {
+				t.Fatalf("cannot create new transport: %v", err)
+			}
+
+			received := make(chan string, 8)
+			_ = httpTransport.SetRxHandler(
+				func(addr string, metadata map[string]interface{}, data []byte) error {
+					if addr != "data" {
+						t.Errorf("unexpected addr: %v", addr)
+					}
+					received <- string(data)
+					return nil
+				},
+			)
+			if err := httpTransport.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			defer httpTransport.Disconnect(0)
+
+			var got []string
+			timeout := time.After(200 * time.Millisecond)
+		loop:
+			for {
+				select {
+				case data := <-received:
+					got = append(got, data)
+				case <-timeout:
+					break loop
+				}
+			}
+
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v", cmp.Diff(got, test.want))
+			}
+		})
+	}
+}
+
+func TestTxFailover(t *testing.T) {
+	var requests atomic.Int32
+	handler := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(status)
+		}
+	}
+	failing := httptest.NewServer(handler(http.StatusBadGateway))
+	defer failing.Close()
+	healthy := httptest.NewServer(handler(http.StatusOK))
+	defer healthy.Close()
+
+	config.DefaultConfig.HTTPFailoverCooldown = time.Minute
+	defer func() { config.DefaultConfig.HTTPFailoverCooldown = 0 }()
+
+	httpTransport, err := transport.NewHTTPTransport(
+		"test",
+		[]string{
+			strings.TrimPrefix(failing.URL, "http://"),
+			strings.TrimPrefix(healthy.URL, "http://"),
+		},
+		nil,
+		"testUA",
+		time.Second,
+	)
+	if err != nil {
+		t.Fatalf("cannot create new transport: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		code, _, _, err := httpTransport.Tx("test", nil, []byte("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != http.StatusOK {
+			t.Errorf("%v != %v", code, http.StatusOK)
+		}
+	}
+
+	// The failing server is only tried once; the second request goes directly
+	// to the healthy server.
+	if got := requests.Load(); got != 3 {
+		t.Errorf("%v != %v", got, 3)
+	}
+	want := strings.TrimPrefix(healthy.URL, "http://")
+	if got := httpTransport.ActiveServer(); got != want {
+		t.Errorf("%v != %v", got, want)
+	}
+}
@@ -23,6 +23,7 @@ type MQTT struct {
 	opts           *mqtt.ClientOptions
 	events         chan TransporterEvent
 	eventHandler   EventHandlerFunc
+	fragments      *reassembler
 }
 
 // NewMQTTTransport creates a transport suitable for transmitting data over a
@@ -31,6 +32,7 @@ func NewMQTTTransport(clientID string, brokers []string, tlsConfig *tls.Config)
 	var t MQTT
 
 	t.events = make(chan TransporterEvent)
+	t.fragments = newReassembler(config.DefaultConfig.MQTTFragmentTimeout)
 
 	if _, ok := os.LookupEnv("MQTT_DEBUG"); ok {
 		mqtt.DEBUG = log.New(os.Stderr, "[MQTT_DEBUG] ", log.LstdFlags, log.LevelDebug)
@@ -68,7 +70,15 @@ func NewMQTTTransport(clientID string, brokers []string, tlsConfig *tls.Config)
 				if t.receiveHandler == nil {
 					return
 				}
-				if err := t.receiveHandler("data", nil, m.Payload()); err != nil {
+				data, complete, err := t.fragments.Add(m.Payload())
+				if err != nil {
+					log.Errorf("cannot reassemble data message: %v", err)
+					return
+				}
+				if !complete {
+					return
+				}
+				if err := t.receiveHandler("data", nil, data); err != nil {
 					log.Errorf("cannot receive data message: %v", err)
 				}
 			}()
@@ -81,7 +91,15 @@ func NewMQTTTransport(clientID string, brokers []string, tlsConfig *tls.Config)
 				if t.receiveHandler == nil {
 					return
 				}
-				if err := t.receiveHandler("control", nil, m.Payload()); err != nil {
+				data, complete, err := t.fragments.Add(m.Payload())
+				if err != nil {
+					log.Errorf("cannot reassemble control message: %v", err)
+					return
+				}
+				if !complete {
+					return
+				}
+				if err := t.receiveHandler("control", nil, data); err != nil {
 					log.Errorf("cannot receive control message: %v", err)
 				}
 			}()
@@ -121,6 +139,7 @@ func NewMQTTTransport(clientID string, brokers []string, tlsConfig *tls.Config)
 			State          yggdrasil.ConnectionState    "json:\"state\""
 			Tags           map[string]string            "json:\"tags,omitempty\""
 			ClientVersion  string                       "json:\"client_version,omitempty\""
+			ActiveServer   string                       "json:\"active_server,omitempty\""
 		}{
 			State:         yggdrasil.ConnectionStateOffline,
 			ClientVersion: constants.Version,
@@ -189,7 +208,8 @@ func (t *MQTT) Disconnect(quiesce uint) {
 }
 
 // Tx publishes data to an MQTT topic created by combining client information
-// with addr.
+// with addr. If data is larger than the configured fragment size, it is
+// published as a manifest followed by a number of chunks.
 func (t *MQTT) Tx(
 	addr string,
 	metadata map[string]string,
@@ -198,16 +218,26 @@ func (t *MQTT) Tx(
 	opts := t.client.OptionsReader()
 	topic := fmt.Sprintf("%v/%v/%v/out", config.DefaultConfig.PathPrefix, opts.ClientID(), addr)
 
-	token := t.client.Publish(topic, 1, false, data)
-	if !token.WaitTimeout(config.DefaultConfig.MQTTPublishTimeout) {
-		return TxResponseErr, nil, nil, fmt.Errorf(
-			"cannot publish message: connection timeout: %v elapsed",
-			config.DefaultConfig.MQTTPublishTimeout,
-		)
+	payloads, err := fragmentPayload(data, config.DefaultConfig.MQTTFragmentSize)
+	if err != nil {
+		return TxResponseErr, nil, nil, fmt.Errorf("cannot fragment message: %w", err)
 	}
-	if token.Error() != nil {
-		log.Errorf("failed to publish message: %v", token.Error())
-		return TxResponseErr, nil, nil, token.Error()
+	if len(payloads) > 1 {
+		log.Debugf("publishing message as %v fragments", len(payloads)-1)
+	}
+
+	for _, payload := range payloads {
+		token := t.client.Publish(topic, 1, false, payload)
+		if !token.WaitTimeout(config.DefaultConfig.MQTTPublishTimeout) {
+			return TxResponseErr, nil, nil, fmt.Errorf(
+				"cannot publish message: connection timeout: %v elapsed",
+				config.DefaultConfig.MQTTPublishTimeout,
+			)
+		}
+		if token.Error() != nil {
+			log.Errorf("failed to publish message: %v", token.Error())
+			return TxResponseErr, nil, nil, token.Error()
+		}
 	}
 	log.Debugf("published message to topic %v", topic)
 
@@ -0,0 +1,427 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/google/uuid"
+	"github.com/redhatinsights/yggdrasil"
+	"github.com/redhatinsights/yggdrasil/internal/config"
+	"github.com/redhatinsights/yggdrasil/internal/constants"
+)
+
+// Metadata keys that are mapped onto MQTT v5 message properties, rather than
+// (or in addition to) user properties.
+const (
+	// MetadataKeyContentType is the metadata key holding the content type of
+	// a message.
+	MetadataKeyContentType = "content-type"
+
+	// MetadataKeyCorrelationData is the metadata key holding the correlation
+	// data of a received message.
+	MetadataKeyCorrelationData = "correlation-data"
+
+	// MetadataKeyTTL is the metadata key holding the time-to-live of a
+	// message, either as a number of seconds or a duration string ("1h30m").
+	MetadataKeyTTL = "ttl"
+
+	// MetadataKeyMessageExpiry is the metadata key holding the remaining
+	// lifetime, in seconds, of a received message.
+	MetadataKeyMessageExpiry = "message-expiry"
+)
+
+// MQTT5 is a Transporter that sends and receives data and control messages
+// over MQTT v5 by subscribing and publishing to topics on an MQTT broker.
+// Unlike MQTT, message metadata is carried as MQTT v5 user properties.
+type MQTT5 struct {
+	clientID       string
+	cm             *autopaho.ConnectionManager
+	cfg            autopaho.ClientConfig
+	receiveHandler RxHandlerFunc
+	events         chan TransporterEvent
+	eventsOnce     sync.Once
+	eventHandler   EventHandlerFunc
+}
+
+// NewMQTT5Transport creates a transport suitable for transmitting data over a
+// set of MQTT topics using protocol version 5.
+func NewMQTT5Transport(clientID string, brokers []string, tlsConfig *tls.Config) (*MQTT5, error) {
+	t := MQTT5{
+		clientID: clientID,
+		events:   make(chan TransporterEvent),
+	}
+
+	var serverURLs []*url.URL
+	for _, broker := range brokers {
+		u, err := url.Parse(broker)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse broker URL '%v': %w", broker, err)
+		}
+		serverURLs = append(serverURLs, u)
+	}
+
+	data, err := json.Marshal(&yggdrasil.ConnectionStatus{
+		Type:      yggdrasil.MessageTypeConnectionStatus,
+		MessageID: uuid.New().String(),
+		Version:   1,
+		Sent:      time.Now(),
+		Content: struct {
+			CanonicalFacts map[string]interface{}       "json:\"canonical_facts\""
+			Dispatchers    map[string]map[string]string "json:\"dispatchers\""
+			State          yggdrasil.ConnectionState    "json:\"state\""
+			Tags           map[string]string            "json:\"tags,omitempty\""
+			ClientVersion  string                       "json:\"client_version,omitempty\""
+			ActiveServer   string                       "json:\"active_server,omitempty\""
+		}{
+			State:         yggdrasil.ConnectionStateOffline,
+			ClientVersion: constants.Version,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal message to JSON: %w", err)
+	}
+
+	t.cfg = autopaho.ClientConfig{
+		ServerUrls:                    serverURLs,
+		TlsCfg:                        tlsConfig.Clone(),
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: true,
+		ConnectRetryDelay:             config.DefaultConfig.MQTTConnectRetryInterval,
+		ConnectTimeout:                config.DefaultConfig.MQTTConnectTimeout,
+		WillMessage: &paho.WillMessage{
+			Topic:   t.topic("control", "out"),
+			Payload: data,
+			QoS:     1,
+		},
+		WillProperties: &paho.WillProperties{
+			ContentType: "application/json",
+		},
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+			t.events <- TransporterEventConnected
+
+			for _, u := range serverURLs {
+				log.Tracef("connected to broker: %v", u)
+			}
+
+			// Publish a throwaway message in case the topic does not exist;
+			// this is a workaround for the Akamai MQTT broker implementation.
+			go func() {
+				_, _ = cm.Publish(context.Background(), &paho.Publish{
+					Topic: t.topic("data", "out"),
+				})
+			}()
+
+			subscriptions := []paho.SubscribeOptions{
+				{Topic: t.topic("data", "in"), QoS: 1},
+				{Topic: t.topic("control", "in"), QoS: 1},
+			}
+			if _, err := cm.Subscribe(context.Background(), &paho.Subscribe{
+				Subscriptions: subscriptions,
+			}); err != nil {
+				log.Errorf("cannot subscribe to topics: %v", err)
+				return
+			}
+			for _, s := range subscriptions {
+				log.Tracef("subscribed to topic: %v", s.Topic)
+			}
+		},
+		OnConnectError: func(err error) {
+			log.Errorf("cannot connect to broker: %v", err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: clientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(pr paho.PublishReceived) (bool, error) {
+					t.receive(pr.Packet)
+					return true, nil
+				},
+			},
+			OnClientError: func(err error) {
+				log.Errorf("connection lost unexpectedly: %v", err)
+				t.connectionLost()
+			},
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				log.Errorf("server disconnected: reason code %v", d.ReasonCode)
+				t.connectionLost()
+			},
+		},
+	}
+
+	if _, ok := os.LookupEnv("MQTT_DEBUG"); ok {
+		logger := log.New(os.Stderr, "[MQTT_DEBUG] ", log.LstdFlags, log.LevelDebug)
+		t.cfg.Debug = logger
+		t.cfg.PahoDebug = logger
+	}
+
+	return &t, nil
+}
+
+// Connect connects an MQTT client to the configured broker and waits for the
+// connection to open.
+func (t *MQTT5) Connect() error {
+	// Events are delivered by a single goroutine, however many times the
+	// transport reconnects.
+	t.eventsOnce.Do(func() {
+		go func() {
+			for event := range t.events {
+				if t.eventHandler == nil {
+					continue
+				}
+				t.eventHandler(event)
+			}
+		}()
+	})
+
+	log.Infof("connecting to broker: %v", config.DefaultConfig.Server)
+	cm, err := autopaho.NewConnection(context.Background(), t.cfg)
+	if err != nil {
+		return fmt.Errorf("cannot connect to broker: %w", err)
+	}
+	t.cm = cm
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		config.DefaultConfig.MQTTConnectTimeout,
+	)
+	defer cancel()
+	if err := cm.AwaitConnection(ctx); err != nil {
+		_ = cm.Disconnect(context.Background())
+		return fmt.Errorf(
+			"cannot connect to broker: connection timeout: %v elapsed",
+			config.DefaultConfig.MQTTConnectTimeout,
+		)
+	}
+	return nil
+}
+
+// ReloadTLSConfig disconnects the current connection, then reconnects using
+// the given TLS config.
+func (t *MQTT5) ReloadTLSConfig(tlsConfig *tls.Config) error {
+	if t.cm != nil {
+		_ = t.cm.Disconnect(context.Background())
+	}
+	t.cfg.TlsCfg = tlsConfig.Clone()
+	return t.Connect()
+}
+
+// Disconnect closes the connection to the MQTT broker, waiting for the
+// specified number of milliseconds for work to complete.
+func (t *MQTT5) Disconnect(quiesce uint) {
+	if t.cm == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		time.Millisecond*time.Duration(quiesce),
+	)
+	defer cancel()
+	if err := t.cm.Disconnect(ctx); err != nil {
+		log.Errorf("cannot disconnect from broker: %v", err)
+	}
+}
+
+// Tx publishes data to an MQTT topic created by combining client information
+// with addr. Metadata is published as MQTT v5 user properties.
+func (t *MQTT5) Tx(
+	addr string,
+	metadata map[string]string,
+	data []byte,
+) (responseCode int, responseMetadata map[string]string, responseData []byte, err error) {
+	if t.cm == nil {
+		return TxResponseErr, nil, nil, fmt.Errorf("cannot publish message: not connected")
+	}
+
+	properties, err := publishProperties(metadata, data)
+	if err != nil {
+		return TxResponseErr, nil, nil, fmt.Errorf("cannot create message properties: %w", err)
+	}
+
+	topic := t.topic(addr, "out")
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		config.DefaultConfig.MQTTPublishTimeout,
+	)
+	defer cancel()
+	_, err = t.cm.Publish(ctx, &paho.Publish{
+		Topic:      topic,
+		QoS:        1,
+		Payload:    data,
+		Properties: properties,
+	})
+	if err != nil {
+		log.Errorf("failed to publish message: %v", err)
+		return TxResponseErr, nil, nil, err
+	}
+	log.Debugf("published message to topic %v", topic)
+
+	return TxResponseOK, map[string]string{}, []byte{}, nil
+}
+
+// SetRxHandler stores a reference to f, which is then called whenever data is
+// received over the inbound data topic.
+func (t *MQTT5) SetRxHandler(f RxHandlerFunc) error {
+	t.receiveHandler = f
+	return nil
+}
+
+func (t *MQTT5) SetEventHandler(f EventHandlerFunc) error {
+	t.eventHandler = f
+	return nil
+}
+
+// topic creates a topic name for the given channel ("data" or "control") and
+// direction ("in" or "out").
+func (t *MQTT5) topic(channel string, direction string) string {
+	return fmt.Sprintf(
+		"%v/%v/%v/%v",
+		config.DefaultConfig.PathPrefix,
+		t.clientID,
+		channel,
+		direction,
+	)
+}
+
+// receive calls the receive handler with the payload of p, passing the message
+// properties as metadata.
+func (t *MQTT5) receive(p *paho.Publish) {
+	var addr string
+	switch p.Topic {
+	case t.topic("data", "in"):
+		addr = "data"
+	case t.topic("control", "in"):
+		addr = "control"
+	default:
+		log.Errorf("unhandled message: %v", string(p.Payload))
+		return
+	}
+
+	go func() {
+		if t.receiveHandler == nil {
+			return
+		}
+		if err := t.receiveHandler(addr, receiveMetadata(p.Properties), p.Payload); err != nil {
+			log.Errorf("cannot receive %v message: %v", addr, err)
+		}
+	}()
+}
+
+// connectionLost emits a disconnected event and, unless automatic
+// reconnection is enabled, stops the connection manager from reconnecting.
+func (t *MQTT5) connectionLost() {
+	t.events <- TransporterEventDisconnected
+
+	if !config.DefaultConfig.MQTTAutoReconnect && t.cm != nil {
+		go func() {
+			_ = t.cm.Disconnect(context.Background())
+		}()
+	}
+}
+
+// publishProperties creates MQTT v5 publish properties from metadata and the
+// message envelope in data. Each metadata key/value pair is added as a user
+// property. The message expiry is set from the "ttl" metadata value and the
+// correlation data is set to the "response_to" field of the envelope, if any.
+// The content type is set from the "content-type" metadata value, defaulting to
+// "application/json".
+func publishProperties(metadata map[string]string, data []byte) (*paho.PublishProperties, error) {
+	properties := paho.PublishProperties{
+		ContentType: "application/json",
+	}
+	if contentType := metadata[MetadataKeyContentType]; contentType != "" {
+		properties.ContentType = contentType
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		if k == MetadataKeyContentType {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		properties.User.Add(k, metadata[k])
+	}
+
+	if value, has := metadata[MetadataKeyTTL]; has {
+		ttl, err := parseTTL(value)
+		if err != nil {
+			return nil, err
+		}
+		if ttl.Seconds() > math.MaxUint32 {
+			return nil, fmt.Errorf(
+				"cannot use TTL '%v': longer than %v seconds",
+				value,
+				uint32(math.MaxUint32),
+			)
+		}
+		expiry := uint32(ttl.Seconds())
+		properties.MessageExpiry = &expiry
+	}
+
+	var envelope struct {
+		ResponseTo string `json:"response_to"`
+	}
+	if err := json.Unmarshal(data, &envelope); err == nil && envelope.ResponseTo != "" {
+		properties.CorrelationData = []byte(envelope.ResponseTo)
+	}
+
+	return &properties, nil
+}
+
+// receiveMetadata creates a metadata map from the properties of a received
+// MQTT v5 message. User properties are included as-is, along with the
+// content-type, correlation-data and message-expiry properties, if set.
+func receiveMetadata(properties *paho.PublishProperties) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	if properties == nil {
+		return metadata
+	}
+	for _, p := range properties.User {
+		metadata[p.Key] = p.Value
+	}
+	if properties.ContentType != "" {
+		metadata[MetadataKeyContentType] = properties.ContentType
+	}
+	if len(properties.CorrelationData) > 0 {
+		metadata[MetadataKeyCorrelationData] = string(properties.CorrelationData)
+	}
+	if properties.MessageExpiry != nil {
+		metadata[MetadataKeyMessageExpiry] = strconv.FormatUint(
+			uint64(*properties.MessageExpiry),
+			10,
+		)
+	}
+	return metadata
+}
+
+// parseTTL parses value as either a whole number of seconds or a duration
+// string.
+func parseTTL(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse TTL '%v': %w", value, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("cannot parse TTL '%v': negative duration", value)
+	}
+	return d, nil
+}
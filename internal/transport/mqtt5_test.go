@@ -0,0 +1,222 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func uint32Ptr(v uint32) *uint32 {
+	return &v
+}
+
+func TestPublishProperties(t *testing.T) {
+	tests := []struct {
+		description string
+		input       struct {
+			metadata map[string]string
+			data     []byte
+		}
+		want      *paho.PublishProperties
+		wantError error
+	}{
+		{
+			description: "empty",
+			want: &paho.PublishProperties{
+				ContentType: "application/json",
+			},
+		},
+		{
+			description: "user properties",
+			input: struct {
+				metadata map[string]string
+				data     []byte
+			}{
+				metadata: map[string]string{"b": "2", "a": "1"},
+				data:     []byte(`{"message_id":"1234"}`),
+			},
+			want: &paho.PublishProperties{
+				ContentType: "application/json",
+				User: paho.UserProperties{
+					{Key: "a", Value: "1"},
+					{Key: "b", Value: "2"},
+				},
+			},
+		},
+		{
+			description: "correlation data",
+			input: struct {
+				metadata map[string]string
+				data     []byte
+			}{
+				data: []byte(`{"message_id":"1234","response_to":"5678"}`),
+			},
+			want: &paho.PublishProperties{
+				ContentType:     "application/json",
+				CorrelationData: []byte("5678"),
+			},
+		},
+		{
+			description: "ttl",
+			input: struct {
+				metadata map[string]string
+				data     []byte
+			}{
+				metadata: map[string]string{"ttl": "1m"},
+			},
+			want: &paho.PublishProperties{
+				ContentType:   "application/json",
+				MessageExpiry: uint32Ptr(60),
+				User: paho.UserProperties{
+					{Key: "ttl", Value: "1m"},
+				},
+			},
+		},
+		{
+			description: "content type",
+			input: struct {
+				metadata map[string]string
+				data     []byte
+			}{
+				metadata: map[string]string{"content-type": "text/plain", "a": "1"},
+			},
+			want: &paho.PublishProperties{
+				ContentType: "text/plain",
+				User: paho.UserProperties{
+					{Key: "a", Value: "1"},
+				},
+			},
+		},
+		{
+			description: "ttl overflow",
+			input: struct {
+				metadata map[string]string
+				data     []byte
+			}{
+				metadata: map[string]string{"ttl": "2000000h"},
+			},
+			wantError: cmpopts.AnyError,
+		},
+		{
+			description: "invalid ttl",
+			input: struct {
+				metadata map[string]string
+				data     []byte
+			}{
+				metadata: map[string]string{"ttl": "soon"},
+			},
+			wantError: cmpopts.AnyError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := publishProperties(test.input.metadata, test.input.data)
+
+			if test.wantError != nil {
+				if !cmp.Equal(err, test.wantError, cmpopts.EquateErrors()) {
+					t.Errorf("%#v != %#v", err, test.wantError)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !cmp.Equal(got, test.want) {
+					t.Errorf("%v", cmp.Diff(got, test.want))
+				}
+			}
+		})
+	}
+}
+
+func TestReceiveMetadata(t *testing.T) {
+	tests := []struct {
+		description string
+		input       *paho.PublishProperties
+		want        map[string]interface{}
+	}{
+		{
+			description: "nil properties",
+			want:        map[string]interface{}{},
+		},
+		{
+			description: "all properties",
+			input: &paho.PublishProperties{
+				ContentType:     "application/json",
+				CorrelationData: []byte("5678"),
+				MessageExpiry:   uint32Ptr(30),
+				User: paho.UserProperties{
+					{Key: "a", Value: "1"},
+				},
+			},
+			want: map[string]interface{}{
+				"a":                "1",
+				"content-type":     "application/json",
+				"correlation-data": "5678",
+				"message-expiry":   "30",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got := receiveMetadata(test.input)
+
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v", cmp.Diff(got, test.want))
+			}
+		})
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		want        time.Duration
+		wantError   error
+	}{
+		{
+			description: "seconds",
+			input:       "90",
+			want:        90 * time.Second,
+		},
+		{
+			description: "duration",
+			input:       "1h30m",
+			want:        90 * time.Minute,
+		},
+		{
+			description: "negative",
+			input:       "-1h",
+			wantError:   cmpopts.AnyError,
+		},
+		{
+			description: "invalid",
+			input:       "tomorrow",
+			wantError:   cmpopts.AnyError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := parseTTL(test.input)
+
+			if test.wantError != nil {
+				if !cmp.Equal(err, test.wantError, cmpopts.EquateErrors()) {
+					t.Errorf("%#v != %#v", err, test.wantError)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !cmp.Equal(got, test.want) {
+					t.Errorf("%v != %v", got, test.want)
+				}
+			}
+		})
+	}
+}
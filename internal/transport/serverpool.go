@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+)
+
+// serverHealth records the health of a single server in a serverPool.
+type serverHealth struct {
+	addr      string
+	failures  int
+	downUntil time.Time
+}
+
+// serverPool tracks the health of an ordered list of servers, selecting the
+// first healthy server as the active server. A server that fails is skipped
+// until its cooldown expires, after which it is preferred again according to
+// its position in the list.
+type serverPool struct {
+	mu       sync.Mutex
+	servers  []*serverHealth
+	active   string
+	cooldown time.Duration
+	now      func() time.Time
+}
+
+// newServerPool creates a serverPool for addrs, in order of preference.
+func newServerPool(addrs []string, cooldown time.Duration) *serverPool {
+	servers := make([]*serverHealth, 0, len(addrs))
+	for _, addr := range addrs {
+		servers = append(servers, &serverHealth{addr: addr})
+	}
+	return &serverPool{
+		servers:  servers,
+		cooldown: cooldown,
+		now:      time.Now,
+	}
+}
+
+// Len returns the number of servers in the pool.
+func (p *serverPool) Len() int {
+	return len(p.servers)
+}
+
+// Active returns the address of the server that requests should be sent to.
+// If every server is cooling down, the server whose cooldown expires first is
+// returned.
+func (p *serverPool) Active() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.servers) == 0 {
+		return ""
+	}
+
+	now := p.now()
+	var selected *serverHealth
+	for _, server := range p.servers {
+		if !now.Before(server.downUntil) {
+			selected = server
+			break
+		}
+		if selected == nil || server.downUntil.Before(selected.downUntil) {
+			selected = server
+		}
+	}
+
+	if selected.addr != p.active {
+		if p.active == "" {
+			log.Infof("using server %v", selected.addr)
+		} else {
+			log.Infof("switching active server from %v to %v", p.active, selected.addr)
+		}
+		p.active = selected.addr
+	}
+
+	return selected.addr
+}
+
+// Failed records a failed request to addr, taking the server out of rotation
+// for the duration of the cooldown.
+func (p *serverPool) Failed(addr string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, server := range p.servers {
+		if server.addr != addr {
+			continue
+		}
+		server.failures++
+		server.downUntil = p.now().Add(p.cooldown)
+		log.Warnf(
+			"server %v failed (%v consecutive failures): %v",
+			addr,
+			server.failures,
+			err,
+		)
+		return
+	}
+}
+
+// Succeeded records a successful request to addr.
+func (p *serverPool) Succeeded(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, server := range p.servers {
+		if server.addr == addr {
+			server.failures = 0
+			server.downUntil = time.Time{}
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package transport
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestServerPool(t *testing.T) {
+	type step struct {
+		advance   time.Duration
+		failed    string
+		succeeded string
+		want      string
+	}
+	tests := []struct {
+		description string
+		servers     []string
+		steps       []step
+	}{
+		{
+			description: "prefer first server",
+			servers:     []string{"a", "b"},
+			steps: []step{
+				{want: "a"},
+			},
+		},
+		{
+			description: "fail over",
+			servers:     []string{"a", "b", "c"},
+			steps: []step{
+				{failed: "a", want: "b"},
+				{failed: "b", want: "c"},
+			},
+		},
+		{
+			description: "fail back after cooldown",
+			servers:     []string{"a", "b"},
+			steps: []step{
+				{failed: "a", want: "b"},
+				{advance: 30 * time.Second, want: "b"},
+				{advance: 31 * time.Second, want: "a"},
+			},
+		},
+		{
+			description: "all servers failed",
+			servers:     []string{"a", "b"},
+			steps: []step{
+				{failed: "a", want: "b"},
+				{advance: time.Second, failed: "b", want: "a"},
+			},
+		},
+		{
+			description: "success restores server",
+			servers:     []string{"a", "b"},
+			steps: []step{
+				{failed: "a", want: "b"},
+				{succeeded: "a", want: "a"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			now := time.Now()
+			pool := newServerPool(test.servers, time.Minute)
+			pool.now = func() time.Time { return now }
+
+			for i, step := range test.steps {
+				now = now.Add(step.advance)
+				if step.failed != "" {
+					pool.Failed(step.failed, fmt.Errorf("failed"))
+				}
+				if step.succeeded != "" {
+					pool.Succeeded(step.succeeded)
+				}
+				if got := pool.Active(); got != step.want {
+					t.Errorf("step %v: %v != %v", i, got, step.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseEvent is a single event received in a text/event-stream response. ID and
+// Retry hold the most recent values sent by the server, which may have been
+// set by an earlier event.
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  []byte
+	Retry time.Duration
+}
+
+// readEvents parses the text/event-stream format from r, calling f for each
+// complete event. It returns when r is exhausted, or f returns an error.
+// Parsing follows the HTML Living Standard "server-sent events" section.
+func readEvents(r io.Reader, f func(e sseEvent) error) error {
+	reader := bufio.NewReader(r)
+
+	var (
+		id      string
+		retry   time.Duration
+		event   sseEvent
+		data    bytes.Buffer
+		hasData bool
+	)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if err != nil && line == "" {
+			return nil
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		// An empty line dispatches the event.
+		if line == "" {
+			if hasData {
+				event.ID = id
+				event.Retry = retry
+				event.Data = bytes.TrimSuffix(data.Bytes(), []byte("\n"))
+				if err := f(event); err != nil {
+					return err
+				}
+			}
+			event = sseEvent{}
+			data = bytes.Buffer{}
+			hasData = false
+			continue
+		}
+
+		// Lines beginning with a colon are comments.
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "data":
+			data.WriteString(value)
+			data.WriteString("\n")
+			hasData = true
+		case "event":
+			event.Event = value
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				id = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 64); err == nil {
+				retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadEvents(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		want        []sseEvent
+	}{
+		{
+			description: "empty",
+			input:       "",
+			want:        nil,
+		},
+		{
+			description: "single event",
+			input:       "data: {\"message_id\":\"1234\"}\n\n",
+			want: []sseEvent{
+				{Data: []byte(`{"message_id":"1234"}`)},
+			},
+		},
+		{
+			description: "multi-line data",
+			input:       "data: a\ndata: b\n\n",
+			want: []sseEvent{
+				{Data: []byte("a\nb")},
+			},
+		},
+		{
+			description: "fields",
+			input:       "retry: 1500\n\nid: 1\nevent: control\ndata: a\n\ndata: b\n\n",
+			want: []sseEvent{
+				{ID: "1", Event: "control", Data: []byte("a"), Retry: 1500 * time.Millisecond},
+				{ID: "1", Data: []byte("b"), Retry: 1500 * time.Millisecond},
+			},
+		},
+		{
+			description: "comments and CRLF",
+			input:       ": keepalive\r\n\r\ndata:a\r\n\r\n",
+			want: []sseEvent{
+				{Data: []byte("a")},
+			},
+		},
+		{
+			description: "incomplete event",
+			input:       "data: a\n\ndata: b",
+			want: []sseEvent{
+				{Data: []byte("a")},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got []sseEvent
+			err := readEvents(strings.NewReader(test.input), func(e sseEvent) error {
+				got = append(got, e)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v", cmp.Diff(got, test.want))
+			}
+		})
+	}
+}
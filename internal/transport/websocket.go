@@ -0,0 +1,415 @@
+package transport
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/gorilla/websocket"
+	"github.com/redhatinsights/yggdrasil/internal/config"
+)
+
+// webSocketRxBufferSize is the number of received frames held while the data
+// handler is busy. The connection is not read while the buffer is full.
+const webSocketRxBufferSize = 100
+
+// webSocketFrame is the JSON envelope used to multiplex the data and control
+// channels over a single WebSocket connection.
+type webSocketFrame struct {
+	Channel  string            `json:"channel"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Data     json.RawMessage   `json:"data"`
+}
+
+// WebSocket is a Transporter that sends and receives data and control messages
+// over a single, persistent WebSocket connection. Each message is sent as a
+// JSON frame naming the channel ("data" or "control") it belongs to.
+type WebSocket struct {
+	clientID          string
+	server            string
+	userAgent         string
+	dialer            *websocket.Dialer
+	conn              *websocket.Conn
+	connMu            sync.Mutex
+	writeMu           sync.Mutex
+	done              chan struct{}
+	frames            chan webSocketFrame
+	framesOnce        sync.Once
+	dataHandler       RxHandlerFunc
+	eventHandler      EventHandlerFunc
+	reconnectDelay    time.Duration
+	maxReconnectDelay time.Duration
+	pingInterval      time.Duration
+}
+
+// NewWebSocketTransport creates a WebSocket transport that connects to server.
+// If tlsConfig is not nil, the connection is made using the wss scheme.
+func NewWebSocketTransport(
+	clientID string,
+	server string,
+	tlsConfig *tls.Config,
+	userAgent string,
+) (*WebSocket, error) {
+	if server == "" {
+		return nil, fmt.Errorf("cannot create WebSocket transport: missing server")
+	}
+
+	return &WebSocket{
+		clientID:  clientID,
+		server:    server,
+		userAgent: userAgent,
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: config.DefaultConfig.HTTPTimeout,
+			TLSClientConfig:  tlsConfig.Clone(),
+		},
+		frames:            make(chan webSocketFrame, webSocketRxBufferSize),
+		reconnectDelay:    time.Second,
+		maxReconnectDelay: 2 * time.Minute,
+		pingInterval:      30 * time.Second,
+	}, nil
+}
+
+// Connect opens the WebSocket connection and begins receiving messages. If the
+// connection cannot be opened, or is lost, it is reopened in the background,
+// waiting an increasing amount of time between each attempt.
+func (t *WebSocket) Connect() error {
+	// Frames are passed to the data handler by a single goroutine, so that a
+	// slow handler does not hold up reading the connection.
+	t.framesOnce.Do(func() {
+		go t.handleFrames()
+	})
+
+	conn, err := t.dial()
+	if err != nil {
+		log.Errorf("cannot connect, retrying in the background: %v", err)
+	}
+
+	done := make(chan struct{})
+
+	t.connMu.Lock()
+	t.conn = conn
+	t.done = done
+	t.connMu.Unlock()
+
+	go t.run(conn, done)
+
+	return nil
+}
+
+// Disconnect closes the WebSocket connection, waiting for the specified number
+// of milliseconds for work to complete.
+func (t *WebSocket) Disconnect(quiesce uint) {
+	time.Sleep(time.Millisecond * time.Duration(quiesce))
+
+	t.connMu.Lock()
+	conn := t.conn
+	if t.done != nil {
+		close(t.done)
+		t.done = nil
+	}
+	t.conn = nil
+	t.connMu.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	t.writeMu.Lock()
+	err := conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
+	t.writeMu.Unlock()
+	if err != nil {
+		log.Debugf("cannot send close message: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		log.Debugf("cannot close connection: %v", err)
+	}
+
+	t.emit(TransporterEventDisconnected)
+}
+
+// Tx sends data as a frame on the channel named by addr.
+func (t *WebSocket) Tx(
+	addr string,
+	metadata map[string]string,
+	data []byte,
+) (responseCode int, responseMetadata map[string]string, responseData []byte, err error) {
+	t.connMu.Lock()
+	conn := t.conn
+	t.connMu.Unlock()
+
+	if conn == nil {
+		return TxResponseErr, nil, nil, fmt.Errorf("cannot perform Tx: transport is disconnected")
+	}
+
+	frame, err := json.Marshal(webSocketFrame{
+		Channel:  addr,
+		Metadata: metadata,
+		Data:     data,
+	})
+	if err != nil {
+		return TxResponseErr, nil, nil, fmt.Errorf("cannot marshal frame: %w", err)
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if config.DefaultConfig.HTTPTimeout > 0 {
+		deadline := time.Now().Add(config.DefaultConfig.HTTPTimeout)
+		if err := conn.SetWriteDeadline(deadline); err != nil {
+			return TxResponseErr, nil, nil, fmt.Errorf("cannot set write deadline: %w", err)
+		}
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+		return TxResponseErr, nil, nil, fmt.Errorf("cannot write message: %w", err)
+	}
+
+	return TxResponseOK, nil, nil, nil
+}
+
+// ReloadTLSConfig replaces the TLS configuration used by the transport and
+// reopens the connection using it.
+func (t *WebSocket) ReloadTLSConfig(tlsConfig *tls.Config) error {
+	t.connMu.Lock()
+	t.dialer.TLSClientConfig = tlsConfig.Clone()
+	old := t.conn
+	t.connMu.Unlock()
+
+	// Nothing more to do if the transport is not connected; the new
+	// configuration is used the next time it connects.
+	if old == nil {
+		return nil
+	}
+
+	conn, err := t.dial()
+	if err != nil {
+		return err
+	}
+
+	t.connMu.Lock()
+	if t.conn != old {
+		// The transport was disconnected or reconnected in the meantime.
+		t.connMu.Unlock()
+		return conn.Close()
+	}
+	t.conn = conn
+	t.connMu.Unlock()
+
+	// Closing the old connection makes the receive loop pick up the new one.
+	return old.Close()
+}
+
+func (t *WebSocket) SetRxHandler(f RxHandlerFunc) error {
+	t.dataHandler = f
+	return nil
+}
+
+func (t *WebSocket) SetEventHandler(f EventHandlerFunc) error {
+	t.eventHandler = f
+	return nil
+}
+
+// run reads frames from the current connection until done is closed,
+// reconnecting whenever the connection is lost. If conn is nil, the connection
+// is opened first.
+func (t *WebSocket) run(conn *websocket.Conn, done chan struct{}) {
+	if conn == nil {
+		conn = t.reconnect(nil, done)
+		if conn == nil {
+			return
+		}
+	}
+	t.emit(TransporterEventConnected)
+
+	for {
+		t.receive(conn, done)
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		// If the connection was replaced (by ReloadTLSConfig), continue
+		// reading from the new connection.
+		t.connMu.Lock()
+		current := t.conn
+		t.connMu.Unlock()
+		if current != nil && current != conn {
+			conn = current
+			continue
+		}
+
+		t.emit(TransporterEventDisconnected)
+
+		conn = t.reconnect(conn, done)
+		if conn == nil {
+			return
+		}
+
+		t.emit(TransporterEventConnected)
+	}
+}
+
+// receive reads frames from conn, queueing each for the data handler, until the
+// connection fails or is closed.
+func (t *WebSocket) receive(conn *websocket.Conn, done chan struct{}) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go t.keepalive(conn, stop)
+
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * t.pingInterval))
+	})
+	if err := conn.SetReadDeadline(time.Now().Add(2 * t.pingInterval)); err != nil {
+		log.Errorf("cannot set read deadline: %v", err)
+	}
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-done:
+			default:
+				log.Errorf("cannot read message: %v", err)
+			}
+			return
+		}
+
+		var frame webSocketFrame
+		if err := json.Unmarshal(message, &frame); err != nil {
+			log.Errorf("cannot unmarshal frame: %v", err)
+			continue
+		}
+		log.Debugf("received frame on channel %v", frame.Channel)
+
+		select {
+		case t.frames <- frame:
+		case <-done:
+			return
+		}
+	}
+}
+
+// handleFrames passes each received frame to the data handler, in the order
+// the frames were received.
+func (t *WebSocket) handleFrames() {
+	for frame := range t.frames {
+		if t.dataHandler == nil {
+			continue
+		}
+		metadata := make(map[string]interface{})
+		for k, v := range frame.Metadata {
+			metadata[k] = v
+		}
+		if err := t.dataHandler(frame.Channel, metadata, frame.Data); err != nil {
+			log.Errorf("cannot handle frame: %v", err)
+		}
+	}
+}
+
+// keepalive sends a ping on conn at regular intervals until stop is closed.
+func (t *WebSocket) keepalive(conn *websocket.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(t.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(t.pingInterval))
+			if err != nil {
+				log.Debugf("cannot send ping: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// reconnect dials the server until it succeeds or done is closed, doubling
+// the delay between attempts up to the maximum reconnect delay. It returns the
+// new connection, or nil if done was closed.
+func (t *WebSocket) reconnect(old *websocket.Conn, done chan struct{}) *websocket.Conn {
+	delay := t.reconnectDelay
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-time.After(delay):
+		}
+
+		conn, err := t.dial()
+		if err != nil {
+			log.Errorf("cannot reconnect: %v", err)
+			delay *= 2
+			if delay > t.maxReconnectDelay {
+				delay = t.maxReconnectDelay
+			}
+			continue
+		}
+
+		t.connMu.Lock()
+		if t.done != done || t.conn != old {
+			// The transport was disconnected while dialing.
+			t.connMu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		t.conn = conn
+		t.connMu.Unlock()
+
+		return conn
+	}
+}
+
+// dial opens a new WebSocket connection to the server.
+func (t *WebSocket) dial() (*websocket.Conn, error) {
+	t.connMu.Lock()
+	dialer := *t.dialer
+	t.connMu.Unlock()
+
+	header := http.Header{}
+	header.Set("User-Agent", t.userAgent)
+
+	conn, resp, err := dialer.Dial(t.getUrl(dialer.TLSClientConfig != nil), header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("cannot connect to server: %w: %v", err, resp.Status)
+		}
+		return nil, fmt.Errorf("cannot connect to server: %w", err)
+	}
+	log.Infof("connected to %v", t.server)
+
+	return conn, nil
+}
+
+// emit calls the event handler, if one is set.
+func (t *WebSocket) emit(e TransporterEvent) {
+	if t.eventHandler == nil {
+		return
+	}
+	t.eventHandler(e)
+}
+
+func (t *WebSocket) getUrl(isTLS bool) string {
+	scheme := "ws"
+	if isTLS {
+		scheme = "wss"
+	}
+	u := url.URL{
+		Scheme: scheme,
+		Host:   t.server,
+		Path:   path.Join("/", config.DefaultConfig.PathPrefix, "websocket", t.clientID),
+	}
+	return u.String()
+}
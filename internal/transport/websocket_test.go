@@ -0,0 +1,305 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
+)
+
+// webSocketServer is a test server that upgrades each request to a WebSocket
+// connection and hands the connection to the test.
+type webSocketServer struct {
+	*httptest.Server
+	conns chan *websocket.Conn
+
+	// failures is the number of requests that are refused before
+	// connections are upgraded.
+	failures atomic.Int32
+}
+
+func newWebSocketServer(t *testing.T) *webSocketServer {
+	s := &webSocketServer{conns: make(chan *websocket.Conn, 4)}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/yggdrasil/websocket/test" {
+			http.NotFound(w, r)
+			return
+		}
+		if s.failures.Add(-1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("cannot upgrade connection: %v", err)
+			return
+		}
+		s.conns <- conn
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webSocketServer) accept(t *testing.T) *websocket.Conn {
+	select {
+	case conn := <-s.conns:
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for connection")
+	}
+	return nil
+}
+
+func newTestWebSocket(t *testing.T, server string) (*WebSocket, chan TransporterEvent) {
+	transport, err := NewWebSocketTransport("test", server, nil, "testUA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.reconnectDelay = 10 * time.Millisecond
+	transport.maxReconnectDelay = 10 * time.Millisecond
+
+	events := make(chan TransporterEvent, 8)
+	_ = transport.SetEventHandler(func(e TransporterEvent) {
+		events <- e
+	})
+	t.Cleanup(func() { transport.Disconnect(0) })
+	return transport, events
+}
+
+func waitForEvent(t *testing.T, events chan TransporterEvent, want TransporterEvent) {
+	select {
+	case got := <-events:
+		if got != want {
+			t.Fatalf("%v != %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for event %v", want)
+	}
+}
+
+func TestWebSocketTx(t *testing.T) {
+	server := newWebSocketServer(t)
+	transport, events := newTestWebSocket(t, strings.TrimPrefix(server.URL, "http://"))
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn := server.accept(t)
+	waitForEvent(t, events, TransporterEventConnected)
+
+	code, _, _, err := transport.Tx(
+		"control",
+		map[string]string{"k": "v"},
+		[]byte(`{"type":"connection-status"}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != TxResponseOK {
+		t.Errorf("%v != %v", code, TxResponseOK)
+	}
+
+	var got webSocketFrame
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := webSocketFrame{
+		Channel:  "control",
+		Metadata: map[string]string{"k": "v"},
+		Data:     json.RawMessage(`{"type":"connection-status"}`),
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+}
+
+func TestWebSocketRx(t *testing.T) {
+	server := newWebSocketServer(t)
+	transport, events := newTestWebSocket(t, strings.TrimPrefix(server.URL, "http://"))
+
+	type rx struct {
+		addr     string
+		metadata map[string]interface{}
+		data     string
+	}
+	received := make(chan rx, 1)
+	_ = transport.SetRxHandler(
+		func(addr string, metadata map[string]interface{}, data []byte) error {
+			received <- rx{addr: addr, metadata: metadata, data: string(data)}
+			return nil
+		},
+	)
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn := server.accept(t)
+	waitForEvent(t, events, TransporterEventConnected)
+
+	err := conn.WriteJSON(webSocketFrame{
+		Channel:  "data",
+		Metadata: map[string]string{"k": "v"},
+		Data:     json.RawMessage(`{"message_id":"1234"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := rx{
+		addr:     "data",
+		metadata: map[string]interface{}{"k": "v"},
+		data:     `{"message_id":"1234"}`,
+	}
+	select {
+	case got := <-received:
+		if !cmp.Equal(got, want, cmp.AllowUnexported(rx{})) {
+			t.Errorf("%v", cmp.Diff(got, want, cmp.AllowUnexported(rx{})))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+}
+
+func TestWebSocketRxSlowHandler(t *testing.T) {
+	server := newWebSocketServer(t)
+	transport, events := newTestWebSocket(t, strings.TrimPrefix(server.URL, "http://"))
+
+	release := make(chan struct{})
+	received := make(chan string, 2)
+	_ = transport.SetRxHandler(
+		func(addr string, metadata map[string]interface{}, data []byte) error {
+			received <- string(data)
+			<-release
+			return nil
+		},
+	)
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn := server.accept(t)
+	waitForEvent(t, events, TransporterEventConnected)
+
+	for _, data := range []string{`"1"`, `"2"`} {
+		err := conn.WriteJSON(webSocketFrame{Channel: "data", Data: json.RawMessage(data)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+
+	// The connection is still read while the handler is busy, so a ping is
+	// answered.
+	pong := make(chan struct{}, 1)
+	conn.SetPongHandler(func(string) error {
+		pong <- struct{}{}
+		return nil
+	})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-pong:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for pong")
+	}
+
+	close(release)
+	select {
+	case got := <-received:
+		if got != `"2"` {
+			t.Errorf("%v != %v", got, `"2"`)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+}
+
+func TestWebSocketConnectRetry(t *testing.T) {
+	server := newWebSocketServer(t)
+	server.failures.Store(2)
+	transport, events := newTestWebSocket(t, strings.TrimPrefix(server.URL, "http://"))
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	server.accept(t)
+	waitForEvent(t, events, TransporterEventConnected)
+
+	if _, _, _, err := transport.Tx("data", nil, []byte(`{}`)); err != nil {
+		t.Errorf("cannot transmit after connecting: %v", err)
+	}
+}
+
+func TestWebSocketReconnect(t *testing.T) {
+	server := newWebSocketServer(t)
+	transport, events := newTestWebSocket(t, strings.TrimPrefix(server.URL, "http://"))
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn := server.accept(t)
+	waitForEvent(t, events, TransporterEventConnected)
+
+	// Drop the connection from the server side.
+	conn.Close()
+
+	waitForEvent(t, events, TransporterEventDisconnected)
+	server.accept(t)
+	waitForEvent(t, events, TransporterEventConnected)
+
+	if _, _, _, err := transport.Tx("data", nil, []byte(`{}`)); err != nil {
+		t.Errorf("cannot transmit after reconnect: %v", err)
+	}
+}
+
+func TestWebSocketReloadTLSConfig(t *testing.T) {
+	server := newWebSocketServer(t)
+	transport, events := newTestWebSocket(t, strings.TrimPrefix(server.URL, "http://"))
+
+	if err := transport.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	server.accept(t)
+	waitForEvent(t, events, TransporterEventConnected)
+
+	if err := transport.ReloadTLSConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	conn := server.accept(t)
+
+	if _, _, _, err := transport.Tx("data", nil, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	var got webSocketFrame
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing the connection must not be reported as a disconnection.
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e)
+	default:
+	}
+}
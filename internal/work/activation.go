@@ -0,0 +1,274 @@
+package work
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/redhatinsights/yggdrasil"
+	"github.com/redhatinsights/yggdrasil/ipc"
+)
+
+const (
+	// startReplySuccess and startReplyAlreadyRunning are the values returned
+	// by org.freedesktop.DBus.StartServiceByName.
+	startReplySuccess        uint32 = 1
+	startReplyAlreadyRunning uint32 = 2
+
+	// maxDispatchRetryDelay is the longest delay between dispatch attempts.
+	maxDispatchRetryDelay = 5 * time.Minute
+)
+
+// ownerWaiters tracks goroutines waiting for a bus name to gain an owner.
+type ownerWaiters struct {
+	mu      sync.Mutex
+	waiters map[string][]chan struct{}
+}
+
+// add returns a channel that is closed when name gains an owner.
+func (w *ownerWaiters) add(name string) chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.waiters == nil {
+		w.waiters = make(map[string][]chan struct{})
+	}
+	c := make(chan struct{})
+	w.waiters[name] = append(w.waiters[name], c)
+	return c
+}
+
+// remove stops tracking the channel c returned by add.
+func (w *ownerWaiters) remove(name string, c chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	waiters := w.waiters[name]
+	for i := range waiters {
+		if waiters[i] == c {
+			w.waiters[name] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(w.waiters[name]) == 0 {
+		delete(w.waiters, name)
+	}
+}
+
+// notify closes the channels of every goroutine waiting for name.
+func (w *ownerWaiters) notify(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, c := range w.waiters[name] {
+		close(c)
+	}
+	delete(w.waiters, name)
+}
+
+// activateWorker ensures a worker owns the bus name for directive. If the name
+// has no owner, the bus is asked to start the worker with D-Bus activation and
+// activateWorker waits for the worker to acquire its name, up to the configured
+// activation timeout.
+func (d *Dispatcher) activateWorker(directive string) error {
+	name := "com.redhat.Yggdrasil1.Worker1." + directive
+
+	var hasOwner bool
+	call := d.conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, name)
+	if err := call.Store(&hasOwner); err != nil {
+		return fmt.Errorf("cannot call org.freedesktop.DBus.NameHasOwner: %v", err)
+	}
+	if hasOwner {
+		return nil
+	}
+
+	// Start waiting before requesting activation so that the name acquisition
+	// is not missed.
+	owned := d.owners.add(name)
+	defer d.owners.remove(name, owned)
+
+	timeout := d.activationTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Debugf("starting worker %v with D-Bus activation", directive)
+	var reply uint32
+	call = d.conn.BusObject().CallWithContext(
+		ctx,
+		"org.freedesktop.DBus.StartServiceByName",
+		0,
+		name,
+		uint32(0),
+	)
+	if err := call.Store(&reply); err != nil {
+		return fmt.Errorf("cannot start worker %v: %v", directive, err)
+	}
+	switch reply {
+	case startReplySuccess:
+	case startReplyAlreadyRunning:
+		return nil
+	default:
+		return fmt.Errorf("unexpected reply %v starting worker %v", reply, directive)
+	}
+
+	select {
+	case <-owned:
+		log.Debugf("worker %v started", directive)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timeout reached waiting for worker %v to start", directive)
+	}
+}
+
+// workerAvailable returns true if a worker owns the bus name for directive or
+// can be started with D-Bus activation.
+func (d *Dispatcher) workerAvailable(directive string) bool {
+	name := "com.redhat.Yggdrasil1.Worker1." + directive
+
+	var hasOwner bool
+	call := d.conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, name)
+	if err := call.Store(&hasOwner); err != nil {
+		log.Errorf("cannot call org.freedesktop.DBus.NameHasOwner: %v", err)
+		return true
+	}
+	if hasOwner {
+		return true
+	}
+
+	var names []string
+	call = d.conn.BusObject().Call("org.freedesktop.DBus.ListActivatableNames", 0)
+	if err := call.Store(&names); err != nil {
+		log.Errorf("cannot call org.freedesktop.DBus.ListActivatableNames: %v", err)
+		return true
+	}
+	for _, activatable := range names {
+		if activatable == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatchWithRetry makes attempt number attempt to dispatch data. If the
+// attempt fails with an error that a later attempt may not fail with, and
+// fewer than the configured number of attempts have been made, it returns the
+// delay after which the message should be queued again. Otherwise it returns
+// zero and, if the attempt failed, the message is moved to the dead-letter
+// store, if one is enabled, and sent on the failures channel. Messages that
+// expire before they are dispatched are dropped.
+func (d *Dispatcher) dispatchWithRetry(data yggdrasil.Data, attempt int) time.Duration {
+	err := checkExpiry(data, d.maxAge, d.clockSkew, time.Now())
+	if err == nil {
+		err = d.Dispatch(data)
+	}
+	if err == nil {
+		return 0
+	}
+
+	if retryable(err) && attempt < d.maxAttempts {
+		delay := retryDelay(d.retryDelay, attempt)
+		log.Warnf(
+			"cannot dispatch message %v (attempt %v of %v), retrying in %v: %v",
+			data.MessageID,
+			attempt,
+			d.maxAttempts,
+			delay,
+			err,
+		)
+		return delay
+	}
+
+	if err := d.dispatchFailed(data, attempt, err); err != nil {
+		log.Errorf("cannot dispatch data: %v", err)
+	}
+	return 0
+}
+
+// dispatchFailed handles a message that could not be dispatched after attempts
+// attempts, the last of which failed with err.
+func (d *Dispatcher) dispatchFailed(data yggdrasil.Data, attempts int, err error) error {
+	var dispatchErr *DispatchError
+	if errors.As(err, &dispatchErr) && dispatchErr.Code == yggdrasil.ErrorCodeExpired {
+		log.Warnf("dropping expired message: %v", err)
+		d.RecordJournalEntry(&data, ipc.WorkerEventNameExpired, map[string]string{
+			"reason": err.Error(),
+		})
+		d.sendFailure(Failure{Data: data, Err: err})
+		return nil
+	}
+
+	err = fmt.Errorf(
+		"cannot dispatch message %v after %v attempts: %w",
+		data.MessageID,
+		attempts,
+		err,
+	)
+	d.sendFailure(Failure{Data: data, Err: err})
+	if d.DeadLetters == nil {
+		return err
+	}
+	if storeErr := d.DeadLetters.Add(data, attempts, err); storeErr != nil {
+		return fmt.Errorf(
+			"cannot add message %v to dead-letter store: %w",
+			data.MessageID,
+			storeErr,
+		)
+	}
+	log.Warnf("moved message %v to dead-letter store: %v", data.MessageID, err)
+	return nil
+}
+
+// ScheduleFailed handles a scheduled message that could not be queued for
+// dispatch after attempts attempts, the last of which failed with err, in the
+// same way as a message that could not be dispatched.
+func (d *Dispatcher) ScheduleFailed(data yggdrasil.Data, attempts int, err error) {
+	if err := d.dispatchFailed(data, attempts, err); err != nil {
+		log.Errorf("cannot dispatch data: %v", err)
+	}
+}
+
+// sendFailure sends f on the failures channel without waiting for it to be
+// received, so that dispatching is not held up by the receiver. If the channel
+// is full, f is logged and dropped.
+func (d *Dispatcher) sendFailure(f Failure) {
+	select {
+	case d.Failures <- f:
+	default:
+		log.Errorf(
+			"cannot report failure of message %v: failures channel is full: %v",
+			f.Data.MessageID,
+			f.Err,
+		)
+	}
+}
+
+// retryDelay returns the delay before the attempt following attempt number
+// attempt: delay before the second attempt, doubling before each further
+// attempt up to maxDispatchRetryDelay.
+func retryDelay(delay time.Duration, attempt int) time.Duration {
+	for i := 1; i < attempt && delay < maxDispatchRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDispatchRetryDelay {
+		delay = maxDispatchRetryDelay
+	}
+	return delay
+}
+
+// retryable returns true if err means that the worker could not be reached or
+// was too busy to accept the message, so that a later attempt may succeed.
+// Errors returned by the worker itself are not retried, since the worker may
+// already have acted on the message.
+func retryable(err error) bool {
+	var dispatchErr *DispatchError
+	if !errors.As(err, &dispatchErr) {
+		return false
+	}
+	switch dispatchErr.Code {
+	case yggdrasil.ErrorCodeNoSuchWorker,
+		yggdrasil.ErrorCodeQueueFull,
+		yggdrasil.ErrorCodeContentFetchFailed:
+		return true
+	}
+	return false
+}
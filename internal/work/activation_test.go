@@ -0,0 +1,133 @@
+package work
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redhatinsights/yggdrasil"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		description string
+		input       error
+		want        bool
+	}{
+		{
+			description: "no such worker",
+			input:       newDispatchError(yggdrasil.ErrorCodeNoSuchWorker, "not found"),
+			want:        true,
+		},
+		{
+			description: "worker busy",
+			input:       newDispatchError(yggdrasil.ErrorCodeQueueFull, "busy"),
+			want:        true,
+		},
+		{
+			description: "content fetch failed",
+			input:       newDispatchError(yggdrasil.ErrorCodeContentFetchFailed, "timeout"),
+			want:        true,
+		},
+		{
+			description: "worker failed",
+			input:       newDispatchError(yggdrasil.ErrorCodeWorkerFailed, "failed"),
+		},
+		{
+			description: "malformed",
+			input:       newDispatchError(yggdrasil.ErrorCodeMalformed, "invalid URL"),
+		},
+		{
+			description: "expired",
+			input:       newDispatchError(yggdrasil.ErrorCodeExpired, "expired"),
+		},
+		{
+			description: "other error",
+			input:       errors.New("cannot get property"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if got := retryable(test.input); got != test.want {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		description string
+		attempt     int
+		want        time.Duration
+	}{
+		{
+			description: "first attempt",
+			attempt:     1,
+			want:        time.Second,
+		},
+		{
+			description: "third attempt",
+			attempt:     3,
+			want:        4 * time.Second,
+		},
+		{
+			description: "capped",
+			attempt:     100,
+			want:        maxDispatchRetryDelay,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if got := retryDelay(time.Second, test.attempt); got != test.want {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOwnerWaiters(t *testing.T) {
+	var w ownerWaiters
+
+	a := w.add("com.redhat.Yggdrasil1.Worker1.echo")
+	b := w.add("com.redhat.Yggdrasil1.Worker1.echo")
+	c := w.add("com.redhat.Yggdrasil1.Worker1.other")
+	w.remove("com.redhat.Yggdrasil1.Worker1.echo", b)
+
+	w.notify("com.redhat.Yggdrasil1.Worker1.echo")
+
+	select {
+	case <-a:
+	default:
+		t.Errorf("waiter was not notified")
+	}
+	select {
+	case <-b:
+		t.Errorf("removed waiter was notified")
+	default:
+	}
+	select {
+	case <-c:
+		t.Errorf("waiter for other name was notified")
+	default:
+	}
+}
+
+func TestSendFailure(t *testing.T) {
+	d := Dispatcher{Failures: make(chan Failure, 1)}
+
+	// The second failure is dropped instead of blocking.
+	d.sendFailure(Failure{Data: yggdrasil.Data{MessageID: "1"}})
+	d.sendFailure(Failure{Data: yggdrasil.Data{MessageID: "2"}})
+
+	if got := (<-d.Failures).Data.MessageID; got != "1" {
+		t.Errorf("%v != %v", got, "1")
+	}
+	select {
+	case f := <-d.Failures:
+		t.Errorf("unexpected failure: %v", f.Data.MessageID)
+	default:
+	}
+}
@@ -2,11 +2,13 @@ package work
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,8 +17,13 @@ import (
 	"github.com/godbus/dbus/v5/introspect"
 	"github.com/redhatinsights/yggdrasil"
 	"github.com/redhatinsights/yggdrasil/internal/config"
+	"github.com/redhatinsights/yggdrasil/internal/constants"
+	"github.com/redhatinsights/yggdrasil/internal/content"
+	"github.com/redhatinsights/yggdrasil/internal/deadletter"
 	internalhttp "github.com/redhatinsights/yggdrasil/internal/http"
 	"github.com/redhatinsights/yggdrasil/internal/messagejournal"
+	"github.com/redhatinsights/yggdrasil/internal/routing"
+	"github.com/redhatinsights/yggdrasil/internal/schedule"
 	"github.com/redhatinsights/yggdrasil/internal/sync"
 	"github.com/redhatinsights/yggdrasil/ipc"
 )
@@ -24,41 +31,93 @@ import (
 const (
 	TransmitResponseErr int = -1
 	TransmitResponseOK  int = 0
+
+	// TransmitResponseQueued indicates the message could not be transmitted
+	// immediately and was stored in the outbound queue for later transmission.
+	TransmitResponseQueued int = 1
+
+	// failuresBufferSize is the number of failures held on the failures
+	// channel until they are received.
+	failuresBufferSize = 100
 )
 
 // Dispatcher implements the com.redhat.Yggdrasil1.Dispatcher1 D-Bus interface
 // and is suitable to be exported onto a bus.
 //
-// Dispatcher receives values on its 'inbound' channel and sends them via D-Bus
-// to the destination worker. It sends values on the 'outbound' channel to relay
-// data received from workers to a remote address.
+// Dispatcher receives values on its 'inbound' channel, or through Enqueue, and
+// sends them via D-Bus to the destination worker. Each directive has its own
+// queue, so a slow worker does not delay delivery to other workers. Queued
+// messages that cannot be delivered are sent on the buffered 'failures'
+// channel, and dropped with a logged error if it is full. It sends
+// values on the 'outbound' channel to relay data received from workers to a
+// remote address.
 type Dispatcher struct {
 	HTTPClient     *internalhttp.Client
 	conn           *dbus.Conn
 	features       sync.RWMutexMap[map[string]string]
+	queues         *dispatchQueues
+	contentCache   *content.Cache
+	owners         ownerWaiters
+	recipients     recipientLog
 	MessageJournal *messagejournal.MessageJournal
+	DeadLetters    *deadletter.Store
+	Scheduler      *schedule.Scheduler
+	Router         *routing.Table
 	Dispatchers    chan map[string]map[string]string
 	WorkerEvents   chan ipc.WorkerEvent
+	Failures       chan Failure
 	Inbound        chan yggdrasil.Data
 	Outbound       chan struct {
 		Data yggdrasil.Data
 		Resp chan yggdrasil.Response
 	}
+
+	activationTimeout time.Duration
+	maxAttempts       int
+	retryDelay        time.Duration
+	maxAge            time.Duration
+	clockSkew         time.Duration
 }
 
 func NewDispatcher(client *internalhttp.Client) *Dispatcher {
-	return &Dispatcher{
+	d := &Dispatcher{
 		HTTPClient:     client,
 		features:       sync.RWMutexMap[map[string]string]{},
 		MessageJournal: nil,
 		Dispatchers:    make(chan map[string]map[string]string),
 		WorkerEvents:   make(chan ipc.WorkerEvent),
+		Failures:       make(chan Failure, failuresBufferSize),
 		Inbound:        make(chan yggdrasil.Data),
 		Outbound: make(chan struct {
 			Data yggdrasil.Data
 			Resp chan yggdrasil.Response
 		}),
+		activationTimeout: config.DefaultConfig.ActivationTimeout,
+		maxAttempts:       config.DefaultConfig.DispatchMaxAttempts,
+		retryDelay:        config.DefaultConfig.DispatchRetryDelay,
+		maxAge:            config.DefaultConfig.MessageMaxAge,
+		clockSkew:         config.DefaultConfig.ClockSkew,
+	}
+
+	d.contentCache = content.NewCache(
+		filepath.Join(constants.CacheDir, "content"),
+		int64(config.DefaultConfig.ContentCacheMaxSize)*1024*1024,
+		config.DefaultConfig.ContentCacheMaxAge,
+	)
+
+	var spillDir string
+	if config.DefaultConfig.DispatchQueueFull == QueueFullSpill {
+		spillDir = filepath.Join(constants.StateDir, "dispatch-spill")
 	}
+	d.queues = newDispatchQueues(
+		d.dispatchWithRetry,
+		d.workerAvailable,
+		config.DefaultConfig.DispatchConcurrency,
+		config.DefaultConfig.DispatchQueueDepth,
+		spillDir,
+	)
+
+	return d
 }
 
 // Connect connects the dispatcher to an appropriate D-Bus broker and begins
@@ -214,6 +273,7 @@ func (d *Dispatcher) Connect() error {
 				// If there is a new owner, this signal means a new process
 				// owns the name; add a record to the feature map.
 				if newOwner != "" {
+					d.owners.notify(name)
 					obj := d.conn.Object(
 						name,
 						dbus.ObjectPath(
@@ -271,12 +331,22 @@ func (d *Dispatcher) Connect() error {
 		d.Dispatchers <- d.FlattenDispatchers()
 	}()
 
-	// start goroutine receiving values from the inbound channel and send them
-	// via the Worker D-Bus interface.
+	// Resume dispatching messages spilled to disk by a previous session.
+	if err := d.queues.restore(); err != nil {
+		log.Errorf("cannot restore spilled messages: %v", err)
+	}
+
+	// Start dispatching scheduled messages as they become due.
+	if d.Scheduler != nil {
+		d.Scheduler.Start()
+	}
+
+	// start goroutine receiving values from the inbound channel and add them
+	// to the dispatch queue of their directive.
 	go func() {
 		for data := range d.Inbound {
-			if err := d.Dispatch(data); err != nil {
-				log.Errorf("cannot dispatch data: %v", err)
+			if err := d.Enqueue(data); err != nil {
+				log.Errorf("cannot queue data for dispatch: %v", err)
 				continue
 			}
 		}
@@ -285,6 +355,132 @@ func (d *Dispatcher) Connect() error {
 	return nil
 }
 
+// Enqueue adds data to the dispatch queue of its directive, returning
+// ErrQueueFull if the queue is full and messages are not spilled to disk, and
+// a DispatchError with the code ErrorCodeNoSuchWorker if no worker owns or can
+// be started for the directive. The message is sent to the worker once earlier
+// messages for the same directive have been dispatched. If the scheduler is
+// enabled and data carries schedule metadata, the message is instead held by
+// the scheduler until it is due. If a routing table is set, the message is
+// queued for each worker its directive is routed to.
+func (d *Dispatcher) Enqueue(data yggdrasil.Data) error {
+	var err error
+	data.Directive, err = ScrubName(data.Directive)
+	if err != nil {
+		log.Debug(err)
+	}
+
+	if d.Scheduler != nil && schedule.Scheduled(data) {
+		next, err := d.Scheduler.Add(data)
+		if errors.Is(err, schedule.ErrInvalidSchedule) {
+			return &DispatchError{Code: yggdrasil.ErrorCodeMalformed, Err: err}
+		}
+		if errors.Is(err, schedule.ErrFull) {
+			return &DispatchError{Code: yggdrasil.ErrorCodeQueueFull, Err: err}
+		}
+		if err != nil {
+			return fmt.Errorf("cannot schedule message: %w", err)
+		}
+		log.Infof("scheduled message %v for %v", data.MessageID, next)
+		return nil
+	}
+
+	if d.Router == nil {
+		return d.queues.Enqueue(data)
+	}
+
+	// Queue a copy of the message for each worker the directive is routed to.
+	workers, fallback := d.resolve(data.Directive, data.Metadata)
+	var errs []error
+	for _, worker := range workers {
+		msg := data
+		msg.Directive = worker
+		if fallback != "" && !d.workerAvailable(msg.Directive) {
+			log.Infof(
+				"worker %v is not available, routing message %v to %v",
+				msg.Directive,
+				msg.MessageID,
+				fallback,
+			)
+			msg.Directive = fallback
+		}
+		if msg.Directive != data.Directive {
+			log.Debugf("routing message %v to worker %v", msg.MessageID, msg.Directive)
+		}
+		if err := d.queues.Enqueue(msg); err != nil {
+			errs = append(errs, fmt.Errorf("worker %v: %w", msg.Directive, err))
+			continue
+		}
+		d.recipients.add(msg.MessageID, msg.Directive)
+	}
+	return errors.Join(errs...)
+}
+
+// Targets returns the names of every worker a message for directive with the
+// given metadata may be dispatched to: the directive itself, the workers it is
+// routed to and the fallback worker that receives it if one of them is not
+// available.
+func (d *Dispatcher) Targets(directive string, metadata map[string]string) []string {
+	directive, err := ScrubName(directive)
+	if err != nil {
+		log.Debug(err)
+	}
+
+	targets := []string{directive}
+	workers, fallback := d.resolve(directive, metadata)
+	for _, name := range append(workers, fallback) {
+		if name != "" && !slices.Contains(targets, name) {
+			targets = append(targets, name)
+		}
+	}
+	return targets
+}
+
+// resolve returns the scrubbed names of the workers a message for the
+// scrubbed directive with the given metadata is routed to, and of the
+// fallback worker that receives it if one of them is not available, or an
+// empty fallback if there is none. Without a routing table, the message is
+// routed to the worker named by directive.
+func (d *Dispatcher) resolve(directive string, metadata map[string]string) ([]string, string) {
+	if d.Router == nil {
+		return []string{directive}, ""
+	}
+
+	routed, fallback := d.Router.Route(directive, metadata)
+	workers := make([]string, 0, len(routed)+1)
+	for _, worker := range routed {
+		worker, err := ScrubName(worker)
+		if err != nil {
+			log.Debug(err)
+		}
+		workers = append(workers, worker)
+	}
+	if fallback != "" {
+		var err error
+		fallback, err = ScrubName(fallback)
+		if err != nil {
+			log.Debug(err)
+		}
+	}
+	return workers, fallback
+}
+
+// Recipients returns the names of the workers the message messageID, sent for
+// directive, was queued for. If the message was not routed, or is no longer
+// remembered, the worker named by directive is returned.
+func (d *Dispatcher) Recipients(directive string, messageID string) []string {
+	if workers := d.recipients.get(messageID); len(workers) > 0 {
+		return workers
+	}
+	return []string{directive}
+}
+
+// QueueDepths returns the number of messages waiting in, spilled from and
+// being dispatched from the dispatch queue of each directive.
+func (d *Dispatcher) QueueDepths() map[string]map[string]string {
+	return d.queues.Depths()
+}
+
 func (d *Dispatcher) Dispatch(data yggdrasil.Data) error {
 	var err error
 	data.Directive, err = ScrubName(data.Directive)
@@ -292,6 +488,10 @@ func (d *Dispatcher) Dispatch(data yggdrasil.Data) error {
 		log.Debug(err)
 	}
 
+	if err := d.activateWorker(data.Directive); err != nil {
+		return &DispatchError{Code: yggdrasil.ErrorCodeNoSuchWorker, Err: err}
+	}
+
 	obj := d.conn.Object(
 		"com.redhat.Yggdrasil1.Worker1."+data.Directive,
 		dbus.ObjectPath(filepath.Join("/com/redhat/Yggdrasil1/Worker1/", data.Directive)),
@@ -299,7 +499,8 @@ func (d *Dispatcher) Dispatch(data yggdrasil.Data) error {
 	propertyName := "com.redhat.Yggdrasil1.Worker1.RemoteContent"
 	r, err := obj.GetProperty(propertyName)
 	if err != nil {
-		return fmt.Errorf(
+		return newDispatchError(
+			callErrorCode(err),
 			"cannot get property '%s' of object: %s: using destination interface: %s: %v",
 			propertyName, obj.Path(), obj.Destination(), err,
 		)
@@ -311,30 +512,59 @@ func (d *Dispatcher) Dispatch(data yggdrasil.Data) error {
 		var urlStr string
 		err = json.Unmarshal(data.Content, &urlStr)
 		if err != nil {
-			return fmt.Errorf("unable to unmarshal JSON string fragment: %v", err)
+			return newDispatchError(
+				yggdrasil.ErrorCodeMalformed,
+				"unable to unmarshal JSON string fragment: %v",
+				err,
+			)
 		}
 
 		// When string fragment was unmarshalled, then we can try to parse string as URL
 		URL, err := url.Parse(urlStr)
 		if err != nil {
-			return fmt.Errorf("cannot parse content %v as URL: %v", urlStr, err)
+			return newDispatchError(
+				yggdrasil.ErrorCodeMalformed,
+				"cannot parse content %v as URL: %v",
+				urlStr,
+				err,
+			)
 		}
 		if config.DefaultConfig.DataHost != "" {
 			URL.Host = config.DefaultConfig.DataHost
 		}
 
-		resp, err := d.HTTPClient.Get(URL.String())
+		f, err := d.contentCache.Fetch(d.HTTPClient, URL.String(), data.Metadata)
 		if err != nil {
-			return fmt.Errorf("cannot get detached message content: %v", err)
+			return newDispatchError(
+				fetchErrorCode(err),
+				"cannot get detached message content: %v",
+				err,
+			)
 		}
-		content, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("cannot read response body: %v", err)
+		// The content is released from the cache whether or not the message
+		// is dispatched.
+		defer d.contentCache.Release(URL.String(), data.Metadata)
+
+		// Workers that accept streamed content receive a file descriptor for
+		// the cached content instead, so that it is never held in memory.
+		if d.conn.SupportsUnixFDs() && streamContent(obj) {
+			if err := d.dispatchStream(obj, data, f); err != nil {
+				return err
+			}
+			return d.updateFeatures(obj, data.Directive)
 		}
-		if err := resp.Body.Close(); err != nil {
-			return fmt.Errorf("cannot close response body: %v", err)
+
+		data.Content, err = io.ReadAll(f)
+		if err := f.Close(); err != nil {
+			log.Errorf("cannot close cached content: %v", err)
+		}
+		if err != nil {
+			return newDispatchError(
+				yggdrasil.ErrorCodeContentFetchFailed,
+				"cannot read cached content: %v",
+				err,
+			)
 		}
-		data.Content = content
 	}
 
 	call := obj.Call(
@@ -347,7 +577,8 @@ func (d *Dispatcher) Dispatch(data yggdrasil.Data) error {
 		data.Content,
 	)
 	if err := call.Store(); err != nil {
-		return fmt.Errorf(
+		return newDispatchError(
+			callErrorCode(err),
 			"cannot call 'Dispatch' method on worker: %s of object: %s: using destination interface: %s: %v",
 			data.Directive,
 			obj.Path(),
@@ -357,6 +588,55 @@ func (d *Dispatcher) Dispatch(data yggdrasil.Data) error {
 	}
 	log.Debugf("send message %v to worker %v", data.MessageID, data.Directive)
 
+	return d.updateFeatures(obj, data.Directive)
+}
+
+// dispatchStream calls the DispatchFD method of the worker, passing a file
+// descriptor for the content in f. F is closed once the worker has received
+// its own copy of the file descriptor.
+func (d *Dispatcher) dispatchStream(obj dbus.BusObject, data yggdrasil.Data, f *os.File) error {
+	call := obj.Call(
+		"com.redhat.Yggdrasil1.Worker1.DispatchFD",
+		0,
+		data.Directive,
+		data.MessageID,
+		data.ResponseTo,
+		data.Metadata,
+		dbus.UnixFD(f.Fd()),
+	)
+	if err := f.Close(); err != nil {
+		log.Errorf("cannot close cached content: %v", err)
+	}
+	if err := call.Store(); err != nil {
+		return newDispatchError(
+			callErrorCode(err),
+			"cannot call 'DispatchFD' method on worker: %s of object: %s: using destination interface: %s: %v",
+			data.Directive,
+			obj.Path(),
+			obj.Destination(),
+			err,
+		)
+	}
+	log.Debugf("streaming message %v to worker %v", data.MessageID, data.Directive)
+
+	return nil
+}
+
+// streamContent returns true if the worker accepts content through the
+// DispatchFD method. Workers that do not have the StreamContent property do
+// not.
+func streamContent(obj dbus.BusObject) bool {
+	v, err := obj.GetProperty("com.redhat.Yggdrasil1.Worker1.StreamContent")
+	if err != nil {
+		return false
+	}
+	stream, ok := v.Value().(bool)
+	return ok && stream
+}
+
+// updateFeatures gets the features of the worker for directive and sends the
+// updated map of dispatchers on the dispatchers channel.
+func (d *Dispatcher) updateFeatures(obj dbus.BusObject, directive string) error {
 	v, err := obj.GetProperty("com.redhat.Yggdrasil1.Worker1.Features")
 	if err != nil {
 		return fmt.Errorf("cannot get property 'com.redhat.Yggdrasil1.Worker1.Features': %v", err)
@@ -365,12 +645,41 @@ func (d *Dispatcher) Dispatch(data yggdrasil.Data) error {
 	if !ok {
 		return fmt.Errorf("cannot convert %T to map[string]string", v.Value())
 	}
-	d.features.Set(data.Directive, features)
+	d.features.Set(directive, features)
 	d.Dispatchers <- d.FlattenDispatchers()
 
 	return nil
 }
 
+// RecordJournalEntry adds a message journal entry recording that yggd handled
+// data itself instead of dispatching it to a worker, if the message journal is
+// enabled.
+func (d *Dispatcher) RecordJournalEntry(
+	data *yggdrasil.Data,
+	event ipc.WorkerEventName,
+	eventData map[string]string,
+) {
+	if d.MessageJournal == nil {
+		return
+	}
+	workerMessage := yggdrasil.WorkerMessage{
+		MessageID:  data.MessageID,
+		Sent:       time.Now().UTC(),
+		WorkerName: data.Directive,
+		ResponseTo: data.ResponseTo,
+		WorkerEvent: struct {
+			EventName uint              "json:\"event_name\""
+			EventData map[string]string "json:\"event_data\""
+		}{
+			uint(event),
+			eventData,
+		},
+	}
+	if err := d.MessageJournal.AddEntry(workerMessage); err != nil {
+		log.Errorf("cannot add journal entry: %v", err)
+	}
+}
+
 func (d *Dispatcher) DisconnectWorkers() {
 	if err := d.EmitEvent(ipc.DispatcherEventReceivedDisconnect); err != nil {
 		log.Errorf("cannot emit event: %v", err)
@@ -413,14 +722,117 @@ func (d *Dispatcher) Transmit(
 
 	directive := strings.TrimPrefix(name, "com.redhat.Yggdrasil1.Worker1.")
 
+	responseCode, responseMetadata, responseData, err = d.transmit(
+		directive,
+		addr,
+		messageID,
+		responseTo,
+		metadata,
+		data,
+	)
+	if err != nil {
+		return TransmitResponseErr, nil, nil, NewDBusError("Transmit", err.Error())
+	}
+	return
+}
+
+// TransmitAsync implements the com.redhat.Yggdrasil1.Dispatcher1.TransmitAsync
+// method. It returns as soon as the message is accepted and sends a
+// TransmitCompleted signal to the caller once the message has been sent.
+func (d *Dispatcher) TransmitAsync(
+	sender dbus.Sender,
+	addr string,
+	messageID string,
+	responseTo string,
+	metadata map[string]string,
+	data []byte,
+) *dbus.Error {
+	name, err := d.senderName(sender)
+	if err != nil {
+		return NewDBusError(
+			"TransmitAsync",
+			fmt.Sprintf("cannot get name for sender: %v", err),
+		)
+	}
+
+	directive := strings.TrimPrefix(name, "com.redhat.Yggdrasil1.Worker1.")
+
+	go func() {
+		code, responseMetadata, responseData, err := d.transmit(
+			directive,
+			addr,
+			messageID,
+			responseTo,
+			metadata,
+			data,
+		)
+		if err != nil {
+			log.Errorf("cannot transmit message %v: %v", messageID, err)
+			code = TransmitResponseErr
+			responseMetadata = map[string]string{"error": err.Error()}
+			responseData = []byte{}
+		}
+		if responseMetadata == nil {
+			responseMetadata = map[string]string{}
+		}
+		if responseData == nil {
+			responseData = []byte{}
+		}
+		err = d.emitTransmitCompleted(sender, messageID, code, responseMetadata, responseData)
+		if err != nil {
+			log.Errorf("cannot emit signal: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// emitTransmitCompleted sends the
+// com.redhat.Yggdrasil1.Dispatcher1.TransmitCompleted signal for the message
+// with the given ID to destination only, so that the response is not seen by
+// other workers.
+func (d *Dispatcher) emitTransmitCompleted(
+	destination dbus.Sender,
+	messageID string,
+	responseCode int,
+	responseMetadata map[string]string,
+	responseData []byte,
+) error {
+	path := dbus.ObjectPath("/com/redhat/Yggdrasil1/Dispatcher1")
+	body := []interface{}{messageID, int32(responseCode), responseMetadata, responseData}
+	msg := &dbus.Message{
+		Type: dbus.TypeSignal,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldDestination: dbus.MakeVariant(string(destination)),
+			dbus.FieldPath:        dbus.MakeVariant(path),
+			dbus.FieldInterface:   dbus.MakeVariant("com.redhat.Yggdrasil1.Dispatcher1"),
+			dbus.FieldMember:      dbus.MakeVariant("TransmitCompleted"),
+			dbus.FieldSignature:   dbus.MakeVariant(dbus.SignatureOf(body...)),
+		},
+		Body: body,
+	}
+	return d.conn.Send(msg, nil).Err
+}
+
+// transmit sends data on behalf of the worker handling directive, either
+// directly to addr over HTTP for workers that handle remote content, or via the
+// outbound channel. It waits for the response up to the configured transmit
+// timeout.
+func (d *Dispatcher) transmit(
+	directive string,
+	addr string,
+	messageID string,
+	responseTo string,
+	metadata map[string]string,
+	data []byte,
+) (responseCode int, responseMetadata map[string]string, responseData []byte, err error) {
 	obj := d.conn.Object(
 		"com.redhat.Yggdrasil1.Worker1."+directive,
 		dbus.ObjectPath(filepath.Join("/com/redhat/Yggdrasil1/Worker1/", directive)),
 	)
 	r, err := obj.GetProperty("com.redhat.Yggdrasil1.Worker1.RemoteContent")
 	if err != nil {
-		return -1, nil, nil, NewDBusError(
-			"Transmit",
+		return TransmitResponseErr, nil, nil, fmt.Errorf(
 			"cannot get property 'com.redhat.Yggdrasil1.Worker1.RemoteContent'",
 		)
 	}
@@ -428,75 +840,73 @@ func (d *Dispatcher) Transmit(
 	if r.Value().(bool) {
 		URL, err := url.Parse(addr)
 		if err != nil {
-			return TransmitResponseErr, nil, nil, NewDBusError(
-				"Transmit",
-				fmt.Sprintf("cannot parse addr as URL: %v", err),
+			return TransmitResponseErr, nil, nil, fmt.Errorf("cannot parse addr as URL: %v", err)
+		}
+		if URL.Scheme == "" {
+			return TransmitResponseErr, nil, nil, fmt.Errorf("URL: '%v' has no scheme", addr)
+		}
+		if config.DefaultConfig.DataHost != "" {
+			URL.Host = config.DefaultConfig.DataHost
+		}
+		resp, err := d.HTTPClient.Post(URL.String(), metadata, data)
+		if err != nil {
+			return TransmitResponseErr, nil, nil, fmt.Errorf("cannot perform HTTP request: %v", err)
+		}
+		data, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return TransmitResponseErr, nil, nil, fmt.Errorf(
+				"cannot read HTTP response body: %v",
+				err,
 			)
 		}
-		if URL.Scheme != "" {
-			if config.DefaultConfig.DataHost != "" {
-				URL.Host = config.DefaultConfig.DataHost
-			}
-			resp, err := d.HTTPClient.Post(URL.String(), metadata, data)
-			if err != nil {
-				return TransmitResponseErr, nil, nil, NewDBusError(
-					"Transmit",
-					fmt.Sprintf("cannot perform HTTP request: %v", err),
-				)
-			}
-			data, err = io.ReadAll(resp.Body)
-			if err != nil {
-				return TransmitResponseErr, nil, nil, NewDBusError(
-					"Transmit",
-					fmt.Sprintf("cannot read HTTP response body: %v", err),
-				)
-			}
 
-			err = resp.Body.Close()
-			if err != nil {
-				return TransmitResponseErr, nil, nil, NewDBusError(
-					"Transmit",
-					fmt.Sprintf("cannot close HTTP response body: %v", err),
-				)
-			}
-			responseCode = resp.StatusCode
-			responseMetadata = make(map[string]string)
-			for header := range resp.Header {
-				responseMetadata[header] = resp.Header.Get(header)
-			}
-			responseData = data
-		} else {
-			return TransmitResponseErr, nil, nil, NewDBusError("Transmit", fmt.Sprintf("URL: '%v' has no scheme", addr))
+		err = resp.Body.Close()
+		if err != nil {
+			return TransmitResponseErr, nil, nil, fmt.Errorf(
+				"cannot close HTTP response body: %v",
+				err,
+			)
 		}
-	} else {
-		ch := make(chan yggdrasil.Response)
-		d.Outbound <- struct {
-			Data yggdrasil.Data
-			Resp chan yggdrasil.Response
-		}{
-			Data: yggdrasil.Data{
-				Type:       yggdrasil.MessageTypeData,
-				MessageID:  messageID,
-				ResponseTo: responseTo,
-				Version:    1,
-				Sent:       time.Now(),
-				Directive:  addr,
-				Metadata:   metadata,
-				Content:    data,
-			},
-			Resp: ch,
-		}
-
-		select {
-		case resp := <-ch:
-			responseCode = resp.Code
-			responseMetadata = resp.Metadata
-			responseData = resp.Data
-		case <-time.After(1 * time.Second):
-			return TransmitResponseErr, nil, nil, NewDBusError("com.redhat.Yggdrasil1.Dispatcher1.Transmit", "timeout reached waiting for response")
+		responseCode = resp.StatusCode
+		responseMetadata = make(map[string]string)
+		for header := range resp.Header {
+			responseMetadata[header] = resp.Header.Get(header)
 		}
+		responseData = data
+		return responseCode, responseMetadata, responseData, nil
+	}
+
+	ch := make(chan yggdrasil.Response, 1)
+	d.Outbound <- struct {
+		Data yggdrasil.Data
+		Resp chan yggdrasil.Response
+	}{
+		Data: yggdrasil.Data{
+			Type:       yggdrasil.MessageTypeData,
+			MessageID:  messageID,
+			ResponseTo: responseTo,
+			Version:    1,
+			Sent:       time.Now(),
+			Directive:  addr,
+			Metadata:   metadata,
+			Content:    data,
+		},
+		Resp: ch,
+	}
+
+	// When acknowledgements are enabled, also wait for the server to
+	// acknowledge the message.
+	timeout := config.DefaultConfig.TransmitTimeout
+	if config.DefaultConfig.TransmitAck {
+		timeout += config.DefaultConfig.TransmitAckTimeout
+	}
+
+	select {
+	case resp := <-ch:
+		return resp.Code, resp.Metadata, resp.Data, nil
+	case <-time.After(timeout):
+		return TransmitResponseErr, nil, nil, fmt.Errorf("timeout reached waiting for response")
 	}
-	return
 }
 
 // senderName retrieves a list of names from the bus object, iterating over each
@@ -584,7 +994,8 @@ func (d *Dispatcher) CancelMessage(directive, message_id, cancel_id string) erro
 		message_id,
 		cancel_id)
 	if err := call.Store(); err != nil {
-		return fmt.Errorf(
+		return newDispatchError(
+			callErrorCode(err),
 			"cannot call Cancel method with message %v on worker %v: %v",
 			cancel_id,
 			directive,
@@ -1,12 +1,26 @@
 package work
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/redhatinsights/yggdrasil"
+	"github.com/redhatinsights/yggdrasil/internal/config"
+	"github.com/redhatinsights/yggdrasil/internal/content"
+	internalhttp "github.com/redhatinsights/yggdrasil/internal/http"
 	"github.com/redhatinsights/yggdrasil/ipc"
+	"github.com/redhatinsights/yggdrasil/worker/workertest"
 )
 
 func TestWorkerEventFromSignal(t *testing.T) {
@@ -136,3 +150,188 @@ func TestWorkerEventFromSignal(t *testing.T) {
 		})
 	}
 }
+
+// connectWorker connects to the bus at address as a worker handling directive,
+// subscribing to the com.redhat.Yggdrasil1.Dispatcher1 signals.
+func connectWorker(t *testing.T, address string, directive string) (*dbus.Conn, chan *dbus.Signal) {
+	t.Helper()
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	path := dbus.ObjectPath("/com/redhat/Yggdrasil1/Worker1/" + directive)
+	_, err = prop.Export(conn, path, prop.Map{
+		"com.redhat.Yggdrasil1.Worker1": {
+			"RemoteContent": {Value: false, Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName("com.redhat.Yggdrasil1.Worker1."+directive, 0)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("cannot request name: %v", err)
+	}
+
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchInterface("com.redhat.Yggdrasil1.Dispatcher1"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+	return conn, signals
+}
+
+func TestTransmitAsync(t *testing.T) {
+	timeout := config.DefaultConfig.TransmitTimeout
+	t.Cleanup(func() {
+		config.DefaultConfig.TransmitTimeout = timeout
+	})
+	config.DefaultConfig.TransmitTimeout = 5 * time.Second
+	address := workertest.StartDaemon(t)
+
+	d := NewDispatcher(nil)
+	var err error
+	d.conn, err = dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.conn.Close()
+	err = d.conn.Export(
+		d,
+		"/com/redhat/Yggdrasil1/Dispatcher1",
+		"com.redhat.Yggdrasil1.Dispatcher1",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for msg := range d.Outbound {
+			msg.Resp <- yggdrasil.Response{
+				Code:     202,
+				Metadata: map[string]string{"a": "b"},
+				Data:     msg.Data.Content,
+			}
+		}
+	}()
+
+	conn, signals := connectWorker(t, address, "sender")
+	_, otherSignals := connectWorker(t, address, "other")
+
+	call := conn.Object(d.conn.Names()[0], "/com/redhat/Yggdrasil1/Dispatcher1").Call(
+		"com.redhat.Yggdrasil1.Dispatcher1.TransmitAsync",
+		0,
+		"sender",
+		"1234",
+		"",
+		map[string]string{},
+		[]byte("hello"),
+	)
+	if call.Err != nil {
+		t.Fatal(call.Err)
+	}
+
+	var (
+		id           string
+		code         int32
+		respMetadata map[string]string
+		respData     []byte
+	)
+	select {
+	case s := <-signals:
+		if s.Name != "com.redhat.Yggdrasil1.Dispatcher1.TransmitCompleted" {
+			t.Fatalf("unexpected signal: %v", s.Name)
+		}
+		if err := dbus.Store(s.Body, &id, &code, &respMetadata, &respData); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TransmitCompleted was not received")
+	}
+	if id != "1234" || code != 202 || respMetadata["a"] != "b" || string(respData) != "hello" {
+		t.Errorf("unexpected signal body: %v %v %v %v", id, code, respMetadata, respData)
+	}
+
+	// Other workers do not receive the response.
+	select {
+	case s := <-otherSignals:
+		t.Errorf("unexpected signal received by other worker: %v", s.Name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// failingWorker is a worker that fails to handle every message.
+type failingWorker struct{}
+
+func (failingWorker) Dispatch(
+	directive string,
+	messageID string,
+	responseTo string,
+	metadata map[string]string,
+	data []byte,
+) *dbus.Error {
+	return dbus.MakeFailedError(fmt.Errorf("cannot handle message"))
+}
+
+func TestDispatchReleaseContent(t *testing.T) {
+	address := workertest.StartDaemon(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	path := dbus.ObjectPath("/com/redhat/Yggdrasil1/Worker1/remote")
+	_, err = prop.Export(conn, path, prop.Map{
+		"com.redhat.Yggdrasil1.Worker1": {
+			"RemoteContent": {Value: true, Emit: prop.EmitTrue},
+			"Features":      {Value: map[string]string{}, Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Export(failingWorker{}, path, "com.redhat.Yggdrasil1.Worker1"); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName("com.redhat.Yggdrasil1.Worker1.remote", 0)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("cannot request name: %v", err)
+	}
+
+	cacheDir := t.TempDir()
+	d := NewDispatcher(internalhttp.NewHTTPClient(nil, "test"))
+	d.contentCache = content.NewCache(cacheDir, 0, 0)
+	d.conn, err = dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.conn.Close()
+
+	err = d.Dispatch(yggdrasil.Data{
+		MessageID: "1234",
+		Directive: "remote",
+		Content:   json.RawMessage(`"` + ts.URL + `"`),
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// The content is released even though the message was not dispatched.
+	blobs, err := os.ReadDir(filepath.Join(cacheDir, "blobs"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	if len(blobs) != 0 {
+		t.Errorf("unexpected cached content: %v", blobs)
+	}
+}
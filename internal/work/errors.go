@@ -1,6 +1,14 @@
 package work
 
-import "reflect"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/redhatinsights/yggdrasil"
+	"github.com/redhatinsights/yggdrasil/internal/content"
+)
 
 // typeConversionError represents a conversion error when converting one type
 // to  another.
@@ -33,3 +41,61 @@ func newStringMapTypeConversionError(parameter interface{}) typeConversionError
 		t: reflect.TypeOf(map[string]string{}),
 	}
 }
+
+// DispatchError is returned when a message cannot be dispatched to a worker.
+// Code classifies the failure so that it can be reported to the server.
+type DispatchError struct {
+	Code yggdrasil.ErrorCode
+	Err  error
+}
+
+func (e *DispatchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DispatchError) Unwrap() error {
+	return e.Err
+}
+
+// newDispatchError creates a DispatchError with the given code, formatting
+// the error according to a format specifier.
+func newDispatchError(code yggdrasil.ErrorCode, format string, a ...interface{}) error {
+	return &DispatchError{Code: code, Err: fmt.Errorf(format, a...)}
+}
+
+// callErrorCode classifies an error returned by a method call on a worker. If
+// the worker does not own its name on the bus, ErrorCodeNoSuchWorker is
+// returned. If the worker is handling as many messages as it can,
+// ErrorCodeQueueFull is returned.
+func callErrorCode(err error) yggdrasil.ErrorCode {
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) {
+		switch dbusErr.Name {
+		case "org.freedesktop.DBus.Error.ServiceUnknown",
+			"org.freedesktop.DBus.Error.NameHasNoOwner":
+			return yggdrasil.ErrorCodeNoSuchWorker
+		case "com.redhat.Yggdrasil1.Worker1.Busy":
+			return yggdrasil.ErrorCodeQueueFull
+		}
+	}
+	return yggdrasil.ErrorCodeWorkerFailed
+}
+
+// fetchErrorCode classifies an error returned when fetching the detached
+// content of a message.
+func fetchErrorCode(err error) yggdrasil.ErrorCode {
+	switch {
+	case errors.Is(err, content.ErrInvalidMetadata):
+		return yggdrasil.ErrorCodeMalformed
+	case errors.Is(err, content.ErrMismatch):
+		return yggdrasil.ErrorCodeContentMismatch
+	}
+	return yggdrasil.ErrorCodeContentFetchFailed
+}
+
+// Failure is sent on the dispatcher's Failures channel when a message queued
+// for dispatch could not be delivered to a worker.
+type Failure struct {
+	Data yggdrasil.Data
+	Err  error
+}
@@ -0,0 +1,51 @@
+package work
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/redhatinsights/yggdrasil"
+)
+
+func TestCallErrorCode(t *testing.T) {
+	tests := []struct {
+		description string
+		input       error
+		want        yggdrasil.ErrorCode
+	}{
+		{
+			description: "no owner",
+			input:       dbus.Error{Name: "org.freedesktop.DBus.Error.NameHasNoOwner"},
+			want:        yggdrasil.ErrorCodeNoSuchWorker,
+		},
+		{
+			description: "busy",
+			input: fmt.Errorf(
+				"cannot call method: %w",
+				dbus.Error{Name: "com.redhat.Yggdrasil1.Worker1.Busy"},
+			),
+			want: yggdrasil.ErrorCodeQueueFull,
+		},
+		{
+			description: "failed",
+			input:       dbus.Error{Name: "org.freedesktop.DBus.Error.Failed"},
+			want:        yggdrasil.ErrorCodeWorkerFailed,
+		},
+		{
+			description: "other",
+			input:       errors.New("connection closed"),
+			want:        yggdrasil.ErrorCodeWorkerFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got := callErrorCode(test.input)
+			if got != test.want {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
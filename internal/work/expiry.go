@@ -0,0 +1,58 @@
+package work
+
+import (
+	"time"
+
+	"github.com/redhatinsights/yggdrasil"
+)
+
+// expiry returns the time at which data expires, being the earliest of the
+// expiry given by its TTL and the expiry given by maxAge. If data does not
+// expire, the zero time is returned.
+func expiry(data yggdrasil.Data, maxAge time.Duration) time.Time {
+	if data.Sent.IsZero() {
+		return time.Time{}
+	}
+
+	var limit time.Duration
+	if data.TTL > 0 {
+		limit = time.Duration(data.TTL) * time.Second
+	}
+	if maxAge > 0 && (limit == 0 || maxAge < limit) {
+		limit = maxAge
+	}
+	if limit == 0 {
+		return time.Time{}
+	}
+
+	return data.Sent.Add(limit)
+}
+
+// checkExpiry returns a DispatchError with the code ErrorCodeExpired if data
+// expired before now. Since a message sent in the future would expire later
+// than intended, a message that expires and was sent more than skew after now
+// is rejected as well. Skew only tolerates differences between the clocks of
+// the client and server, and never extends the lifetime of a message.
+func checkExpiry(data yggdrasil.Data, maxAge, skew time.Duration, now time.Time) error {
+	expires := expiry(data, maxAge)
+	if expires.IsZero() {
+		return nil
+	}
+	if data.Sent.After(now.Add(skew)) {
+		return newDispatchError(
+			yggdrasil.ErrorCodeExpired,
+			"message %v was sent in the future at %v",
+			data.MessageID,
+			data.Sent.UTC().Format(time.RFC3339),
+		)
+	}
+	if now.Before(expires) {
+		return nil
+	}
+	return newDispatchError(
+		yggdrasil.ErrorCodeExpired,
+		"message %v expired at %v",
+		data.MessageID,
+		expires.UTC().Format(time.RFC3339),
+	)
+}
@@ -0,0 +1,91 @@
+package work
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redhatinsights/yggdrasil"
+)
+
+func TestCheckExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+		input       yggdrasil.Data
+		maxAge      time.Duration
+		skew        time.Duration
+		wantExpired bool
+	}{
+		{
+			description: "no limit",
+			input:       yggdrasil.Data{Sent: now.Add(-72 * time.Hour)},
+		},
+		{
+			description: "no sent time",
+			input:       yggdrasil.Data{TTL: 60},
+			maxAge:      time.Hour,
+		},
+		{
+			description: "ttl",
+			input:       yggdrasil.Data{Sent: now.Add(-2 * time.Minute), TTL: 60},
+			wantExpired: true,
+		},
+		{
+			description: "ttl not extended by skew",
+			input:       yggdrasil.Data{Sent: now.Add(-2 * time.Minute), TTL: 60},
+			skew:        5 * time.Minute,
+			wantExpired: true,
+		},
+		{
+			description: "max age",
+			input:       yggdrasil.Data{Sent: now.Add(-2 * time.Hour)},
+			maxAge:      time.Hour,
+			wantExpired: true,
+		},
+		{
+			description: "max age shorter than ttl",
+			input:       yggdrasil.Data{Sent: now.Add(-2 * time.Hour), TTL: 86400},
+			maxAge:      time.Hour,
+			wantExpired: true,
+		},
+		{
+			description: "ttl shorter than max age",
+			input:       yggdrasil.Data{Sent: now.Add(-2 * time.Hour), TTL: 60},
+			maxAge:      24 * time.Hour,
+			wantExpired: true,
+		},
+		{
+			description: "sent in the future within skew",
+			input:       yggdrasil.Data{Sent: now.Add(2 * time.Minute), TTL: 60},
+			skew:        5 * time.Minute,
+		},
+		{
+			description: "sent in the future",
+			input:       yggdrasil.Data{Sent: now.Add(time.Hour), TTL: 60},
+			skew:        5 * time.Minute,
+			wantExpired: true,
+		},
+		{
+			description: "sent in the future without limit",
+			input:       yggdrasil.Data{Sent: now.Add(time.Hour)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			err := checkExpiry(test.input, test.maxAge, test.skew, now)
+			if !test.wantExpired {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var dispatchErr *DispatchError
+			if !errors.As(err, &dispatchErr) || dispatchErr.Code != yggdrasil.ErrorCodeExpired {
+				t.Errorf("%v is not an expired error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package work
+
+import (
+	"sync"
+	"time"
+
+	"github.com/redhatinsights/yggdrasil/ipc"
+)
+
+// EventForwarder decides which worker events are forwarded to the server.
+// Only BEGIN, WORKING and END events of the configured directives are
+// forwarded, and WORKING events for the same message are throttled so that a
+// chatty worker cannot flood the broker.
+type EventForwarder struct {
+	directives map[string]bool
+	interval   time.Duration
+
+	mu      sync.Mutex
+	working map[string]time.Time
+	now     func() time.Time
+}
+
+// NewEventForwarder creates an EventForwarder for the given directives, where
+// "*" matches every directive. At most one WORKING event is forwarded for each
+// message during interval.
+func NewEventForwarder(directives []string, interval time.Duration) *EventForwarder {
+	f := &EventForwarder{
+		directives: make(map[string]bool),
+		interval:   interval,
+		working:    make(map[string]time.Time),
+		now:        time.Now,
+	}
+	for _, directive := range directives {
+		f.directives[directive] = true
+	}
+	return f
+}
+
+// Forward returns true if event should be forwarded to the server.
+func (f *EventForwarder) Forward(event ipc.WorkerEvent) bool {
+	if !f.directives["*"] && !f.directives[event.Worker] {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+
+	// Forget messages whose workers stopped sending WORKING events without
+	// sending END, so that the map does not grow without bound.
+	for messageID, forwarded := range f.working {
+		if now.Sub(forwarded) >= f.interval {
+			delete(f.working, messageID)
+		}
+	}
+
+	switch event.Name {
+	case ipc.WorkerEventNameBegin:
+		return true
+	case ipc.WorkerEventNameWorking:
+		if _, has := f.working[event.MessageID]; has {
+			return false
+		}
+		f.working[event.MessageID] = now
+		return true
+	case ipc.WorkerEventNameEnd:
+		delete(f.working, event.MessageID)
+		return true
+	default:
+		return false
+	}
+}
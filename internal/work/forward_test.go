@@ -0,0 +1,89 @@
+package work
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/redhatinsights/yggdrasil/ipc"
+)
+
+func TestEventForwarderForward(t *testing.T) {
+	type step struct {
+		advance   time.Duration
+		worker    string
+		name      ipc.WorkerEventName
+		messageID string
+		want      bool
+	}
+
+	tests := []struct {
+		description string
+		directives  []string
+		steps       []step
+	}{
+		{
+			description: "disabled",
+			steps: []step{
+				{worker: "echo", name: ipc.WorkerEventNameBegin, messageID: "1"},
+			},
+		},
+		{
+			description: "directive",
+			directives:  []string{"echo"},
+			steps: []step{
+				{worker: "echo", name: ipc.WorkerEventNameBegin, messageID: "1", want: true},
+				{worker: "other", name: ipc.WorkerEventNameBegin, messageID: "2"},
+				{worker: "echo", name: ipc.WorkerEventNameStarted},
+			},
+		},
+		{
+			description: "throttled",
+			directives:  []string{"*"},
+			steps: []step{
+				{worker: "echo", name: ipc.WorkerEventNameBegin, messageID: "1", want: true},
+				{worker: "echo", name: ipc.WorkerEventNameWorking, messageID: "1", want: true},
+				{
+					advance:   time.Second,
+					worker:    "echo",
+					name:      ipc.WorkerEventNameWorking,
+					messageID: "1",
+				},
+				{worker: "echo", name: ipc.WorkerEventNameWorking, messageID: "2", want: true},
+				{
+					advance:   5 * time.Second,
+					worker:    "echo",
+					name:      ipc.WorkerEventNameWorking,
+					messageID: "1",
+					want:      true,
+				},
+				{worker: "echo", name: ipc.WorkerEventNameEnd, messageID: "1", want: true},
+				{worker: "echo", name: ipc.WorkerEventNameWorking, messageID: "1", want: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			now := time.Now()
+			f := NewEventForwarder(test.directives, 5*time.Second)
+			f.now = func() time.Time { return now }
+
+			var got []bool
+			var want []bool
+			for _, step := range test.steps {
+				now = now.Add(step.advance)
+				got = append(got, f.Forward(ipc.WorkerEvent{
+					Worker:    step.worker,
+					Name:      step.name,
+					MessageID: step.messageID,
+				}))
+				want = append(want, step.want)
+			}
+
+			if !cmp.Equal(got, want) {
+				t.Errorf("%v", cmp.Diff(got, want))
+			}
+		})
+	}
+}
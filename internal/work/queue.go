@@ -0,0 +1,395 @@
+package work
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/redhatinsights/yggdrasil"
+)
+
+const (
+	// QueueFullReject rejects messages for a directive whose dispatch queue
+	// is full.
+	QueueFullReject = "reject"
+
+	// QueueFullSpill stores messages for a directive whose dispatch queue is
+	// full on disk until the queue has room for them.
+	QueueFullSpill = "spill"
+)
+
+// directivePattern matches directive names that are valid as the last element
+// of a worker's bus name.
+var directivePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ErrQueueFull is returned when a message cannot be queued for dispatch
+// because the directive's dispatch queue is full.
+var ErrQueueFull = errors.New("dispatch queue is full")
+
+// errQueueClosed is returned by push when the queue was removed after being
+// idle, so that a new queue must be created for the directive.
+var errQueueClosed = errors.New("dispatch queue is closed")
+
+// queueIdleTimeout is the duration a dispatch queue must be empty and idle
+// before it is removed and its goroutines exit.
+const queueIdleTimeout = 5 * time.Minute
+
+// dispatchFunc makes attempt number attempt, counting from 1, to dispatch
+// data. It returns the delay after which the message should be queued again
+// for another attempt, or zero if no further attempt is to be made.
+type dispatchFunc func(data yggdrasil.Data, attempt int) time.Duration
+
+// queuedMessage is a message held in a dispatch queue together with the
+// number of attempts already made to dispatch it.
+type queuedMessage struct {
+	data     yggdrasil.Data
+	attempts int
+}
+
+// dispatchQueue holds messages waiting to be dispatched to the worker for a
+// single directive. Messages are dispatched by a fixed number of goroutines.
+// When spilling is enabled, messages that do not fit in the queue are written
+// to files in spillDir and moved back into the queue, in order, as it drains.
+// Messages waiting to be queued again after a failed attempt are counted in
+// retrying.
+type dispatchQueue struct {
+	mu       sync.Mutex
+	messages chan queuedMessage
+	active   int
+	retrying int
+	spilled  []string
+	spillDir string
+	closed   bool
+}
+
+// dispatchQueues holds the dispatch queue of each directive, creating queues
+// as messages for new directives are enqueued and removing queues that have
+// been idle for idleTimeout.
+type dispatchQueues struct {
+	mu          sync.Mutex
+	queues      map[string]*dispatchQueue
+	dispatch    dispatchFunc
+	available   func(directive string) bool
+	concurrency int
+	depth       int
+	spillDir    string
+	idleTimeout time.Duration
+}
+
+// newDispatchQueues creates a set of dispatch queues holding at most depth
+// messages per directive and calling dispatch from concurrency goroutines per
+// directive. If spillDir is not empty, messages that do not fit in a queue are
+// stored in spillDir instead of being rejected. If available is not nil, a
+// queue is only created for a directive for which it returns true.
+func newDispatchQueues(
+	dispatch dispatchFunc,
+	available func(directive string) bool,
+	concurrency int,
+	depth int,
+	spillDir string,
+) *dispatchQueues {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if depth < 1 {
+		depth = 1
+	}
+	return &dispatchQueues{
+		queues:      make(map[string]*dispatchQueue),
+		dispatch:    dispatch,
+		available:   available,
+		concurrency: concurrency,
+		depth:       depth,
+		spillDir:    spillDir,
+		idleTimeout: queueIdleTimeout,
+	}
+}
+
+// restore creates queues for directives with messages spilled to disk by a
+// previous session, so that they are dispatched.
+func (qs *dispatchQueues) restore() error {
+	if qs.spillDir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(qs.spillDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("cannot read spill directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !directivePattern.MatchString(entry.Name()) {
+			continue
+		}
+		if _, err := qs.queue(entry.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Enqueue adds data to the dispatch queue of its directive. If the queue is
+// full and spilling is disabled, ErrQueueFull is returned. If the directive
+// has no queue and no worker is available for it, a DispatchError with the
+// code ErrorCodeNoSuchWorker is returned.
+func (qs *dispatchQueues) Enqueue(data yggdrasil.Data) error {
+	if !directivePattern.MatchString(data.Directive) {
+		return newDispatchError(
+			yggdrasil.ErrorCodeMalformed,
+			"invalid directive: %v",
+			data.Directive,
+		)
+	}
+	if !qs.has(data.Directive) && qs.available != nil && !qs.available(data.Directive) {
+		return newDispatchError(
+			yggdrasil.ErrorCodeNoSuchWorker,
+			"no worker for directive: %v",
+			data.Directive,
+		)
+	}
+	for {
+		q, err := qs.queue(data.Directive)
+		if err != nil {
+			return err
+		}
+		if err := q.push(data); !errors.Is(err, errQueueClosed) {
+			return err
+		}
+	}
+}
+
+// has returns true if directive has a dispatch queue.
+func (qs *dispatchQueues) has(directive string) bool {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	_, has := qs.queues[directive]
+	return has
+}
+
+// Depths returns the number of queued, spilled, in-progress and retrying
+// messages for each directive.
+func (qs *dispatchQueues) Depths() map[string]map[string]string {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	depths := make(map[string]map[string]string)
+	for directive, q := range qs.queues {
+		q.mu.Lock()
+		depths[directive] = map[string]string{
+			"queued":      strconv.Itoa(len(q.messages)),
+			"spilled":     strconv.Itoa(len(q.spilled)),
+			"active":      strconv.Itoa(q.active),
+			"retrying":    strconv.Itoa(q.retrying),
+			"depth":       strconv.Itoa(qs.depth),
+			"concurrency": strconv.Itoa(qs.concurrency),
+		}
+		q.mu.Unlock()
+	}
+	return depths
+}
+
+// queue returns the dispatch queue for directive, creating it and starting
+// its goroutines if it does not exist.
+func (qs *dispatchQueues) queue(directive string) (*dispatchQueue, error) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	if q, has := qs.queues[directive]; has {
+		return q, nil
+	}
+
+	q := &dispatchQueue{
+		messages: make(chan queuedMessage, qs.depth),
+	}
+	if qs.spillDir != "" {
+		q.spillDir = filepath.Join(qs.spillDir, directive)
+		if err := q.loadSpilled(); err != nil {
+			return nil, err
+		}
+		q.refill()
+	}
+	qs.queues[directive] = q
+
+	for i := 0; i < qs.concurrency; i++ {
+		go qs.run(directive, q)
+	}
+
+	return q, nil
+}
+
+// run dispatches messages received from q until it is closed. A message whose
+// dispatch attempt failed is queued again after the delay returned by
+// qs.dispatch, so that run goes on dispatching other messages meanwhile. When
+// no message is received for qs.idleTimeout, q is removed if it is idle.
+func (qs *dispatchQueues) run(directive string, q *dispatchQueue) {
+	idle := time.NewTimer(qs.idleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case m, ok := <-q.messages:
+			if !ok {
+				return
+			}
+			q.mu.Lock()
+			q.active++
+			q.refill()
+			q.mu.Unlock()
+
+			m.attempts++
+			delay := qs.dispatch(m.data, m.attempts)
+
+			q.mu.Lock()
+			q.active--
+			if delay > 0 {
+				q.retrying++
+				time.AfterFunc(delay, func() { q.requeue(m, delay) })
+			}
+			q.mu.Unlock()
+		case <-idle.C:
+			qs.reap(directive, q)
+		}
+
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(qs.idleTimeout)
+	}
+}
+
+// reap removes q and closes it, ending its goroutines, if it holds no queued,
+// spilled or in-progress messages. Its spill directory is removed if empty.
+func (qs *dispatchQueues) reap(directive string, q *dispatchQueue) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || len(q.messages) > 0 || len(q.spilled) > 0 || q.active > 0 || q.retrying > 0 {
+		return
+	}
+	q.closed = true
+	close(q.messages)
+	if qs.queues[directive] == q {
+		delete(qs.queues, directive)
+	}
+	if q.spillDir != "" {
+		if err := os.Remove(q.spillDir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Debugf("cannot remove spill directory: %v", err)
+		}
+	}
+	log.Debugf("removed idle dispatch queue for %v", directive)
+}
+
+// push adds data to the queue. Once any message has been spilled, later
+// messages are spilled too until the spilled messages have been moved back
+// into the queue, so that messages are dispatched in the order received.
+func (q *dispatchQueue) push(data yggdrasil.Data) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+	if len(q.spilled) == 0 {
+		select {
+		case q.messages <- queuedMessage{data: data}:
+			return nil
+		default:
+		}
+	}
+
+	if q.spillDir == "" {
+		return ErrQueueFull
+	}
+	return q.spill(data)
+}
+
+// requeue adds m back to the queue for another dispatch attempt. If the queue
+// is full, m is added after a further delay. The queue is not removed while
+// messages are waiting to be added back.
+func (q *dispatchQueue) requeue(m queuedMessage, delay time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case q.messages <- m:
+		q.retrying--
+	default:
+		time.AfterFunc(delay, func() { q.requeue(m, delay) })
+	}
+}
+
+// spill writes data to a new file in the spill directory. q.mu must be held by
+// the caller.
+func (q *dispatchQueue) spill(data yggdrasil.Data) error {
+	if err := os.MkdirAll(q.spillDir, 0750); err != nil {
+		return fmt.Errorf("cannot create spill directory: %w", err)
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("cannot marshal message: %w", err)
+	}
+	file := filepath.Join(q.spillDir, fmt.Sprintf("%020d.json", time.Now().UnixNano()))
+	if err := os.WriteFile(file, b, 0600); err != nil {
+		return fmt.Errorf("cannot write spilled message: %w", err)
+	}
+	q.spilled = append(q.spilled, file)
+	log.Debugf("spilled message %v to '%v'", data.MessageID, file)
+	return nil
+}
+
+// refill moves spilled messages back into the queue while it has room. q.mu
+// must be held by the caller.
+func (q *dispatchQueue) refill() {
+	for len(q.spilled) > 0 && len(q.messages) < cap(q.messages) {
+		file := q.spilled[0]
+		q.spilled = q.spilled[1:]
+
+		b, err := os.ReadFile(file)
+		if err != nil {
+			log.Errorf("cannot read spilled message: %v", err)
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			log.Errorf("cannot remove spilled message: %v", err)
+		}
+		var data yggdrasil.Data
+		if err := json.Unmarshal(b, &data); err != nil {
+			log.Errorf("cannot unmarshal spilled message: %v", err)
+			continue
+		}
+		q.messages <- queuedMessage{data: data}
+	}
+}
+
+// loadSpilled reads the names of files spilled by a previous session, oldest
+// first.
+func (q *dispatchQueue) loadSpilled() error {
+	entries, err := os.ReadDir(q.spillDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("cannot read spill directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && filepath.Ext(entry.Name()) == ".json" {
+			q.spilled = append(q.spilled, filepath.Join(q.spillDir, entry.Name()))
+		}
+	}
+	sort.Strings(q.spilled)
+	return nil
+}
@@ -0,0 +1,244 @@
+package work
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/redhatinsights/yggdrasil"
+)
+
+// recorder records the IDs of dispatched messages. Messages for the directive
+// "slow" block until release is closed.
+type recorder struct {
+	mu         sync.Mutex
+	dispatched []string
+	release    chan struct{}
+	done       chan string
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		release: make(chan struct{}),
+		done:    make(chan string, 100),
+	}
+}
+
+func (r *recorder) dispatch(data yggdrasil.Data, attempt int) time.Duration {
+	if data.Directive == "slow" {
+		<-r.release
+	}
+	r.mu.Lock()
+	r.dispatched = append(r.dispatched, data.MessageID)
+	r.mu.Unlock()
+	r.done <- data.MessageID
+	return 0
+}
+
+// wait waits for n messages to be dispatched.
+func (r *recorder) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.done:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for message %v of %v", i+1, n)
+		}
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	r := newRecorder()
+	queues := newDispatchQueues(r.dispatch, nil, 1, 2, "")
+
+	// The first message is being dispatched, the next two fill the queue.
+	for _, id := range []string{"1", "2", "3"} {
+		if err := queues.Enqueue(yggdrasil.Data{MessageID: id, Directive: "slow"}); err != nil {
+			t.Fatal(err)
+		}
+		if id == "1" {
+			waitActive(t, queues, "slow", 1)
+		}
+	}
+	err := queues.Enqueue(yggdrasil.Data{MessageID: "4", Directive: "slow"})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("%v != %v", err, ErrQueueFull)
+	}
+
+	// Other directives are not blocked by the slow directive.
+	if err := queues.Enqueue(yggdrasil.Data{MessageID: "5", Directive: "fast"}); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, 1)
+
+	got := queues.Depths()["slow"]
+	want := map[string]string{
+		"queued":      "2",
+		"spilled":     "0",
+		"active":      "1",
+		"retrying":    "0",
+		"depth":       "2",
+		"concurrency": "1",
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+
+	close(r.release)
+	r.wait(t, 3)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !cmp.Equal(r.dispatched, []string{"5", "1", "2", "3"}) {
+		t.Errorf("%v", cmp.Diff(r.dispatched, []string{"5", "1", "2", "3"}))
+	}
+}
+
+func TestEnqueueInvalidDirective(t *testing.T) {
+	queues := newDispatchQueues(newRecorder().dispatch, nil, 1, 1, "")
+	if err := queues.Enqueue(yggdrasil.Data{Directive: "../echo"}); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestEnqueueSpill(t *testing.T) {
+	spillDir := t.TempDir()
+
+	// Spill messages without dispatching any of them.
+	blocked := newRecorder()
+	queues := newDispatchQueues(blocked.dispatch, nil, 1, 1, spillDir)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if err := queues.Enqueue(yggdrasil.Data{MessageID: id, Directive: "slow"}); err != nil {
+			t.Fatal(err)
+		}
+		if id == "1" {
+			waitActive(t, queues, "slow", 1)
+		}
+	}
+	if got := queues.Depths()["slow"]["spilled"]; got != "2" {
+		t.Fatalf("%v != %v", got, "2")
+	}
+
+	// Spilled messages are dispatched in order by a new session.
+	r := newRecorder()
+	close(r.release)
+	queues = newDispatchQueues(r.dispatch, nil, 1, 1, spillDir)
+	if err := queues.restore(); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, 2)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !cmp.Equal(r.dispatched, []string{"3", "4"}) {
+		t.Errorf("%v", cmp.Diff(r.dispatched, []string{"3", "4"}))
+	}
+}
+
+func TestEnqueueRetry(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []string
+	done := make(chan struct{})
+	dispatch := func(data yggdrasil.Data, attempt int) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, data.MessageID+"/"+strconv.Itoa(attempt))
+		if data.MessageID == "1" && attempt < 3 {
+			return 10 * time.Millisecond
+		}
+		if data.MessageID == "1" {
+			close(done)
+		}
+		return 0
+	}
+	queues := newDispatchQueues(dispatch, nil, 1, 2, "")
+
+	for _, id := range []string{"1", "2"} {
+		if err := queues.Enqueue(yggdrasil.Data{MessageID: id, Directive: "echo"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for retries")
+	}
+
+	// The second message is dispatched while the first waits to be retried.
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"1/1", "2/1", "1/2", "1/3"}
+	if !cmp.Equal(attempts, want) {
+		t.Errorf("%v", cmp.Diff(attempts, want))
+	}
+}
+
+func TestEnqueueUnavailable(t *testing.T) {
+	r := newRecorder()
+	available := func(directive string) bool { return directive == "echo" }
+	queues := newDispatchQueues(r.dispatch, available, 1, 1, "")
+
+	err := queues.Enqueue(yggdrasil.Data{MessageID: "1", Directive: "missing"})
+	var dispatchErr *DispatchError
+	if !errors.As(err, &dispatchErr) || dispatchErr.Code != yggdrasil.ErrorCodeNoSuchWorker {
+		t.Fatalf("%v != %v", err, yggdrasil.ErrorCodeNoSuchWorker)
+	}
+	if _, has := queues.Depths()["missing"]; has {
+		t.Errorf("queue created for unavailable worker")
+	}
+
+	if err := queues.Enqueue(yggdrasil.Data{MessageID: "2", Directive: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, 1)
+}
+
+func TestReapIdleQueue(t *testing.T) {
+	spillDir := t.TempDir()
+	r := newRecorder()
+	close(r.release)
+	queues := newDispatchQueues(r.dispatch, nil, 2, 1, spillDir)
+	queues.idleTimeout = 10 * time.Millisecond
+
+	if err := queues.Enqueue(yggdrasil.Data{MessageID: "1", Directive: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, 1)
+
+	deadline := time.Now().Add(time.Second)
+	for queues.has("echo") {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for idle queue to be removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A new queue is created for later messages.
+	if err := queues.Enqueue(yggdrasil.Data{MessageID: "2", Directive: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t, 1)
+}
+
+// waitActive waits until n messages for directive are being dispatched.
+func waitActive(t *testing.T, queues *dispatchQueues, directive string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		q, err := queues.queue(directive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.mu.Lock()
+		active := q.active
+		q.mu.Unlock()
+		if active == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %v active messages", n)
+}
@@ -0,0 +1,50 @@
+package work
+
+import (
+	"sync"
+)
+
+// maxRecipients is the number of routed messages whose recipients are
+// remembered.
+const maxRecipients = 1000
+
+// recipientLog remembers the workers each routed message was queued for, so
+// that a cancel command for a message reaches the workers that received it.
+// When full, the oldest messages are forgotten.
+type recipientLog struct {
+	mu      sync.Mutex
+	workers map[string][]string
+	order   []string
+}
+
+// add records that the message messageID was queued for worker.
+func (l *recipientLog) add(messageID string, worker string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.workers == nil {
+		l.workers = make(map[string][]string)
+	}
+	workers, has := l.workers[messageID]
+	if !has {
+		if len(l.order) >= maxRecipients {
+			delete(l.workers, l.order[0])
+			l.order = l.order[1:]
+		}
+		l.order = append(l.order, messageID)
+	}
+	for _, w := range workers {
+		if w == worker {
+			return
+		}
+	}
+	l.workers[messageID] = append(workers, worker)
+}
+
+// get returns the workers the message messageID was queued for, or nil if the
+// message is not known.
+func (l *recipientLog) get(messageID string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.workers[messageID]...)
+}
@@ -0,0 +1,159 @@
+package work
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/redhatinsights/yggdrasil"
+	"github.com/redhatinsights/yggdrasil/internal/routing"
+)
+
+func TestRecipientLog(t *testing.T) {
+	var l recipientLog
+
+	l.add("1", "rpm")
+	l.add("1", "audit")
+	l.add("1", "rpm")
+	if got, want := l.get("1"), []string{"rpm", "audit"}; !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+	if got := l.get("2"); len(got) != 0 {
+		t.Errorf("unexpected recipients: %v", got)
+	}
+
+	// The oldest messages are forgotten once the log is full.
+	for i := 0; i < maxRecipients; i++ {
+		l.add(strconv.Itoa(i+2), "echo")
+	}
+	if got := l.get("1"); len(got) != 0 {
+		t.Errorf("unexpected recipients: %v", got)
+	}
+	if got, want := l.get("2"), []string{"echo"}; !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+}
+
+func TestRecipients(t *testing.T) {
+	d := Dispatcher{}
+	d.recipients.add("1", "rpm")
+	d.recipients.add("1", "catchall")
+
+	got, want := d.Recipients("package", "1"), []string{"rpm", "catchall"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+	got, want = d.Recipients("echo", "2"), []string{"echo"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+}
+
+func TestTargets(t *testing.T) {
+	table := loadRoutes(t, `
+fallback = "catchall"
+
+[directive.old_echo]
+workers = ["echo"]
+
+[directive.package]
+workers = ["rpm", "audit"]
+fallback = "package_fallback"
+`)
+
+	tests := []struct {
+		description string
+		router      *routing.Table
+		input       string
+		want        []string
+	}{
+		{
+			description: "no routes",
+			input:       "echo",
+			want:        []string{"echo"},
+		},
+		{
+			description: "alias",
+			router:      table,
+			input:       "old_echo",
+			want:        []string{"old_echo", "echo", "catchall"},
+		},
+		{
+			description: "dashed alias",
+			router:      table,
+			input:       "old-echo",
+			want:        []string{"old_echo", "echo", "catchall"},
+		},
+		{
+			description: "workers and fallback",
+			router:      table,
+			input:       "package",
+			want:        []string{"package", "rpm", "audit", "package_fallback"},
+		},
+		{
+			description: "unrouted directive",
+			router:      table,
+			input:       "echo",
+			want:        []string{"echo", "catchall"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			d := Dispatcher{Router: test.router}
+			got := d.Targets(test.input, nil)
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v", cmp.Diff(got, test.want))
+			}
+		})
+	}
+}
+
+func TestEnqueueRouted(t *testing.T) {
+	dispatched := make(chan string, 1)
+	d := Dispatcher{Router: loadRoutes(t, "[directive.old_echo]\nworkers = [\"echo\"]\n")}
+	d.queues = newDispatchQueues(
+		func(data yggdrasil.Data, attempt int) time.Duration {
+			dispatched <- data.Directive
+			return 0
+		},
+		nil,
+		1,
+		1,
+		"",
+	)
+
+	// A dashed directive is routed by its scrubbed name.
+	err := d.Enqueue(yggdrasil.Data{MessageID: "1234", Directive: "old-echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-dispatched:
+		if got != "echo" {
+			t.Errorf("%v != %v", got, "echo")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for dispatch")
+	}
+	if got, want := d.Recipients("old_echo", "1234"), []string{"echo"}; !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+}
+
+// loadRoutes loads a routing table from a file containing data.
+func loadRoutes(t *testing.T, data string) *routing.Table {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "routes.toml")
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	table, err := routing.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
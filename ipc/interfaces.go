@@ -51,6 +51,21 @@ const (
 	// WorkerEventNameStopped is emitted when worker is stopped,
 	// and it cannot process any message.
 	WorkerEventNameStopped WorkerEventName = 5
+
+	// WorkerEventNameDuplicate is recorded in the message journal when yggd
+	// drops a message it has already received instead of dispatching it to
+	// the worker again. It is never emitted by workers.
+	WorkerEventNameDuplicate WorkerEventName = 6
+
+	// WorkerEventNameDenied is recorded in the message journal when yggd
+	// rejects a message received from the server because it is not permitted
+	// by the authorization policy. It is never emitted by workers.
+	WorkerEventNameDenied WorkerEventName = 7
+
+	// WorkerEventNameExpired is recorded in the message journal when yggd
+	// drops a message received from the server because it expired before it
+	// could be dispatched. It is never emitted by workers.
+	WorkerEventNameExpired WorkerEventName = 8
 )
 
 func (e WorkerEventName) String() string {
@@ -65,6 +80,12 @@ func (e WorkerEventName) String() string {
 		return "STARTED"
 	case WorkerEventNameStopped:
 		return "STOPPED"
+	case WorkerEventNameDuplicate:
+		return "DUPLICATE"
+	case WorkerEventNameDenied:
+		return "DENIED"
+	case WorkerEventNameExpired:
+		return "EXPIRED"
 	}
 	return fmt.Sprintf("UNKNOWN (value: %d)", e)
 }
@@ -14,6 +14,8 @@ const (
 	MessageTypeCommand          MessageType = "command"
 	MessageTypeEvent            MessageType = "event"
 	MessageTypeData             MessageType = "data"
+	MessageTypeResponse         MessageType = "response"
+	MessageTypeDispatchFailed   MessageType = "dispatch-failed"
 )
 
 // ConnectionState represents accepted values for the "state" field of
@@ -59,6 +61,53 @@ const (
 	// EventNamePong informs the server that the client has received a "ping"
 	// command.
 	EventNamePong EventName = "pong"
+
+	// EventNameWorkerEvent informs the server that a worker emitted an event
+	// while handling a message. The event is described by the WorkerEvent
+	// field of the Event message.
+	EventNameWorkerEvent EventName = "worker-event"
+)
+
+// ErrorCode represents accepted values for the "code" field of DispatchFailed
+// messages.
+type ErrorCode string
+
+const (
+	// ErrorCodeNoSuchWorker indicates no worker handles the message's
+	// directive, or the worker could not be started.
+	ErrorCodeNoSuchWorker ErrorCode = "no-such-worker"
+
+	// ErrorCodeContentFetchFailed indicates the detached content of a message
+	// could not be fetched.
+	ErrorCodeContentFetchFailed ErrorCode = "content-fetch-failed"
+
+	// ErrorCodeContentMismatch indicates the detached content of a message
+	// does not have the digest or length given in its metadata.
+	ErrorCodeContentMismatch ErrorCode = "content-mismatch"
+
+	// ErrorCodeUnauthorized indicates the message failed signature
+	// verification or is not permitted by the client's authorization policy.
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+
+	// ErrorCodeMalformed indicates the message could not be parsed or is
+	// missing required values.
+	ErrorCodeMalformed ErrorCode = "malformed"
+
+	// ErrorCodeQueueFull indicates the dispatch queue of the message's
+	// directive is full.
+	ErrorCodeQueueFull ErrorCode = "queue-full"
+
+	// ErrorCodeExpired indicates the message expired before it could be
+	// dispatched.
+	ErrorCodeExpired ErrorCode = "expired"
+
+	// ErrorCodeWorkerFailed indicates the worker returned an error when the
+	// message was delivered to it.
+	ErrorCodeWorkerFailed ErrorCode = "worker-failed"
+
+	// ErrorCodeInternal indicates the client failed to handle the message for
+	// any other reason.
+	ErrorCodeInternal ErrorCode = "internal"
 )
 
 // A ConnectionStatus message is published by the client when it connects to
@@ -77,6 +126,7 @@ type ConnectionStatus struct {
 		State          ConnectionState              `json:"state"`
 		Tags           map[string]string            `json:"tags,omitempty"`
 		ClientVersion  string                       `json:"client_version,omitempty"`
+		ActiveServer   string                       `json:"active_server,omitempty"`
 	} `json:"content"`
 }
 
@@ -96,6 +146,36 @@ type Event struct {
 	Version    int         `json:"version"`
 	Sent       time.Time   `json:"sent"`
 	Content    string      `json:"content"`
+
+	// WorkerEvent describes the event of a "worker-event" Event message.
+	WorkerEvent *WorkerEvent `json:"worker_event,omitempty"`
+}
+
+// WorkerEvent describes an event emitted by a worker while handling a
+// message. Name is one of "BEGIN", "WORKING" or "END" and MessageID is the ID
+// of the message being handled.
+type WorkerEvent struct {
+	Worker     string            `json:"worker"`
+	Name       string            `json:"name"`
+	MessageID  string            `json:"message_id"`
+	ResponseTo string            `json:"response_to"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+// A DispatchFailed message is published by the client on the "control" topic
+// when a message received from the server could not be handled. ResponseTo is
+// the ID of the failed message.
+type DispatchFailed struct {
+	Type       MessageType `json:"type"`
+	MessageID  string      `json:"message_id"`
+	ResponseTo string      `json:"response_to"`
+	Version    int         `json:"version"`
+	Sent       time.Time   `json:"sent"`
+	Content    struct {
+		Code      ErrorCode `json:"code"`
+		Reason    string    `json:"reason"`
+		Directive string    `json:"directive,omitempty"`
+	} `json:"content"`
 }
 
 type Control struct {
@@ -105,6 +185,14 @@ type Control struct {
 	Version    int             `json:"version"`
 	Sent       time.Time       `json:"sent"`
 	Content    json.RawMessage `json:"content"`
+
+	// Signature is an optional base64 encoded detached signature of the
+	// message, verified by the client before the message is processed.
+	Signature string `json:"signature,omitempty"`
+
+	// SigningCertificate is an optional PEM encoded certificate of the key
+	// that made Signature.
+	SigningCertificate string `json:"signing_certificate,omitempty"`
 }
 
 // Data messages are published by both client and server on their respective
@@ -119,6 +207,20 @@ type Data struct {
 	Directive  string            `json:"directive"`
 	Metadata   map[string]string `json:"metadata"`
 	Content    json.RawMessage   `json:"content"`
+
+	// Signature is an optional base64 encoded detached signature of the
+	// message, verified by the client before the message is dispatched. The
+	// signature may instead be carried in the "signature" metadata key.
+	Signature string `json:"signature,omitempty"`
+
+	// SigningCertificate is an optional PEM encoded certificate of the key
+	// that made Signature. The certificate may instead be carried in the
+	// "signing-certificate" metadata key.
+	SigningCertificate string `json:"signing_certificate,omitempty"`
+
+	// TTL is the optional number of seconds after Sent at which the message
+	// expires. Expired messages are not dispatched to workers.
+	TTL int `json:"ttl,omitempty"`
 }
 
 // A WorkerMessage represents the structure of a journal entry in the
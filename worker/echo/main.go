@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -12,7 +13,6 @@ import (
 
 	"git.sr.ht/~spc/go-log"
 
-	"github.com/redhatinsights/yggdrasil/internal/sync"
 	"github.com/redhatinsights/yggdrasil/ipc"
 	"github.com/redhatinsights/yggdrasil/worker"
 )
@@ -20,16 +20,12 @@ import (
 var sleepTime time.Duration
 var loopIt int
 
-// syncMapCancelChan is a map of channels that maps message ID and its current
-// work. This allows for the cancellation of a message that has not finished.
-var syncMapCancelChan sync.RWMutexMap[chan struct{}]
-
-// echo handles the echo message and sets the channel that will manage the
-// cancel message. It runs a loop and a sleep according to the loop and
-// sleep parameters, then calls the echo function to transmit the
-// message. If there is a cancellation message during the loop or the sleep
-// time, it will cancel the transmission of the message and finish the work.
+// echo handles the echo message. It runs a loop and a sleep according to the
+// loop and sleep parameters, then calls the echo function to transmit the
+// message. If the message is cancelled during the loop or the sleep time, it
+// stops transmitting the message and finishes the work.
 func echo(
+	ctx context.Context,
 	w *worker.Worker,
 	addr string,
 	rcvId string,
@@ -46,37 +42,25 @@ func echo(
 		return fmt.Errorf("cannot call EmitEvent: %w", err)
 	}
 
-	// Setting the channel to handle cancellation
-	syncMapCancelChan.Set(rcvId, make(chan struct{}))
-
 	// Loop the echoes
 	for i := 0; i < loopIt; i++ {
 		// Sleep time between receiving the message and sending it
 		if sleepTime > 0 {
 			log.Infof("sleeping: %v", sleepTime)
-			time.Sleep(sleepTime)
 		}
 		// Cancel message if it has been sent a cancel message
 		// during sleep time or during the loop
-		cancelChan, _ := syncMapCancelChan.Get(rcvId)
 		select {
-		case <-cancelChan:
-			// if the channel is close delete the channel from map
-			// it will not be longer used.
-			log.Tracef("canceled echo message id: %v", rcvId)
-			log.Tracef("deleting channel from map")
-			syncMapCancelChan.Del(rcvId)
+		case <-ctx.Done():
+			log.Tracef("canceled echo message id: %v: %v", rcvId, context.Cause(ctx))
 			return nil
-		default:
+		case <-time.After(sleepTime):
 			if err := sendEchoMessage(w, addr, rcvId, responseTo, metadata, data, i); err != nil {
 				return err
 			}
 		}
-
 	}
 
-	log.Tracef("deleting channel from map")
-	syncMapCancelChan.Del(rcvId)
 	return nil
 }
 
@@ -121,19 +105,6 @@ func sendEchoMessage(
 	return nil
 }
 
-// cancelEcho receives a cancel message id via com.redhat.Yggdrasil1.Worker1.Cancel method
-// closes the channel associated with that message to cancel its current run
-func cancelEcho(w *worker.Worker, addr string, id string, cancelID string) error {
-	log.Infof("cancelling message with id %v", cancelID)
-	if cancelChan, exists := syncMapCancelChan.Get(cancelID); exists {
-		close(cancelChan)
-	} else {
-		return fmt.Errorf("message with given id: %v does not exist and cannot be canceled", cancelID)
-	}
-
-	return nil
-}
-
 func events(event ipc.DispatcherEvent) {
 	switch event {
 	case ipc.DispatcherEventReceivedDisconnect:
@@ -143,14 +114,18 @@ func events(event ipc.DispatcherEvent) {
 
 func main() {
 	var (
-		logLevel      string
-		remoteContent bool
+		logLevel       string
+		remoteContent  bool
+		maxConcurrency int
+		queueDepth     int
 	)
 
 	flag.StringVar(&logLevel, "log-level", "error", "set log level")
 	flag.BoolVar(&remoteContent, "remote-content", false, "connect as a remote content worker")
 	flag.DurationVar(&sleepTime, "sleep", 0, "sleep time in seconds before echoing the response")
 	flag.IntVar(&loopIt, "loop", 1, "number of loop echoes before finish echoing.")
+	flag.IntVar(&maxConcurrency, "max-concurrency", 0, "number of messages echoed at once")
+	flag.IntVar(&queueDepth, "queue-depth", 0, "number of messages waiting to be echoed")
 	flag.Parse()
 
 	level, err := log.ParseLevel(logLevel)
@@ -159,13 +134,14 @@ func main() {
 	}
 	log.SetLevel(level)
 
-	w, err := worker.NewWorker(
+	w, err := worker.NewWorkerWithHandler(
 		"echo",
 		remoteContent,
 		map[string]string{"DispatchedAt": "", "Version": "1"},
-		cancelEcho,
 		echo,
 		events,
+		worker.WithMaxConcurrency(maxConcurrency),
+		worker.WithQueueDepth(queueDepth),
 	)
 	if err != nil {
 		log.Fatalf("error: cannot create worker: %v", err)
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/redhatinsights/yggdrasil/ipc"
+	"github.com/redhatinsights/yggdrasil/worker"
+	"github.com/redhatinsights/yggdrasil/worker/workertest"
+)
+
+const timeout = 5 * time.Second
+
+func startEcho(t *testing.T, loop int, sleep time.Duration) *workertest.Bus {
+	t.Helper()
+	loopIt, sleepTime = loop, sleep
+
+	bus := workertest.NewBus(t)
+	w, err := worker.NewWorkerWithHandler(
+		"echo",
+		false,
+		map[string]string{"DispatchedAt": "", "Version": "1"},
+		echo,
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Start(w)
+	return bus
+}
+
+func TestEcho(t *testing.T) {
+	bus := startEcho(t, 2, 0)
+
+	if err := bus.Dispatch("echo", "1234", "", nil, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	got := bus.Transmissions()
+	if len(got) != 2 {
+		t.Fatalf("unexpected transmissions: %v", got)
+	}
+	for _, tr := range got {
+		if tr.ResponseTo != "1234" || string(tr.Data) != "hello" {
+			t.Errorf("unexpected transmission: %v", tr)
+		}
+	}
+}
+
+func TestEchoCancel(t *testing.T) {
+	bus := startEcho(t, 1, time.Hour)
+
+	if err := bus.Dispatch("echo", "1234", "", nil, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.WaitEvent(ipc.WorkerEventNameWorking, "1234", timeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Cancel("echo", "5678", "1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Data[worker.EventDataKeyStatus] != worker.StatusCancelled {
+		t.Errorf("unexpected event data: %v", event.Data)
+	}
+	if got := bus.Transmissions(); len(got) != 0 {
+		t.Errorf("unexpected transmissions: %v", got)
+	}
+}
+
+func TestEchoDisconnect(t *testing.T) {
+	bus := startEcho(t, 1, time.Hour)
+
+	if err := bus.Dispatch("echo", "1234", "", nil, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.WaitEvent(ipc.WorkerEventNameWorking, "1234", timeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.EmitDispatcherEvent(ipc.DispatcherEventReceivedDisconnect); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		worker.EventDataKeyStatus: worker.StatusCancelled,
+		worker.EventDataKeyReason: worker.ErrDisconnected.Error(),
+	}
+	if !cmp.Equal(event.Data, want) {
+		t.Errorf("%v", cmp.Diff(event.Data, want))
+	}
+	if got := bus.Transmissions(); len(got) != 0 {
+		t.Errorf("unexpected transmissions: %v", got)
+	}
+}
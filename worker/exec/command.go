@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/google/uuid"
+
+	"github.com/redhatinsights/yggdrasil/ipc"
+	"github.com/redhatinsights/yggdrasil/worker"
+)
+
+// killDelay is how long a command may take to exit after it is sent SIGTERM
+// before its process group is killed.
+const killDelay = 10 * time.Second
+
+// maxOutputSize is the number of bytes of the standard output and of the
+// standard error of a command that are transmitted when it exits. Output
+// beyond it is discarded.
+const maxOutputSize = 1024 * 1024
+
+// eventInterval is the shortest time between two WORKING events emitted for
+// the output of a command.
+const eventInterval = time.Second
+
+// maxEventSize is the number of bytes of output emitted in a single WORKING
+// event. Lines written while an event is full are dropped from the events.
+const maxEventSize = 64 * 1024
+
+// errTimeout is the cause of a command context that was cancelled because the
+// command ran for longer than its timeout.
+var errTimeout = errors.New("command timed out")
+
+// result is the JSON-encoded data transmitted when a command exits.
+type result struct {
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutTruncated bool   `json:"stdout_truncated,omitempty"`
+	StderrTruncated bool   `json:"stderr_truncated,omitempty"`
+	ExitCode        int    `json:"exit_code"`
+	Error           string `json:"error,omitempty"`
+}
+
+// handler returns a worker.HandlerFunc that runs c for each message.
+func (c command) handler() worker.HandlerFunc {
+	return func(
+		ctx context.Context,
+		w *worker.Worker,
+		addr string,
+		id string,
+		responseTo string,
+		metadata map[string]string,
+		data []byte,
+	) error {
+		return c.run(ctx, w, addr, id, responseTo, metadata, data)
+	}
+}
+
+// run runs the command in a new process group, writing data to its standard
+// input and passing the message ID and metadata as environment variables. The
+// lines the command writes to its standard output are emitted in WORKING
+// events, at most one every eventInterval. When the command exits, its output,
+// up to maxOutputSize bytes of each stream, and exit code are transmitted in
+// reply to the message. If the command does not exit within its timeout, or if
+// the message is cancelled, the process group is sent SIGTERM, and SIGKILL if
+// it has not exited within killDelay. Nothing is transmitted for a cancelled
+// message.
+func (c command) run(
+	ctx context.Context,
+	w *worker.Worker,
+	addr string,
+	id string,
+	responseTo string,
+	metadata map[string]string,
+	data []byte,
+) error {
+	runCtx, cancel := context.WithTimeoutCause(ctx, c.timeout, errTimeout)
+	defer cancel()
+
+	events := eventWriter{
+		emit: func(data map[string]string) {
+			err := w.EmitEvent(ipc.WorkerEventNameWorking, id, responseTo, data)
+			if err != nil {
+				log.Errorf("cannot emit event: %v", err)
+			}
+		},
+	}
+	stdout := cappedBuffer{max: maxOutputSize}
+	stderr := cappedBuffer{max: maxOutputSize}
+
+	cmd := exec.CommandContext(runCtx, c.args[0], c.args[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = io.MultiWriter(&stdout, &events)
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), environ(addr, id, responseTo, metadata)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		log.Infof("stopping command for message %v: %v", id, context.Cause(runCtx))
+		return signalGroup(cmd.Process, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = killDelay
+
+	var res result
+	if err := cmd.Start(); err != nil {
+		log.Errorf("cannot start command for message %v: %v", id, err)
+		res.ExitCode = -1
+		res.Error = err.Error()
+	} else {
+		err := cmd.Wait()
+		// Kill any process the command left running in its group.
+		if err := signalGroup(cmd.Process, syscall.SIGKILL); err != nil &&
+			!errors.Is(err, os.ErrProcessDone) {
+			log.Errorf("cannot kill process group %v: %v", cmd.Process.Pid, err)
+		}
+		events.flush()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		res.Stdout = stdout.buf.String()
+		res.StdoutTruncated = stdout.truncated
+		res.Stderr = stderr.buf.String()
+		res.StderrTruncated = stderr.truncated
+		res.ExitCode = cmd.ProcessState.ExitCode()
+		var exitErr *exec.ExitError
+		switch {
+		case errors.Is(context.Cause(runCtx), errTimeout):
+			res.Error = fmt.Sprintf("%v after %v", errTimeout, c.timeout)
+		case err != nil && !errors.As(err, &exitErr):
+			res.Error = err.Error()
+		}
+	}
+
+	content, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("cannot marshal result: %w", err)
+	}
+
+	_, _, _, err = w.Transmit(
+		addr,
+		uuid.New().String(),
+		id,
+		map[string]string{
+			"content-type": "application/json",
+			"exit-code":    strconv.Itoa(res.ExitCode),
+		},
+		content,
+	)
+	if err != nil {
+		return fmt.Errorf("cannot call Transmit: %w", err)
+	}
+
+	return nil
+}
+
+// environ returns the environment variables describing a message: its
+// directive, ID and the ID it responds to, and each metadata value in a
+// variable named after its key, upper-cased, with characters other than
+// letters and digits replaced with underscores, and prefixed with
+// YGG_METADATA_.
+func environ(addr string, id string, responseTo string, metadata map[string]string) []string {
+	env := []string{
+		"YGG_DIRECTIVE=" + addr,
+		"YGG_MESSAGE_ID=" + id,
+		"YGG_RESPONSE_TO=" + responseTo,
+	}
+	for key, value := range metadata {
+		name := strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z':
+				return r - 'a' + 'A'
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+				return r
+			default:
+				return '_'
+			}
+		}, key)
+		env = append(env, "YGG_METADATA_"+name+"="+value)
+	}
+	return env
+}
+
+// signalGroup sends sig to the process group led by p.
+func signalGroup(p *os.Process, sig syscall.Signal) error {
+	if p == nil {
+		return os.ErrProcessDone
+	}
+	if err := syscall.Kill(-p.Pid, sig); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			return os.ErrProcessDone
+		}
+		return err
+	}
+	return nil
+}
+
+// cappedBuffer collects up to max bytes of the output of a command, discarding
+// the rest.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max - b.buf.Len(); n > room {
+		p = p[:room]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+// eventWriter emits the lines of the output of a command in WORKING events,
+// under the "stdout" key. The lines written within eventInterval of the last
+// event are batched into the next one. Lines that do not fit in an event of
+// maxEventSize bytes are dropped, and their number is emitted under the
+// "dropped" key.
+type eventWriter struct {
+	mu      sync.Mutex
+	line    []byte
+	pending []byte
+	dropped int
+	last    time.Time
+	timer   *time.Timer
+	emit    func(data map[string]string)
+}
+
+func (ew *eventWriter) Write(p []byte) (int, error) {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+
+	ew.line = append(ew.line, p...)
+	for {
+		i := bytes.IndexByte(ew.line, '\n')
+		if i < 0 {
+			break
+		}
+		ew.add(ew.line[:i])
+		ew.line = ew.line[i+1:]
+	}
+	// A line longer than an event is emitted in parts.
+	if len(ew.line) >= maxEventSize {
+		ew.add(ew.line)
+		ew.line = nil
+	}
+
+	if (len(ew.pending) > 0 || ew.dropped > 0) && ew.timer == nil {
+		delay := eventInterval - time.Since(ew.last)
+		if delay <= 0 {
+			ew.emitPending()
+		} else {
+			ew.timer = time.AfterFunc(delay, func() {
+				ew.mu.Lock()
+				defer ew.mu.Unlock()
+				ew.timer = nil
+				ew.emitPending()
+			})
+		}
+	}
+	return len(p), nil
+}
+
+// flush emits the lines that have not been emitted yet, including the last
+// line of output if it does not end with a newline.
+func (ew *eventWriter) flush() {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+
+	if ew.timer != nil {
+		ew.timer.Stop()
+		ew.timer = nil
+	}
+	if len(ew.line) > 0 {
+		ew.add(ew.line)
+		ew.line = nil
+	}
+	ew.emitPending()
+}
+
+// add adds line, cut to maxEventSize bytes, to the next event, or drops it if
+// the event is full. It must be called with ew.mu held.
+func (ew *eventWriter) add(line []byte) {
+	if len(line) > maxEventSize {
+		line = line[:maxEventSize]
+	}
+	size := len(line)
+	if len(ew.pending) > 0 {
+		size++
+	}
+	if len(ew.pending)+size > maxEventSize {
+		ew.dropped++
+		return
+	}
+	if len(ew.pending) > 0 {
+		ew.pending = append(ew.pending, '\n')
+	}
+	ew.pending = append(ew.pending, line...)
+}
+
+// emitPending emits an event with the lines added since the last one. It must
+// be called with ew.mu held.
+func (ew *eventWriter) emitPending() {
+	if len(ew.pending) == 0 && ew.dropped == 0 {
+		return
+	}
+	data := map[string]string{"stdout": string(ew.pending)}
+	if ew.dropped > 0 {
+		data["dropped"] = strconv.Itoa(ew.dropped)
+	}
+	ew.emit(data)
+	ew.pending = nil
+	ew.dropped = 0
+	ew.last = time.Now()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// defaultTimeout is how long a command may run if its directive does not set a
+// timeout.
+const defaultTimeout = 10 * time.Minute
+
+// document is the TOML representation of the exec worker configuration file.
+//
+// An example configuration that runs a script for the "hello" directive,
+// killing it if it runs for more than a minute, and downloads the content of
+// the "install" directive before running its command:
+//
+//	[directive.hello]
+//	command = ["/usr/libexec/hello", "--verbose"]
+//	timeout = "1m"
+//
+//	[directive.install]
+//	command = ["/usr/libexec/install-package"]
+//	remote_content = true
+type document struct {
+	Directive map[string]commandDocument `toml:"directive"`
+}
+
+// commandDocument is the TOML representation of the command run for a single
+// directive.
+type commandDocument struct {
+	Command       []string `toml:"command"`
+	Timeout       string   `toml:"timeout"`
+	RemoteContent bool     `toml:"remote_content"`
+}
+
+// command is an executable run for each message dispatched to a directive.
+type command struct {
+	args          []string
+	timeout       time.Duration
+	remoteContent bool
+}
+
+// readConfig reads a TOML-encoded configuration from its input, returning the
+// command of each directive.
+func readConfig(in io.Reader) (map[string]command, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read input: %w", err)
+	}
+
+	var doc document
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("cannot parse TOML: %w", err)
+	}
+
+	commands := make(map[string]command)
+	for directive, d := range doc.Directive {
+		if len(d.Command) == 0 || d.Command[0] == "" {
+			return nil, fmt.Errorf("missing command for directive '%v'", directive)
+		}
+		c := command{
+			args:          d.Command,
+			timeout:       defaultTimeout,
+			remoteContent: d.RemoteContent,
+		}
+		if d.Timeout != "" {
+			timeout, err := time.ParseDuration(d.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"cannot parse timeout for directive '%v': %w",
+					directive,
+					err,
+				)
+			}
+			if timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout for directive '%v'", directive)
+			}
+			c.timeout = timeout
+		}
+		commands[directive] = c
+	}
+
+	return commands, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		want        map[string]command
+		wantError   bool
+	}{
+		{
+			description: "empty",
+			input:       ``,
+			want:        map[string]command{},
+		},
+		{
+			description: "defaults",
+			input: strings.Join([]string{
+				`[directive.hello]`,
+				`command = ["/usr/libexec/hello", "--verbose"]`,
+			}, "\n"),
+			want: map[string]command{
+				"hello": {
+					args:    []string{"/usr/libexec/hello", "--verbose"},
+					timeout: defaultTimeout,
+				},
+			},
+		},
+		{
+			description: "timeout and remote content",
+			input: strings.Join([]string{
+				`[directive.install]`,
+				`command = ["/usr/libexec/install-package"]`,
+				`timeout = "1m"`,
+				`remote_content = true`,
+			}, "\n"),
+			want: map[string]command{
+				"install": {
+					args:          []string{"/usr/libexec/install-package"},
+					timeout:       time.Minute,
+					remoteContent: true,
+				},
+			},
+		},
+		{
+			description: "missing command",
+			input: strings.Join([]string{
+				`[directive.hello]`,
+				`timeout = "1m"`,
+			}, "\n"),
+			wantError: true,
+		},
+		{
+			description: "invalid timeout",
+			input: strings.Join([]string{
+				`[directive.hello]`,
+				`command = ["/usr/libexec/hello"]`,
+				`timeout = "soon"`,
+			}, "\n"),
+			wantError: true,
+		},
+		{
+			description: "negative timeout",
+			input: strings.Join([]string{
+				`[directive.hello]`,
+				`command = ["/usr/libexec/hello"]`,
+				`timeout = "-1s"`,
+			}, "\n"),
+			wantError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := readConfig(strings.NewReader(test.input))
+			if test.wantError {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got, test.want, cmp.AllowUnexported(command{})) {
+				t.Errorf("%v", cmp.Diff(got, test.want, cmp.AllowUnexported(command{})))
+			}
+		})
+	}
+}
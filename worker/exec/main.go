@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"git.sr.ht/~spc/go-log"
+
+	"github.com/redhatinsights/yggdrasil/internal/constants"
+	"github.com/redhatinsights/yggdrasil/worker"
+)
+
+func main() {
+	var (
+		logLevel       string
+		configFile     string
+		maxConcurrency int
+		queueDepth     int
+	)
+
+	flag.StringVar(&logLevel, "log-level", "error", "set log level")
+	flag.StringVar(
+		&configFile,
+		"config",
+		filepath.Join(constants.ConfigDir, "exec.toml"),
+		"read the commands of each directive from file",
+	)
+	flag.IntVar(&maxConcurrency, "max-concurrency", 0, "commands run at once per directive")
+	flag.IntVar(&queueDepth, "queue-depth", 0, "messages waiting to run per directive")
+	flag.Parse()
+
+	level, err := log.ParseLevel(logLevel)
+	if err != nil {
+		log.Fatalf("error: cannot parse log level: %v", err)
+	}
+	log.SetLevel(level)
+
+	f, err := os.Open(configFile)
+	if err != nil {
+		log.Fatalf("error: cannot open config file: %v", err)
+	}
+	commands, err := readConfig(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("error: cannot read config file %v: %v", configFile, err)
+	}
+
+	directives := make([]string, 0, len(commands))
+	for directive := range commands {
+		directives = append(directives, directive)
+	}
+	sort.Strings(directives)
+
+	var workers []*worker.Worker
+	for _, directive := range directives {
+		c := commands[directive]
+		w, err := worker.NewWorkerWithHandler(
+			directive,
+			c.remoteContent,
+			map[string]string{"Version": "1"},
+			c.handler(),
+			nil,
+			worker.WithMaxConcurrency(maxConcurrency),
+			worker.WithQueueDepth(queueDepth),
+		)
+		if err != nil {
+			log.Fatalf("error: cannot create worker: %v", err)
+		}
+		workers = append(workers, w)
+	}
+
+	host, err := worker.NewHost(workers...)
+	if err != nil {
+		log.Fatalf("error: cannot create host: %v", err)
+	}
+
+	// Set up a channel to receive the TERM or INT signal over and clean up
+	// before quitting.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+
+	if err := host.Connect(quit); err != nil {
+		log.Fatalf("error: cannot connect: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/redhatinsights/yggdrasil/ipc"
+	"github.com/redhatinsights/yggdrasil/worker"
+	"github.com/redhatinsights/yggdrasil/worker/workertest"
+)
+
+const timeout = 5 * time.Second
+
+func startCommand(t *testing.T, c command) *workertest.Bus {
+	t.Helper()
+
+	bus := workertest.NewBus(t)
+	w, err := worker.NewWorkerWithHandler("exec", false, map[string]string{}, c.handler(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Start(w)
+	return bus
+}
+
+func TestRun(t *testing.T) {
+	script := `cat; echo "$YGG_MESSAGE_ID $YGG_METADATA_JOB_ID"; echo oops >&2; exit 3`
+	bus := startCommand(t, command{
+		args:    []string{"/bin/sh", "-c", script},
+		timeout: timeout,
+	})
+
+	metadata := map[string]string{"job-id": "42"}
+	if err := bus.Dispatch("exec", "1234", "", metadata, []byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, e := range bus.Events() {
+		if e.Name == ipc.WorkerEventNameWorking && e.MessageID == "1234" {
+			lines = append(lines, strings.Split(e.Data["stdout"], "\n")...)
+		}
+	}
+	if want := []string{"hello", "1234 42"}; !cmp.Equal(lines, want) {
+		t.Errorf("%v", cmp.Diff(lines, want))
+	}
+
+	got := bus.Transmissions()
+	if len(got) != 1 {
+		t.Fatalf("unexpected transmissions: %v", got)
+	}
+	if got[0].ResponseTo != "1234" || got[0].Metadata["exit-code"] != "3" {
+		t.Errorf("unexpected transmission: %v", got[0])
+	}
+	var res result
+	if err := json.Unmarshal(got[0].Data, &res); err != nil {
+		t.Fatal(err)
+	}
+	want := result{Stdout: "hello\n1234 42\n", Stderr: "oops\n", ExitCode: 3}
+	if !cmp.Equal(res, want) {
+		t.Errorf("%v", cmp.Diff(res, want))
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	bus := startCommand(t, command{
+		args:    []string{"/bin/sh", "-c", "sleep 60 & wait"},
+		timeout: 100 * time.Millisecond,
+	})
+
+	if err := bus.Dispatch("exec", "1234", "", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := bus.WaitTransmissions(1, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res result
+	if err := json.Unmarshal(got[0].Data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != -1 || res.Error == "" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	bus := startCommand(t, command{
+		args:    []string{"/bin/sh", "-c", "echo started; sleep 60"},
+		timeout: time.Hour,
+	})
+
+	if err := bus.Dispatch("exec", "1234", "", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.WaitEvent(ipc.WorkerEventNameWorking, "1234", timeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Cancel("exec", "5678", "1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Data[worker.EventDataKeyStatus] != worker.StatusCancelled {
+		t.Errorf("unexpected event data: %v", event.Data)
+	}
+	if got := bus.Transmissions(); len(got) != 0 {
+		t.Errorf("unexpected transmissions: %v", got)
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	got := environ("exec", "1234", "5678", map[string]string{"Content-Type": "text/plain"})
+	want := []string{
+		"YGG_DIRECTIVE=exec",
+		"YGG_MESSAGE_ID=1234",
+		"YGG_RESPONSE_TO=5678",
+		"YGG_METADATA_CONTENT_TYPE=text/plain",
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+}
+
+func TestRunOutputTruncated(t *testing.T) {
+	script := fmt.Sprintf("head -c %v /dev/zero | tr '\\0' x; echo", maxOutputSize+10)
+	bus := startCommand(t, command{
+		args:    []string{"/bin/sh", "-c", script},
+		timeout: timeout,
+	})
+
+	if err := bus.Dispatch("exec", "1234", "", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := bus.WaitTransmissions(1, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res result
+	if err := json.Unmarshal(got[0].Data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Stdout) != maxOutputSize || !res.StdoutTruncated || res.StderrTruncated {
+		t.Errorf("unexpected result: %v bytes, %+v", len(res.Stdout), res.StdoutTruncated)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	tests := []struct {
+		description   string
+		input         []string
+		want          string
+		wantTruncated bool
+	}{
+		{
+			description: "under limit",
+			input:       []string{"ab", "cd"},
+			want:        "abcd",
+		},
+		{
+			description: "at limit",
+			input:       []string{"abcde"},
+			want:        "abcde",
+		},
+		{
+			description:   "over limit",
+			input:         []string{"abc", "def", "ghi"},
+			want:          "abcde",
+			wantTruncated: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			b := cappedBuffer{max: 5}
+			for _, p := range test.input {
+				n, err := b.Write([]byte(p))
+				if err != nil || n != len(p) {
+					t.Fatalf("Write(%q) = %v, %v", p, n, err)
+				}
+			}
+			if got := b.buf.String(); got != test.want {
+				t.Errorf("%q != %q", got, test.want)
+			}
+			if b.truncated != test.wantTruncated {
+				t.Errorf("%v != %v", b.truncated, test.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestEventWriter(t *testing.T) {
+	var got []map[string]string
+	ew := eventWriter{
+		emit: func(data map[string]string) {
+			got = append(got, data)
+		},
+	}
+
+	// The first line is emitted at once, and the lines written within
+	// eventInterval of it are batched until the writer is flushed.
+	for _, p := range []string{"one\n", "two\nthr", "ee\n", "four"} {
+		if _, err := ew.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ew.flush()
+
+	want := []map[string]string{
+		{"stdout": "one"},
+		{"stdout": "two\nthree\nfour"},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v", cmp.Diff(got, want))
+	}
+}
+
+func TestEventWriterDropped(t *testing.T) {
+	var got []map[string]string
+	ew := eventWriter{
+		last: time.Now(),
+		emit: func(data map[string]string) {
+			got = append(got, data)
+		},
+	}
+
+	// Four lines fill an event, with the newlines between them, and the fifth
+	// is dropped.
+	line := strings.Repeat("x", maxEventSize/4-1) + "\n"
+	for i := 0; i < 5; i++ {
+		if _, err := ew.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ew.flush()
+
+	if len(got) != 1 {
+		t.Fatalf("unexpected events: %v", len(got))
+	}
+	if n := strings.Count(got[0]["stdout"], "x"); n != 4*(maxEventSize/4-1) {
+		t.Errorf("event holds %v bytes of output", n)
+	}
+	if got[0]["dropped"] != "1" {
+		t.Errorf("dropped = %v", got[0]["dropped"])
+	}
+}
@@ -0,0 +1,149 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/godbus/dbus/v5"
+)
+
+// Host exports several workers onto a single bus connection, so that one
+// process can handle the messages of several directives. Each worker keeps its
+// own handler, features and RemoteContent flag, and owns its own well-known
+// bus name and object path. The TransmitCompleted signal for a message sent
+// with TransmitAsync is delivered only to the worker that sent it.
+type Host struct {
+	workers []*Worker
+
+	// senders holds the worker that sent each message with TransmitAsync
+	// whose TransmitCompleted signal has not been received, by message ID.
+	mu      sync.Mutex
+	senders map[string]*Worker
+}
+
+// NewHost creates a host for workers. Each worker must handle a different
+// directive.
+func NewHost(workers ...*Worker) (*Host, error) {
+	if len(workers) == 0 {
+		return nil, fmt.Errorf("no workers")
+	}
+
+	directives := make(map[string]bool)
+	for _, w := range workers {
+		if directives[w.directive] {
+			return nil, fmt.Errorf("duplicate directive '%v'", w.directive)
+		}
+		directives[w.directive] = true
+	}
+
+	h := &Host{workers: workers, senders: make(map[string]*Worker)}
+	for _, w := range workers {
+		w.host = h
+	}
+	return h, nil
+}
+
+// Directives returns the directives of the workers of the host.
+func (h *Host) Directives() []string {
+	directives := make([]string, 0, len(h.workers))
+	for _, w := range h.workers {
+		directives = append(directives, w.directive)
+	}
+	return directives
+}
+
+// Connect connects to the bus the same way as Worker.Connect and exports each
+// worker of the host onto it, requesting their well-known bus names. It waits
+// until a signal is received on quit, then stops every worker. If a worker
+// cannot be exported, the workers exported before it are unexported and the
+// connection is closed.
+func (h *Host) Connect(quit <-chan os.Signal) error {
+	conn, err := connectBus()
+	if err != nil {
+		return err
+	}
+
+	for i, w := range h.workers {
+		if err := w.export(conn); err != nil {
+			abort(conn, h.workers[:i])
+			return fmt.Errorf("cannot export worker '%v': %w", w.directive, err)
+		}
+	}
+
+	if err := watchDispatcher(conn, h.handleSignal); err != nil {
+		abort(conn, h.workers)
+		return err
+	}
+
+	<-quit
+
+	// The workers drain their messages at the same time, so that stopping
+	// takes no longer than the longest drain timeout.
+	errs := make([]error, len(h.workers))
+	var wg sync.WaitGroup
+	for i, w := range h.workers {
+		wg.Add(1)
+		go func(i int, w *Worker) {
+			defer wg.Done()
+			errs[i] = w.shutdown()
+		}(i, w)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// handleSignal delivers a com.redhat.Yggdrasil1.Dispatcher1 signal to the
+// workers of the host. A TransmitCompleted signal is only delivered to the
+// worker that sent the message.
+func (h *Host) handleSignal(s *dbus.Signal) {
+	if s.Name != "com.redhat.Yggdrasil1.Dispatcher1.TransmitCompleted" {
+		for _, w := range h.workers {
+			w.handleSignal(s)
+		}
+		return
+	}
+
+	var id string
+	if len(s.Body) > 0 {
+		id, _ = s.Body[0].(string)
+	}
+	h.mu.Lock()
+	w, has := h.senders[id]
+	delete(h.senders, id)
+	h.mu.Unlock()
+	if !has {
+		log.Debugf("ignoring TransmitCompleted signal for unknown message %v", id)
+		return
+	}
+	w.handleSignal(s)
+}
+
+// addSender records that w is sending the message id with TransmitAsync.
+func (h *Host) addSender(id string, w *Worker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.senders[id] = w
+}
+
+// removeSender forgets the sender of the message id.
+func (h *Host) removeSender(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.senders, id)
+}
+
+// abort unexports workers, which were exported onto conn, and closes conn.
+func abort(conn *dbus.Conn, workers []*Worker) {
+	for _, w := range workers {
+		if err := w.unexport(); err != nil {
+			log.Errorf("cannot unexport worker '%v': %v", w.directive, err)
+		}
+	}
+	if err := conn.Close(); err != nil {
+		log.Errorf("cannot close connection: %v", err)
+	}
+}
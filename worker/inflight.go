@@ -0,0 +1,224 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/godbus/dbus/v5"
+	"github.com/redhatinsights/yggdrasil/ipc"
+)
+
+// accept tracks the message with id until it is done, returning its context.
+// If the worker is stopping, is already handling a message with id, or has as
+// many messages running and queued as it is allowed, the message is rejected.
+// Otherwise the BEGIN event is emitted.
+func (w *Worker) accept(id string, responseTo string) (context.Context, *dbus.Error) {
+	w.mu.Lock()
+	switch {
+	case w.stopping:
+		w.mu.Unlock()
+		return nil, dbus.NewError(
+			"com.redhat.Yggdrasil1.Worker1.Busy",
+			[]interface{}{"worker is stopping"},
+		)
+	case w.maxConcurrency > 0 && w.running+w.queued >= w.maxConcurrency+w.queueDepth:
+		n := w.running + w.queued
+		w.mu.Unlock()
+		return nil, dbus.NewError(
+			"com.redhat.Yggdrasil1.Worker1.Busy",
+			[]interface{}{fmt.Sprintf("worker is handling %v messages", n)},
+		)
+	}
+	if _, has := w.inFlight[id]; has {
+		w.mu.Unlock()
+		return nil, dbus.MakeFailedError(fmt.Errorf("message %v is already in flight", id))
+	}
+	ctx, cancel := context.WithCancelCause(w.ctx)
+	w.inFlight[id] = cancel
+	w.queued++
+	w.handlers.Add(1)
+	w.publishLoad()
+	w.mu.Unlock()
+
+	err := w.EmitEvent(ipc.WorkerEventNameBegin, id, responseTo, map[string]string{})
+	if err != nil {
+		w.mu.Lock()
+		w.queued--
+		w.publishLoad()
+		w.mu.Unlock()
+		w.done(id)
+		return nil, dbus.NewError(
+			"com.redhat.Yggdrasil1.Worker1.EventError",
+			[]interface{}{err.Error()},
+		)
+	}
+
+	return ctx, nil
+}
+
+// start calls f in a goroutine with the context of the message with id once
+// the worker is handling fewer messages than its maximum concurrency. F returns
+// true if it stopped handling the message because the context was cancelled.
+// When f returns, or if the context is cancelled before f is called, the END
+// event is emitted, including the status and reason of the cancellation if the
+// message was cancelled.
+func (w *Worker) start(
+	ctx context.Context,
+	id string,
+	responseTo string,
+	f func(context.Context) bool,
+) {
+	go func() {
+		cancelled := true
+		if w.acquire(ctx) {
+			cancelled = f(ctx)
+			w.release()
+		}
+
+		eventData := map[string]string{}
+		if cause := context.Cause(ctx); cancelled && cause != nil {
+			eventData[EventDataKeyStatus] = StatusCancelled
+			eventData[EventDataKeyReason] = cause.Error()
+		}
+		w.done(id)
+
+		if err := w.EmitEvent(ipc.WorkerEventNameEnd, id, responseTo, eventData); err != nil {
+			log.Errorf("cannot emit event: %v", err)
+		}
+	}()
+}
+
+// acquire waits until the worker is handling fewer messages than its maximum
+// concurrency and moves a message from the queue to the running messages. It
+// returns false if ctx is cancelled first.
+func (w *Worker) acquire(ctx context.Context) bool {
+	if w.slots != nil {
+		select {
+		case w.slots <- struct{}{}:
+		case <-ctx.Done():
+			w.mu.Lock()
+			w.queued--
+			w.publishLoad()
+			w.mu.Unlock()
+			return false
+		}
+	}
+
+	w.mu.Lock()
+	w.queued--
+	w.running++
+	w.publishLoad()
+	w.mu.Unlock()
+	return true
+}
+
+// release removes a message from the running messages.
+func (w *Worker) release() {
+	w.mu.Lock()
+	w.running--
+	w.publishLoad()
+	w.mu.Unlock()
+
+	if w.slots != nil {
+		<-w.slots
+	}
+}
+
+// done stops tracking the message with id.
+func (w *Worker) done(id string) {
+	w.mu.Lock()
+	cancel := w.inFlight[id]
+	delete(w.inFlight, id)
+	w.mu.Unlock()
+
+	cancel(nil)
+	w.handlers.Done()
+}
+
+// publishLoad sets the Running and Queued properties to the number of messages
+// being handled and waiting to be handled. It must be called with w.mu held.
+func (w *Worker) publishLoad() {
+	if w.props == nil {
+		return
+	}
+	w.props.SetMust("com.redhat.Yggdrasil1.Worker1", "Running", uint32(w.running))
+	w.props.SetMust("com.redhat.Yggdrasil1.Worker1", "Queued", uint32(w.queued))
+}
+
+// cancelInFlight is the CancelRxFunc of workers created with
+// NewWorkerWithHandler. It cancels the handler context of the message with
+// cancelID.
+func cancelInFlight(w *Worker, addr string, id string, cancelID string) error {
+	w.mu.Lock()
+	cancel, has := w.inFlight[cancelID]
+	w.mu.Unlock()
+	if !has {
+		return fmt.Errorf("message %v is not in flight and cannot be cancelled", cancelID)
+	}
+	log.Infof("cancelling message %v", cancelID)
+	cancel(ErrCancelled)
+	return nil
+}
+
+// cancelAll cancels the contexts of every message in flight with the given
+// cause, returning their IDs.
+func (w *Worker) cancelAll(cause error) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ids := make([]string, 0, len(w.inFlight))
+	for id, cancel := range w.inFlight {
+		log.Infof("cancelling message %v: %v", id, cause)
+		cancel(cause)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// drain stops the worker from accepting messages and waits for the messages it
+// has accepted to be handled. Messages that are not handled within the drain
+// timeout are cancelled: the contexts of HandlerFunc workers are cancelled,
+// and the CancelRxFunc of RxFunc workers is called for each message.
+func (w *Worker) drain() {
+	w.mu.Lock()
+	w.stopping = true
+	n := len(w.inFlight)
+	w.mu.Unlock()
+
+	if n > 0 {
+		log.Infof("waiting up to %v for %v messages", w.drainTimeout, n)
+	}
+	if w.waitHandlers(w.drainTimeout) {
+		return
+	}
+
+	ids := w.cancelAll(ErrStopped)
+	w.stop(ErrStopped)
+	if w.handler == nil && w.cancelRx != nil {
+		for _, id := range ids {
+			if err := w.cancelRx(w, w.directive, "", id); err != nil {
+				log.Errorf("cannot cancel message %v: %v", id, err)
+			}
+		}
+	}
+	w.waitHandlers(stopTimeout)
+}
+
+// waitHandlers waits until every message in flight is done, or until timeout
+// has elapsed. It returns false if the timeout elapsed.
+func (w *Worker) waitHandlers(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		w.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		log.Warnf("messages in flight were not done within %v", timeout)
+		return false
+	}
+}
@@ -0,0 +1,37 @@
+package worker
+
+import "time"
+
+// DefaultDrainTimeout is how long a stopping worker waits for the messages it
+// has accepted to be handled, unless set with WithDrainTimeout.
+const DefaultDrainTimeout = 30 * time.Second
+
+// Option configures a Worker created with NewWorker or NewWorkerWithHandler.
+type Option func(w *Worker)
+
+// WithMaxConcurrency limits the number of messages the worker handles at once.
+// Messages received while the limit is reached wait in a queue. A value of 0
+// does not limit the number of messages.
+func WithMaxConcurrency(n int) Option {
+	return func(w *Worker) {
+		w.maxConcurrency = n
+	}
+}
+
+// WithQueueDepth sets the number of messages that wait to be handled while the
+// maximum concurrency is reached. Messages received while the queue is full are
+// rejected with the com.redhat.Yggdrasil1.Worker1.Busy error. It has no effect
+// unless the maximum concurrency is set.
+func WithQueueDepth(n int) Option {
+	return func(w *Worker) {
+		w.queueDepth = n
+	}
+}
+
+// WithDrainTimeout sets how long a stopping worker waits for the messages it
+// has accepted to be handled before cancelling them.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(w *Worker) {
+		w.drainTimeout = d
+	}
+}
@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// metadataKeyContentLength is the metadata key of the length in bytes of the
+// content of a message.
+const metadataKeyContentLength = "content-length"
+
+// lengthReader reads content that must be exactly length bytes long. It
+// returns io.ErrUnexpectedEOF if the content ends early and an error if it is
+// longer, so that truncated content is never mistaken for complete content.
+type lengthReader struct {
+	r      io.Reader
+	length int64
+	n      int64
+}
+
+// newContentReader returns a reader for the content of a message read from r.
+// If metadata includes the content-length key, the reader returns an error
+// when the content does not have that length.
+func newContentReader(r io.Reader, metadata map[string]string) (io.Reader, error) {
+	value, has := metadata[metadataKeyContentLength]
+	if !has {
+		return r, nil
+	}
+	length, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid %v: %v", metadataKeyContentLength, value)
+	}
+	return &lengthReader{r: r, length: length}, nil
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.length {
+		return n, fmt.Errorf(
+			"content is longer than %v of %v bytes",
+			metadataKeyContentLength,
+			l.length,
+		)
+	}
+	if err == io.EOF && l.n < l.length {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
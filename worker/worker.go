@@ -1,10 +1,15 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"regexp"
+	"sync"
+	"time"
 
 	"git.sr.ht/~spc/go-log"
 	"github.com/godbus/dbus/v5"
@@ -13,9 +18,71 @@ import (
 	"github.com/redhatinsights/yggdrasil/ipc"
 )
 
+const (
+	// EventDataKeyStatus is the key of the END event data that holds the
+	// status of a message, if it was not handled to completion.
+	EventDataKeyStatus = "status"
+
+	// EventDataKeyReason is the key of the END event data that holds the
+	// reason a message was cancelled.
+	EventDataKeyReason = "reason"
+
+	// StatusCancelled is the status of a message that was cancelled before it
+	// was handled to completion.
+	StatusCancelled = "cancelled"
+)
+
+var (
+	// ErrCancelled is the cause of a handler context that was cancelled by
+	// the com.redhat.Yggdrasil1.Worker1.Cancel method.
+	ErrCancelled = errors.New("message cancelled")
+
+	// ErrDisconnected is the cause of a handler context that was cancelled
+	// because the dispatcher received the "disconnect" command.
+	ErrDisconnected = errors.New("dispatcher disconnected")
+
+	// ErrStopped is the cause of a handler context that was cancelled because
+	// the worker stopped before the message was handled.
+	ErrStopped = errors.New("worker stopped")
+)
+
+// stopTimeout is how long a stopping worker waits for the handlers of messages
+// in flight to return after their contexts are cancelled.
+const stopTimeout = 5 * time.Second
+
 // RxFunc is a function type that gets called each time the worker receives data.
 type RxFunc func(w *Worker, addr string, id string, responseTo string, metadata map[string]string, data []byte) error
 
+// HandlerFunc is a function type that gets called each time the worker
+// receives data. Ctx is cancelled when the message is cancelled with the
+// com.redhat.Yggdrasil1.Worker1.Cancel method, when the dispatcher emits the
+// RECEIVED_DISCONNECT event, or when the worker stops. The cause of the
+// cancellation is one of ErrCancelled, ErrDisconnected or ErrStopped.
+type HandlerFunc func(
+	ctx context.Context,
+	w *Worker,
+	addr string,
+	id string,
+	responseTo string,
+	metadata map[string]string,
+	data []byte,
+) error
+
+// StreamRxFunc is a function type that gets called each time the worker
+// receives data through the DispatchFD method. The message content is read from
+// content, which is closed once the function returns. The dispatcher verifies
+// the content before it is dispatched, so reaching io.EOF means all of it has
+// been read. If metadata includes the content-length key, reading content that
+// is shorter returns io.ErrUnexpectedEOF instead.
+type StreamRxFunc func(
+	w *Worker,
+	addr string,
+	id string,
+	responseTo string,
+	metadata map[string]string,
+	content io.Reader,
+) error
+
 // CancelRxFunc is a function type that gets called each time the worker receives
 // a cancel message
 type CancelRxFunc func(w *Worker, addr string, id string, cancelID string) error
@@ -24,20 +91,62 @@ type CancelRxFunc func(w *Worker, addr string, id string, cancelID string) error
 // receives a com.redhat.Yggdrasil1.Dispatcher1.Event signal.
 type EventHandlerFunc func(e ipc.DispatcherEvent)
 
+// TransmitCompletedFunc is a function type that gets called each time the
+// worker receives a com.redhat.Yggdrasil1.Dispatcher1.TransmitCompleted signal
+// for a message it sent with TransmitAsync.
+type TransmitCompletedFunc func(
+	w *Worker,
+	id string,
+	responseCode int,
+	responseMetadata map[string]string,
+	responseData []byte,
+)
+
 // Worker implements the com.redhat.Yggdrasil1.Worker1 interface.
 type Worker struct {
 	directive     string
 	features      map[string]string
 	remoteContent bool
 	rx            RxFunc
+	handler       HandlerFunc
+	streamRx      StreamRxFunc
 	cancelRx      CancelRxFunc
 	conn          *dbus.Conn
 	objectPath    dbus.ObjectPath
 	busName       string
 	eventHandler  EventHandlerFunc
+
+	transmitCompleted TransmitCompletedFunc
+
+	// host is the Host the worker is exported by, if any.
+	host *Host
+
+	// ctx is the parent of the handler contexts, cancelled when the worker
+	// stops before they are done.
+	ctx          context.Context
+	stop         context.CancelCauseFunc
+	drainTimeout time.Duration
+
+	// maxConcurrency and queueDepth limit the messages handled at once and
+	// waiting to be handled. Slots holds a value for each message being
+	// handled if maxConcurrency is set.
+	maxConcurrency int
+	queueDepth     int
+	slots          chan struct{}
+
+	// inFlight holds the cancel functions of the contexts of the messages
+	// accepted and not yet done, by message ID.
+	mu       sync.Mutex
+	inFlight map[string]context.CancelCauseFunc
+	running  int
+	queued   int
+	stopping bool
+	handlers sync.WaitGroup
+	props    *prop.Properties
 }
 
-// NewWorker creates a new worker.
+// NewWorker creates a new worker. Options limit the number of messages the
+// worker handles at once and set how long it waits for them when it stops.
 func NewWorker(
 	directive string,
 	remoteContent bool,
@@ -45,6 +154,7 @@ func NewWorker(
 	cancel CancelRxFunc,
 	rx RxFunc,
 	events EventHandlerFunc,
+	options ...Option,
 ) (*Worker, error) {
 	r := regexp.MustCompile("-")
 	if r.Match([]byte(directive)) {
@@ -60,29 +170,93 @@ func NewWorker(
 		objectPath:    dbus.ObjectPath(path.Join("/com/redhat/Yggdrasil1/Worker1", directive)),
 		busName:       fmt.Sprintf("com.redhat.Yggdrasil1.Worker1.%v", directive),
 		eventHandler:  events,
+		inFlight:      make(map[string]context.CancelCauseFunc),
+		drainTimeout:  DefaultDrainTimeout,
+	}
+	for _, option := range options {
+		option(&w)
 	}
+	if w.maxConcurrency > 0 {
+		w.slots = make(chan struct{}, w.maxConcurrency)
+	}
+
+	w.ctx, w.stop = context.WithCancelCause(context.Background())
 
 	return &w, nil
 }
 
+// NewWorkerWithHandler creates a new worker that calls handler with a context
+// for each message it receives. The worker keeps track of the messages being
+// handled, cancelling their contexts when they are cancelled, when the
+// dispatcher disconnects or when the worker stops before they are done. The
+// END event of a message whose handler returned after its context was
+// cancelled includes the "cancelled" status.
+func NewWorkerWithHandler(
+	directive string,
+	remoteContent bool,
+	features map[string]string,
+	handler HandlerFunc,
+	events EventHandlerFunc,
+	options ...Option,
+) (*Worker, error) {
+	w, err := NewWorker(directive, remoteContent, features, cancelInFlight, nil, events, options...)
+	if err != nil {
+		return nil, err
+	}
+	w.handler = handler
+
+	return w, nil
+}
+
 // Connect connects to the bus, exports the worker on its object path, and
 // requests a well-known bus name. It connects to a private session bus, if
 // DBUS_SESSION_BUS_ADDRESS is set in the environment. Otherwise it connects to
 // the system bus. It exports w onto the bus and waits until a signal is
 // received on quit.
 func (w *Worker) Connect(quit <-chan os.Signal) error {
+	conn, err := connectBus()
+	if err != nil {
+		return err
+	}
+
+	if err := w.export(conn); err != nil {
+		abort(conn, nil)
+		return err
+	}
+
+	if err := watchDispatcher(conn, w.handleSignal); err != nil {
+		abort(conn, []*Worker{w})
+		return err
+	}
+
+	<-quit
+
+	return w.shutdown()
+}
+
+// connectBus connects to a private session bus, if DBUS_SESSION_BUS_ADDRESS is
+// set in the environment. Otherwise it connects to the system bus.
+func connectBus() (*dbus.Conn, error) {
+	var conn *dbus.Conn
 	var err error
 
 	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
 		log.Debugf("connecting to session bus: %v", os.Getenv("DBUS_SESSION_BUS_ADDRESS"))
-		w.conn, err = dbus.ConnectSessionBus()
+		conn, err = dbus.ConnectSessionBus()
 	} else {
 		log.Debugf("connecting to system bus")
-		w.conn, err = dbus.ConnectSystemBus()
+		conn, err = dbus.ConnectSystemBus()
 	}
 	if err != nil {
-		return fmt.Errorf("error: cannot connect to bus: %w", err)
+		return nil, fmt.Errorf("error: cannot connect to bus: %w", err)
 	}
+	return conn, nil
+}
+
+// export exports the worker onto conn on its object path, requests its
+// well-known bus name and emits the STARTED event.
+func (w *Worker) export(conn *dbus.Conn) error {
+	w.conn = conn
 
 	// Export properties onto the bus as an org.freedesktop.DBus.Properties
 	// interface.
@@ -98,18 +272,41 @@ func (w *Worker) Connect(quit <-chan os.Signal) error {
 				Writable: false,
 				Emit:     prop.EmitTrue,
 			},
+			"StreamContent": {
+				Value:    w.streamRx != nil,
+				Writable: false,
+				Emit:     prop.EmitTrue,
+			},
+			"Running": {
+				Value:    uint32(0),
+				Writable: false,
+				Emit:     prop.EmitTrue,
+			},
+			"Queued": {
+				Value:    uint32(0),
+				Writable: false,
+				Emit:     prop.EmitTrue,
+			},
 		},
 	}
 
-	_, err = prop.Export(w.conn, w.objectPath, propertySpec)
+	props, err := prop.Export(w.conn, w.objectPath, propertySpec)
 	if err != nil {
 		return fmt.Errorf("cannot export com.redhat.Yggdrasil1.Worker1 properties: %w", err)
 	}
+	w.mu.Lock()
+	w.props = props
+	w.mu.Unlock()
 
 	// Export worker onto the bus, implementing the com.redhat.Yggdrasil1.Worker1
 	// and org.freedesktop.DBus.Introspectable interfaces. The path name the
 	// worker exports includes the directive name.
-	if err := w.conn.ExportMethodTable(map[string]interface{}{"Dispatch": w.dispatch, "Cancel": w.cancel}, w.objectPath, "com.redhat.Yggdrasil1.Worker1"); err != nil {
+	methods := map[string]interface{}{
+		"Dispatch":   w.dispatch,
+		"DispatchFD": w.dispatchFD,
+		"Cancel":     w.cancel,
+	}
+	if err := w.conn.ExportMethodTable(methods, w.objectPath, "com.redhat.Yggdrasil1.Worker1"); err != nil {
 		return fmt.Errorf("cannot export com.redhat.Yggdrasil1.Worker1 interface: %w", err)
 	}
 
@@ -137,25 +334,39 @@ func (w *Worker) Connect(quit <-chan os.Signal) error {
 		return fmt.Errorf("cannot emit event: %w", err)
 	}
 
-	signals := make(chan *dbus.Signal)
-	w.conn.Signal(signals)
-	go func() {
-		for s := range signals {
-			switch s.Name {
-			case "com.redhat.Yggdrasil1.Dispatcher1.Event":
-				event := s.Body[0].(uint)
-				if w.eventHandler == nil {
-					continue
-				}
-				w.eventHandler(ipc.DispatcherEvent(event))
-			}
+	return nil
+}
+
+// unexport emits the STOPPED event, releases the well-known bus name of the
+// worker and removes the interfaces it exported on its object path.
+func (w *Worker) unexport() error {
+	var errs []error
+	err := w.EmitEvent(ipc.WorkerEventNameStopped, "", "", map[string]string{})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("cannot emit event: %w", err))
+	}
+	if _, err := w.conn.ReleaseName(w.busName); err != nil {
+		errs = append(errs, fmt.Errorf("cannot release name %s on bus: %w", w.busName, err))
+	}
+	for _, iface := range []string{
+		"com.redhat.Yggdrasil1.Worker1",
+		"org.freedesktop.DBus.Introspectable",
+		"org.freedesktop.DBus.Properties",
+	} {
+		if err := w.conn.Export(nil, w.objectPath, iface); err != nil {
+			errs = append(errs, fmt.Errorf("cannot unexport %v interface: %w", iface, err))
 		}
-	}()
+	}
+	return errors.Join(errs...)
+}
 
-	<-quit
+// shutdown drains the messages the worker has accepted and emits the STOPPED
+// event.
+func (w *Worker) shutdown() error {
+	w.drain()
 
 	// Emit a stopped event
-	err = w.EmitEvent(
+	err := w.EmitEvent(
 		ipc.WorkerEventNameStopped,
 		"",
 		"",
@@ -168,6 +379,64 @@ func (w *Worker) Connect(quit <-chan os.Signal) error {
 	return nil
 }
 
+// watchDispatcher subscribes to the com.redhat.Yggdrasil1.Dispatcher1 signals
+// on conn and passes each of them to handle.
+func watchDispatcher(conn *dbus.Conn, handle func(s *dbus.Signal)) error {
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchObjectPath("/com/redhat/Yggdrasil1/Dispatcher1"),
+		dbus.WithMatchInterface("com.redhat.Yggdrasil1.Dispatcher1"),
+	); err != nil {
+		return fmt.Errorf("cannot add signal match on com.redhat.Yggdrasil1.Dispatcher1: %w", err)
+	}
+
+	signals := make(chan *dbus.Signal)
+	conn.Signal(signals)
+	go func() {
+		for s := range signals {
+			handle(s)
+		}
+	}()
+
+	return nil
+}
+
+// handleSignal handles a com.redhat.Yggdrasil1.Dispatcher1 signal.
+func (w *Worker) handleSignal(s *dbus.Signal) {
+	switch s.Name {
+	case "com.redhat.Yggdrasil1.Dispatcher1.Event":
+		var event uint32
+		if err := dbus.Store(s.Body, &event); err != nil {
+			log.Errorf("cannot unpack signal: %v", err)
+			return
+		}
+		// Only handlers receive a context, so the messages of other workers
+		// are not cancelled when the dispatcher disconnects.
+		if w.handler != nil && ipc.DispatcherEvent(event) == ipc.DispatcherEventReceivedDisconnect {
+			w.cancelAll(ErrDisconnected)
+		}
+		if w.eventHandler == nil {
+			return
+		}
+		w.eventHandler(ipc.DispatcherEvent(event))
+	case "com.redhat.Yggdrasil1.Dispatcher1.TransmitCompleted":
+		if w.transmitCompleted == nil {
+			return
+		}
+		var (
+			id               string
+			responseCode     int32
+			responseMetadata map[string]string
+			responseData     []byte
+		)
+		err := dbus.Store(s.Body, &id, &responseCode, &responseMetadata, &responseData)
+		if err != nil {
+			log.Errorf("cannot unpack signal: %v", err)
+			return
+		}
+		w.transmitCompleted(w, id, int(responseCode), responseMetadata, responseData)
+	}
+}
+
 // SetFeature sets the value for the given key in the feature map and emits the
 // PropertiesChanged signal.
 func (w *Worker) SetFeature(name, value string) error {
@@ -206,6 +475,52 @@ func (w *Worker) Transmit(
 	return
 }
 
+// TransmitAsync wraps a com.redhat.Yggdrasil1.Dispatcher1.TransmitAsync method
+// call for ease of use from the worker. It returns once the dispatcher has
+// accepted the message. The function set with SetTransmitCompletedHandler is
+// called when the message has been sent.
+func (w *Worker) TransmitAsync(
+	addr string,
+	id string,
+	responseTo string,
+	metadata map[string]string,
+	data []byte,
+) error {
+	// The sender is recorded before the call, so that the TransmitCompleted
+	// signal cannot arrive before it.
+	if w.host != nil {
+		w.host.addSender(id, w)
+	}
+	obj := w.conn.Object("com.redhat.Yggdrasil1.Dispatcher1", "/com/redhat/Yggdrasil1/Dispatcher1")
+	err := obj.Call(
+		"com.redhat.Yggdrasil1.Dispatcher1.TransmitAsync",
+		0,
+		addr,
+		id,
+		responseTo,
+		metadata,
+		data,
+	).Store()
+	if err != nil && w.host != nil {
+		w.host.removeSender(id)
+	}
+	return err
+}
+
+// SetStreamRxFunc sets the function called each time the worker receives
+// remote content through the DispatchFD method. Workers that set it receive
+// remote content as a stream instead of a byte array. It must be called before
+// Connect.
+func (w *Worker) SetStreamRxFunc(f StreamRxFunc) {
+	w.streamRx = f
+}
+
+// SetTransmitCompletedHandler sets the function called each time a message sent
+// with TransmitAsync has completed.
+func (w *Worker) SetTransmitCompletedHandler(f TransmitCompletedFunc) {
+	w.transmitCompleted = f
+}
+
 // EmitEvent emits a WorkerEvent, worker message id, and key-value pairs of optional data.
 func (w *Worker) EmitEvent(
 	event ipc.WorkerEventName,
@@ -263,7 +578,7 @@ func (w *Worker) cancel(addr string, id string, cancelID string) *dbus.Error {
 }
 
 // dispatch implements com.redhat.Yggdrasil1.Worker1.Dispatch by calling the
-// worker's RxFunc in a goroutine.
+// worker's RxFunc or HandlerFunc in a goroutine.
 func (w *Worker) dispatch(
 	addr string,
 	id string,
@@ -278,18 +593,84 @@ func (w *Worker) dispatch(
 	log.Tracef("metadata = %#v", metadata)
 	log.Tracef("data = %v", data)
 
-	if err := w.EmitEvent(ipc.WorkerEventNameBegin, id, responseTo, map[string]string{}); err != nil {
-		return dbus.NewError("com.redhat.Yggdrasil1.Worker1.EventError", []interface{}{err.Error()})
+	ctx, dbusErr := w.accept(id, responseTo)
+	if dbusErr != nil {
+		return dbusErr
 	}
 
-	go func() {
+	w.start(ctx, id, responseTo, func(ctx context.Context) bool {
+		if w.handler != nil {
+			if err := w.handler(ctx, w, addr, id, responseTo, metadata, data); err != nil {
+				log.Errorf("cannot call handler: %v", err)
+			}
+			// A handler that returns after its context is done has stopped
+			// because of the cancellation.
+			return ctx.Err() != nil
+		}
 		if err := w.rx(w, addr, id, responseTo, metadata, data); err != nil {
 			log.Errorf("cannot call rx: %v", err)
 		}
-		if err := w.EmitEvent(ipc.WorkerEventNameEnd, id, responseTo, map[string]string{}); err != nil {
-			log.Errorf("cannot emit event: %v", err)
+		return false
+	})
+
+	return nil
+}
+
+// dispatchFD implements com.redhat.Yggdrasil1.Worker1.DispatchFD by calling the
+// worker's StreamRxFunc in a goroutine with a reader for the file descriptor.
+func (w *Worker) dispatchFD(
+	addr string,
+	id string,
+	responseTo string,
+	metadata map[string]string,
+	content dbus.UnixFD,
+) *dbus.Error {
+	log.Tracef("addr = %v", addr)
+	log.Tracef("id = %v", id)
+	log.Tracef("responseTo = %v", responseTo)
+	log.Tracef("metadata = %#v", metadata)
+	log.Tracef("content = %v", content)
+
+	f := os.NewFile(uintptr(content), "content")
+	if f == nil {
+		return dbus.MakeFailedError(fmt.Errorf("invalid file descriptor: %v", content))
+	}
+
+	if w.streamRx == nil {
+		if err := f.Close(); err != nil {
+			log.Errorf("cannot close file descriptor: %v", err)
 		}
-	}()
+		return dbus.NewError(
+			"org.freedesktop.DBus.Error.UnknownMethod",
+			[]interface{}{"DispatchFD method not implemented"},
+		)
+	}
+
+	r, err := newContentReader(f, metadata)
+	if err != nil {
+		if err := f.Close(); err != nil {
+			log.Errorf("cannot close file descriptor: %v", err)
+		}
+		return dbus.MakeFailedError(err)
+	}
+
+	ctx, dbusErr := w.accept(id, responseTo)
+	if dbusErr != nil {
+		if err := f.Close(); err != nil {
+			log.Errorf("cannot close file descriptor: %v", err)
+		}
+		return dbusErr
+	}
+
+	w.start(ctx, id, responseTo, func(ctx context.Context) bool {
+		if err := w.streamRx(w, addr, id, responseTo, metadata, r); err != nil {
+			log.Errorf("cannot call streamRx: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Errorf("cannot close file descriptor: %v", err)
+		}
+		return false
+	})
 
 	return nil
 }
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/redhatinsights/yggdrasil/ipc"
+)
+
+func TestHandleSignal(t *testing.T) {
+	tests := []struct {
+		description string
+		input       *dbus.Signal
+		wantEvent   bool
+		wantCause   error
+	}{
+		{
+			description: "received disconnect",
+			input: &dbus.Signal{
+				Name: "com.redhat.Yggdrasil1.Dispatcher1.Event",
+				Body: []interface{}{uint32(ipc.DispatcherEventReceivedDisconnect)},
+			},
+			wantEvent: true,
+			wantCause: ErrDisconnected,
+		},
+		{
+			description: "other event",
+			input: &dbus.Signal{
+				Name: "com.redhat.Yggdrasil1.Dispatcher1.Event",
+				Body: []interface{}{uint32(ipc.DispatcherEventConnectionRestored)},
+			},
+			wantEvent: true,
+		},
+		{
+			description: "invalid body",
+			input: &dbus.Signal{
+				Name: "com.redhat.Yggdrasil1.Dispatcher1.Event",
+				Body: []interface{}{"disconnect"},
+			},
+		},
+		{
+			description: "empty body",
+			input: &dbus.Signal{
+				Name: "com.redhat.Yggdrasil1.Dispatcher1.Event",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var gotEvent bool
+			w, err := NewWorkerWithHandler(
+				"test",
+				false,
+				map[string]string{},
+				func(
+					ctx context.Context,
+					w *Worker,
+					addr string,
+					id string,
+					responseTo string,
+					metadata map[string]string,
+					data []byte,
+				) error {
+					return nil
+				},
+				func(e ipc.DispatcherEvent) {
+					gotEvent = true
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancelCause(context.Background())
+			w.inFlight["1234"] = cancel
+
+			w.handleSignal(test.input)
+
+			if gotEvent != test.wantEvent {
+				t.Errorf("event handler called: %v != %v", gotEvent, test.wantEvent)
+			}
+			if got := context.Cause(ctx); !errors.Is(got, test.wantCause) {
+				t.Errorf("%v != %v", got, test.wantCause)
+			}
+		})
+	}
+}
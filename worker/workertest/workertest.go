@@ -0,0 +1,558 @@
+// Package workertest provides a private D-Bus message bus and a fake
+// com.redhat.Yggdrasil1.Dispatcher1 for testing workers written with package
+// worker, without a running yggd or system bus.
+//
+// A test creates a Bus, starts its worker on it, dispatches messages to the
+// worker and asserts the events it emits and the data it transmits:
+//
+//	bus := workertest.NewBus(t)
+//	bus.Start(w)
+//	if err := bus.Dispatch("echo", "1234", "", nil, []byte("hello")); err != nil {
+//		t.Fatal(err)
+//	}
+//	if _, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", time.Second); err != nil {
+//		t.Fatal(err)
+//	}
+//
+// The Bus runs a dbus-daemon process, which must be found in the PATH. Tests
+// are skipped if it is not.
+package workertest
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/redhatinsights/yggdrasil/ipc"
+	"github.com/redhatinsights/yggdrasil/worker"
+)
+
+// busConfig is the configuration of the private dbus-daemon. The %v verb is
+// replaced with the directory its socket is created in.
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%v</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// Event is a com.redhat.Yggdrasil1.Worker1.Event signal emitted by a worker.
+type Event struct {
+	Worker     string
+	Name       ipc.WorkerEventName
+	MessageID  string
+	ResponseTo string
+	Data       map[string]string
+}
+
+// Transmission is a call of the Transmit or TransmitAsync method of the fake
+// dispatcher by a worker.
+type Transmission struct {
+	Addr       string
+	MessageID  string
+	ResponseTo string
+	Metadata   map[string]string
+	Data       []byte
+	Async      bool
+}
+
+// Response is the response of the fake dispatcher to a Transmission.
+type Response struct {
+	Code     int
+	Metadata map[string]string
+	Data     []byte
+}
+
+// TransmitFunc returns the response of the fake dispatcher to a
+// Transmission.
+type TransmitFunc func(t Transmission) Response
+
+// Bus is a private D-Bus message bus on which a fake
+// com.redhat.Yggdrasil1.Dispatcher1 is exported. It records the events emitted
+// by the workers on the bus and the data they transmit.
+type Bus struct {
+	t       testing.TB
+	address string
+	conn    *dbus.Conn
+
+	mu            sync.Mutex
+	changed       chan struct{}
+	events        []Event
+	transmissions []Transmission
+	transmit      TransmitFunc
+}
+
+// StartDaemon starts a private bus, returning its address. The bus is stopped
+// when the test finishes. The test is skipped if dbus-daemon is not installed.
+func StartDaemon(t testing.TB) string {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skipf("cannot find dbus-daemon: %v", err)
+	}
+
+	// The socket is not created in t.TempDir, whose path can be longer than
+	// a unix socket path allows.
+	dir, err := os.MkdirTemp("", "workertest")
+	if err != nil {
+		t.Fatalf("cannot create directory: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("cannot remove directory: %v", err)
+		}
+	})
+
+	config := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(config, []byte(fmt.Sprintf(busConfig, dir)), 0600); err != nil {
+		t.Fatalf("cannot write bus configuration: %v", err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("cannot get standard output of dbus-daemon: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("cannot start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := cmd.Process.Kill(); err != nil {
+			t.Errorf("cannot stop dbus-daemon: %v", err)
+		}
+		_ = cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("cannot read address of dbus-daemon: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// NewBus starts a private bus with StartDaemon and exports the fake dispatcher
+// on it. The DBUS_SESSION_BUS_ADDRESS environment variable is set to its
+// address for the duration of the test, so that workers connect to it.
+func NewBus(t testing.TB) *Bus {
+	t.Helper()
+
+	address := StartDaemon(t)
+
+	b := &Bus{
+		t:       t,
+		address: address,
+		changed: make(chan struct{}),
+		transmit: func(Transmission) Response {
+			return Response{}
+		},
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", b.address)
+
+	var err error
+	b.conn, err = dbus.Connect(b.address)
+	if err != nil {
+		t.Fatalf("cannot connect to bus: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := b.conn.Close(); err != nil {
+			t.Errorf("cannot close connection: %v", err)
+		}
+	})
+
+	methods := map[string]interface{}{
+		"Transmit":      b.transmitSync,
+		"TransmitAsync": b.transmitAsync,
+	}
+	err = b.conn.ExportMethodTable(
+		methods,
+		"/com/redhat/Yggdrasil1/Dispatcher1",
+		"com.redhat.Yggdrasil1.Dispatcher1",
+	)
+	if err != nil {
+		t.Fatalf("cannot export com.redhat.Yggdrasil1.Dispatcher1 interface: %v", err)
+	}
+	reply, err := b.conn.RequestName("com.redhat.Yggdrasil1.Dispatcher1", dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("cannot request name com.redhat.Yggdrasil1.Dispatcher1: %v", err)
+	}
+
+	if err := b.conn.AddMatchSignal(
+		dbus.WithMatchInterface("com.redhat.Yggdrasil1.Worker1"),
+		dbus.WithMatchMember("Event"),
+	); err != nil {
+		t.Fatalf("cannot add signal match on com.redhat.Yggdrasil1.Worker1: %v", err)
+	}
+	signals := make(chan *dbus.Signal)
+	b.conn.Signal(signals)
+	go func() {
+		for s := range signals {
+			b.recordEvent(s)
+		}
+	}()
+
+	return b
+}
+
+// Address returns the address of the bus.
+func (b *Bus) Address() string {
+	return b.address
+}
+
+// Start connects w to the bus in a goroutine and waits until it has emitted
+// the STARTED event. The worker is stopped when the test finishes.
+func (b *Bus) Start(w *worker.Worker) {
+	b.t.Helper()
+
+	b.mu.Lock()
+	started := 0
+	for _, e := range b.events {
+		if e.Name == ipc.WorkerEventNameStarted {
+			started++
+		}
+	}
+	b.mu.Unlock()
+
+	b.connect(w.Connect)
+
+	err := b.wait(5*time.Second, func(events []Event) bool {
+		n := 0
+		for _, e := range events {
+			if e.Name == ipc.WorkerEventNameStarted {
+				n++
+			}
+		}
+		return n > started
+	})
+	if err != nil {
+		b.t.Fatalf("worker did not start: %v", err)
+	}
+}
+
+// StartHost connects h to the bus in a goroutine and waits until each of its
+// workers has emitted the STARTED event. The workers are stopped when the test
+// finishes.
+func (b *Bus) StartHost(h *worker.Host) {
+	b.t.Helper()
+
+	b.connect(h.Connect)
+
+	err := b.wait(5*time.Second, func(events []Event) bool {
+		started := make(map[string]bool)
+		for _, e := range events {
+			if e.Name == ipc.WorkerEventNameStarted {
+				started[e.Worker] = true
+			}
+		}
+		for _, directive := range h.Directives() {
+			if !started[directive] {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		b.t.Fatalf("workers did not start: %v", err)
+	}
+}
+
+// connect calls connect in a goroutine, sending a signal on its quit channel
+// and waiting for it to return when the test finishes.
+func (b *Bus) connect(connect func(quit <-chan os.Signal) error) {
+	quit := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := connect(quit); err != nil {
+			b.t.Errorf("cannot connect: %v", err)
+		}
+	}()
+	b.t.Cleanup(func() {
+		quit <- syscall.SIGTERM
+		<-done
+	})
+}
+
+// Dispatch calls the com.redhat.Yggdrasil1.Worker1.Dispatch method of the
+// worker for directive.
+func (b *Bus) Dispatch(
+	directive string,
+	id string,
+	responseTo string,
+	metadata map[string]string,
+	data []byte,
+) error {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return b.worker(directive).Call(
+		"com.redhat.Yggdrasil1.Worker1.Dispatch",
+		0,
+		directive,
+		id,
+		responseTo,
+		metadata,
+		data,
+	).Store()
+}
+
+// DispatchFD calls the com.redhat.Yggdrasil1.Worker1.DispatchFD method of the
+// worker for directive, passing the file descriptor of content.
+func (b *Bus) DispatchFD(
+	directive string,
+	id string,
+	responseTo string,
+	metadata map[string]string,
+	content *os.File,
+) error {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return b.worker(directive).Call(
+		"com.redhat.Yggdrasil1.Worker1.DispatchFD",
+		0,
+		directive,
+		id,
+		responseTo,
+		metadata,
+		dbus.UnixFD(content.Fd()),
+	).Store()
+}
+
+// Cancel calls the com.redhat.Yggdrasil1.Worker1.Cancel method of the worker
+// for directive.
+func (b *Bus) Cancel(directive string, id string, cancelID string) error {
+	return b.worker(directive).Call(
+		"com.redhat.Yggdrasil1.Worker1.Cancel",
+		0,
+		directive,
+		id,
+		cancelID,
+	).Store()
+}
+
+// Property returns the value of a com.redhat.Yggdrasil1.Worker1 property of
+// the worker for directive.
+func (b *Bus) Property(directive string, name string) (interface{}, error) {
+	v, err := b.worker(directive).GetProperty("com.redhat.Yggdrasil1.Worker1." + name)
+	if err != nil {
+		return nil, err
+	}
+	return v.Value(), nil
+}
+
+// EmitDispatcherEvent emits the com.redhat.Yggdrasil1.Dispatcher1.Event signal.
+func (b *Bus) EmitDispatcherEvent(event ipc.DispatcherEvent) error {
+	return b.conn.Emit(
+		"/com/redhat/Yggdrasil1/Dispatcher1",
+		"com.redhat.Yggdrasil1.Dispatcher1.Event",
+		event,
+	)
+}
+
+// SetTransmitFunc sets the function that returns the response of the fake
+// dispatcher to the data transmitted by workers. By default, every
+// transmission succeeds with an empty response.
+func (b *Bus) SetTransmitFunc(f TransmitFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.transmit = f
+}
+
+// Events returns the events emitted by the workers on the bus so far.
+func (b *Bus) Events() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Event{}, b.events...)
+}
+
+// Transmissions returns the data transmitted by the workers on the bus so far.
+func (b *Bus) Transmissions() []Transmission {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Transmission{}, b.transmissions...)
+}
+
+// WaitEvent waits until a worker has emitted an event with name for the
+// message with id, returning the first such event.
+func (b *Bus) WaitEvent(name ipc.WorkerEventName, id string, timeout time.Duration) (Event, error) {
+	var event Event
+	err := b.wait(timeout, func(events []Event) bool {
+		for _, e := range events {
+			if e.Name == name && e.MessageID == id {
+				event = e
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return event, fmt.Errorf("no %v event for message %v: %w", name, id, err)
+	}
+	return event, nil
+}
+
+// WaitTransmissions waits until the workers on the bus have transmitted data
+// n times, returning the transmissions.
+func (b *Bus) WaitTransmissions(n int, timeout time.Duration) ([]Transmission, error) {
+	err := b.wait(timeout, func([]Event) bool {
+		return len(b.transmissions) >= n
+	})
+	if err != nil {
+		return b.Transmissions(), fmt.Errorf("fewer than %v transmissions: %w", n, err)
+	}
+	return b.Transmissions(), nil
+}
+
+// wait waits until done returns true. Done is called with b.mu held, each time
+// an event or a transmission is recorded.
+func (b *Bus) wait(timeout time.Duration, done func(events []Event) bool) error {
+	deadline := time.After(timeout)
+	for {
+		b.mu.Lock()
+		if done(b.events) {
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return fmt.Errorf("timed out after %v", timeout)
+		}
+	}
+}
+
+// notify wakes up the callers of wait. It must be called with b.mu held.
+func (b *Bus) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// worker returns the object of the worker for directive.
+func (b *Bus) worker(directive string) dbus.BusObject {
+	return b.conn.Object(
+		"com.redhat.Yggdrasil1.Worker1."+directive,
+		dbus.ObjectPath(path.Join("/com/redhat/Yggdrasil1/Worker1", directive)),
+	)
+}
+
+// recordEvent records a com.redhat.Yggdrasil1.Worker1.Event signal.
+func (b *Bus) recordEvent(s *dbus.Signal) {
+	if s.Name != "com.redhat.Yggdrasil1.Worker1.Event" {
+		return
+	}
+	var (
+		name uint32
+		e    Event
+	)
+	if err := dbus.Store(s.Body, &name, &e.MessageID, &e.ResponseTo, &e.Data); err != nil {
+		b.t.Errorf("cannot unpack signal: %v", err)
+		return
+	}
+	e.Name = ipc.WorkerEventName(name)
+	e.Worker = path.Base(string(s.Path))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, e)
+	b.notify()
+}
+
+// record records a transmission, returning the response to it.
+func (b *Bus) record(t Transmission) Response {
+	b.mu.Lock()
+	b.transmissions = append(b.transmissions, t)
+	b.notify()
+	transmit := b.transmit
+	b.mu.Unlock()
+
+	return transmit(t)
+}
+
+// transmitSync implements the com.redhat.Yggdrasil1.Dispatcher1.Transmit
+// method of the fake dispatcher.
+func (b *Bus) transmitSync(
+	addr string,
+	id string,
+	responseTo string,
+	metadata map[string]string,
+	data []byte,
+) (int32, map[string]string, []byte, *dbus.Error) {
+	resp := b.record(Transmission{
+		Addr:       addr,
+		MessageID:  id,
+		ResponseTo: responseTo,
+		Metadata:   metadata,
+		Data:       data,
+	})
+	return int32(resp.Code), responseMetadata(resp), resp.Data, nil
+}
+
+// transmitAsync implements the com.redhat.Yggdrasil1.Dispatcher1.TransmitAsync
+// method of the fake dispatcher, sending the TransmitCompleted signal with the
+// response to the caller once the method has returned.
+func (b *Bus) transmitAsync(
+	sender dbus.Sender,
+	addr string,
+	id string,
+	responseTo string,
+	metadata map[string]string,
+	data []byte,
+) *dbus.Error {
+	resp := b.record(Transmission{
+		Addr:       addr,
+		MessageID:  id,
+		ResponseTo: responseTo,
+		Metadata:   metadata,
+		Data:       data,
+		Async:      true,
+	})
+	go func() {
+		body := []interface{}{id, int32(resp.Code), responseMetadata(resp), resp.Data}
+		msg := &dbus.Message{
+			Type: dbus.TypeSignal,
+			Headers: map[dbus.HeaderField]dbus.Variant{
+				dbus.FieldDestination: dbus.MakeVariant(string(sender)),
+				dbus.FieldPath: dbus.MakeVariant(
+					dbus.ObjectPath("/com/redhat/Yggdrasil1/Dispatcher1"),
+				),
+				dbus.FieldInterface: dbus.MakeVariant("com.redhat.Yggdrasil1.Dispatcher1"),
+				dbus.FieldMember:    dbus.MakeVariant("TransmitCompleted"),
+				dbus.FieldSignature: dbus.MakeVariant(dbus.SignatureOf(body...)),
+			},
+			Body: body,
+		}
+		if err := b.conn.Send(msg, nil).Err; err != nil {
+			b.t.Errorf("cannot send signal: %v", err)
+		}
+	}()
+	return nil
+}
+
+// responseMetadata returns the metadata of resp, which is never nil so that it
+// can be sent on the bus.
+func responseMetadata(resp Response) map[string]string {
+	if resp.Metadata == nil {
+		return map[string]string{}
+	}
+	return resp.Metadata
+}
@@ -0,0 +1,907 @@
+package workertest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/google/go-cmp/cmp"
+	"github.com/redhatinsights/yggdrasil/ipc"
+	"github.com/redhatinsights/yggdrasil/worker"
+)
+
+const timeout = 5 * time.Second
+
+// block waits until its context is cancelled.
+func block(
+	ctx context.Context,
+	w *worker.Worker,
+	addr string,
+	id string,
+	responseTo string,
+	metadata map[string]string,
+	data []byte,
+) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestTransmit(t *testing.T) {
+	bus := NewBus(t)
+	bus.SetTransmitFunc(func(Transmission) Response {
+		return Response{Code: 202}
+	})
+
+	codes := make(chan int, 1)
+	w, err := worker.NewWorkerWithHandler(
+		"test",
+		false,
+		map[string]string{},
+		func(
+			ctx context.Context,
+			w *worker.Worker,
+			addr string,
+			id string,
+			responseTo string,
+			metadata map[string]string,
+			data []byte,
+		) error {
+			code, _, _, err := w.Transmit(addr, "reply-"+id, id, metadata, data)
+			codes <- code
+			return err
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Start(w)
+
+	err = bus.Dispatch("test", "1234", "", map[string]string{"a": "b"}, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Data) != 0 {
+		t.Errorf("unexpected event data: %v", event.Data)
+	}
+	if got := <-codes; got != 202 {
+		t.Errorf("%v != %v", got, 202)
+	}
+
+	want := []Transmission{
+		{
+			Addr:       "test",
+			MessageID:  "reply-1234",
+			ResponseTo: "1234",
+			Metadata:   map[string]string{"a": "b"},
+			Data:       []byte("hello"),
+		},
+	}
+	if !cmp.Equal(bus.Transmissions(), want) {
+		t.Errorf("%v", cmp.Diff(bus.Transmissions(), want))
+	}
+}
+
+func TestCancel(t *testing.T) {
+	tests := []struct {
+		description string
+		cancel      func(bus *Bus) error
+		want        error
+	}{
+		{
+			description: "cancel method",
+			cancel: func(bus *Bus) error {
+				return bus.Cancel("test", "5678", "1234")
+			},
+			want: worker.ErrCancelled,
+		},
+		{
+			description: "disconnect",
+			cancel: func(bus *Bus) error {
+				return bus.EmitDispatcherEvent(ipc.DispatcherEventReceivedDisconnect)
+			},
+			want: worker.ErrDisconnected,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			bus := NewBus(t)
+			w, err := worker.NewWorkerWithHandler("test", false, map[string]string{}, block, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bus.Start(w)
+
+			if err := bus.Dispatch("test", "1234", "", nil, []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := bus.WaitEvent(ipc.WorkerEventNameBegin, "1234", timeout); err != nil {
+				t.Fatal(err)
+			}
+			if err := test.cancel(bus); err != nil {
+				t.Fatal(err)
+			}
+
+			event, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{
+				worker.EventDataKeyStatus: worker.StatusCancelled,
+				worker.EventDataKeyReason: test.want.Error(),
+			}
+			if !cmp.Equal(event.Data, want) {
+				t.Errorf("%v", cmp.Diff(event.Data, want))
+			}
+		})
+	}
+}
+
+func TestCancelStop(t *testing.T) {
+	bus := NewBus(t)
+	w, err := worker.NewWorkerWithHandler(
+		"test",
+		false,
+		map[string]string{},
+		block,
+		nil,
+		worker.WithDrainTimeout(0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The worker is connected directly, so that the test can stop it.
+	quit := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Connect(quit)
+	}()
+	if _, err := bus.WaitEvent(ipc.WorkerEventNameStarted, "", timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bus.Dispatch("test", "1234", "", nil, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.WaitEvent(ipc.WorkerEventNameBegin, "1234", timeout); err != nil {
+		t.Fatal(err)
+	}
+	quit <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(timeout):
+		t.Fatal("worker did not stop")
+	}
+
+	event, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		worker.EventDataKeyStatus: worker.StatusCancelled,
+		worker.EventDataKeyReason: worker.ErrStopped.Error(),
+	}
+	if !cmp.Equal(event.Data, want) {
+		t.Errorf("%v", cmp.Diff(event.Data, want))
+	}
+	if _, err := bus.WaitEvent(ipc.WorkerEventNameStopped, "", timeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	bus := NewBus(t)
+	w, err := worker.NewWorkerWithHandler(
+		"test",
+		false,
+		map[string]string{},
+		block,
+		nil,
+		worker.WithMaxConcurrency(1),
+		worker.WithQueueDepth(1),
+		worker.WithDrainTimeout(0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Start(w)
+
+	for _, id := range []string{"1", "2"} {
+		if err := bus.Dispatch("test", id, "", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var dbusErr dbus.Error
+	err = bus.Dispatch("test", "3", "", nil, nil)
+	if !errors.As(err, &dbusErr) || dbusErr.Name != "com.redhat.Yggdrasil1.Worker1.Busy" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]uint32{"Running": 1, "Queued": 1} {
+		got, err := bus.Property("test", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%v: %v != %v", name, got, want)
+		}
+	}
+}
+
+// gate returns a handler that sends the ID of each message it handles on
+// started and returns once a value is received on release or its context is
+// done.
+func gate(started chan<- string, release <-chan struct{}) worker.HandlerFunc {
+	return func(
+		ctx context.Context,
+		w *worker.Worker,
+		addr string,
+		id string,
+		responseTo string,
+		metadata map[string]string,
+		data []byte,
+	) error {
+		started <- id
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+}
+
+func TestQueue(t *testing.T) {
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	bus := NewBus(t)
+	w, err := worker.NewWorkerWithHandler(
+		"test",
+		false,
+		map[string]string{},
+		gate(started, release),
+		nil,
+		worker.WithMaxConcurrency(1),
+		worker.WithQueueDepth(1),
+		worker.WithDrainTimeout(0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Start(w)
+
+	for _, id := range []string{"1", "2"} {
+		if err := bus.Dispatch("test", id, "", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := <-started; got != "1" {
+		t.Fatalf("%v != %v", got, "1")
+	}
+	select {
+	case id := <-started:
+		t.Fatalf("message %v started while another was running", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	if _, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1", timeout); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-started:
+		if got != "2" {
+			t.Fatalf("%v != %v", got, "2")
+		}
+	case <-time.After(timeout):
+		t.Fatal("queued message did not start")
+	}
+	release <- struct{}{}
+
+	event, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "2", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Data) != 0 {
+		t.Errorf("unexpected event data: %v", event.Data)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	started := make(chan string, 1)
+	release := make(chan struct{})
+	bus := NewBus(t)
+	w, err := worker.NewWorkerWithHandler(
+		"test",
+		false,
+		map[string]string{},
+		gate(started, release),
+		nil,
+		worker.WithDrainTimeout(timeout),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quit := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Connect(quit)
+	}()
+	if _, err := bus.WaitEvent(ipc.WorkerEventNameStarted, "", timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bus.Dispatch("test", "1", "", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	quit <- syscall.SIGTERM
+
+	// Messages received while the worker drains are rejected.
+	deadline := time.Now().Add(timeout)
+	for {
+		var dbusErr dbus.Error
+		err := bus.Dispatch("test", "2", "", nil, nil)
+		if errors.As(err, &dbusErr) && dbusErr.Name == "com.redhat.Yggdrasil1.Worker1.Busy" {
+			break
+		}
+		if err == nil {
+			<-started
+			t.Fatal("message accepted while draining")
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	release <- struct{}{}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(timeout):
+		t.Fatal("worker did not stop")
+	}
+
+	event, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Data) != 0 {
+		t.Errorf("unexpected event data: %v", event.Data)
+	}
+}
+
+func TestDisconnectRxFunc(t *testing.T) {
+	started := make(chan string, 1)
+	release := make(chan struct{})
+	disconnected := make(chan struct{}, 1)
+	bus := NewBus(t)
+	w, err := worker.NewWorker(
+		"test",
+		false,
+		map[string]string{},
+		nil,
+		func(
+			w *worker.Worker,
+			addr string,
+			id string,
+			responseTo string,
+			metadata map[string]string,
+			data []byte,
+		) error {
+			started <- id
+			<-release
+			return nil
+		},
+		func(e ipc.DispatcherEvent) {
+			if e == ipc.DispatcherEventReceivedDisconnect {
+				disconnected <- struct{}{}
+			}
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Start(w)
+
+	if err := bus.Dispatch("test", "1234", "", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := bus.EmitDispatcherEvent(ipc.DispatcherEventReceivedDisconnect); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(timeout):
+		t.Fatal("worker did not receive the event")
+	}
+	release <- struct{}{}
+
+	// The message ran to completion, so it is not reported as cancelled.
+	event, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Data) != 0 {
+		t.Errorf("unexpected event data: %v", event.Data)
+	}
+}
+
+func TestTransmitAsync(t *testing.T) {
+	bus := NewBus(t)
+	bus.SetTransmitFunc(func(Transmission) Response {
+		return Response{Code: 200, Metadata: map[string]string{"x": "y"}}
+	})
+
+	completed := make(chan int, 1)
+	w, err := worker.NewWorkerWithHandler(
+		"test",
+		false,
+		map[string]string{},
+		func(
+			ctx context.Context,
+			w *worker.Worker,
+			addr string,
+			id string,
+			responseTo string,
+			metadata map[string]string,
+			data []byte,
+		) error {
+			return w.TransmitAsync(addr, "reply-"+id, id, metadata, data)
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetTransmitCompletedHandler(func(
+		w *worker.Worker,
+		id string,
+		responseCode int,
+		responseMetadata map[string]string,
+		responseData []byte,
+	) {
+		completed <- responseCode
+	})
+	bus.Start(w)
+
+	if err := bus.Dispatch("test", "1234", "", nil, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-completed:
+		if got != 200 {
+			t.Errorf("%v != %v", got, 200)
+		}
+	case <-time.After(timeout):
+		t.Fatal("TransmitCompleted was not received")
+	}
+
+	got, err := bus.WaitTransmissions(1, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got[0].Async {
+		t.Errorf("transmission is not asynchronous")
+	}
+}
+
+func TestHost(t *testing.T) {
+	bus := NewBus(t)
+
+	var workers []*worker.Worker
+	for _, directive := range []string{"one", "two"} {
+		w, err := worker.NewWorker(
+			directive,
+			directive == "two",
+			map[string]string{"name": directive},
+			nil,
+			func(
+				w *worker.Worker,
+				addr string,
+				id string,
+				responseTo string,
+				metadata map[string]string,
+				data []byte,
+			) error {
+				_, _, _, err := w.Transmit(addr, "reply-"+id, id, metadata, data)
+				return err
+			},
+			nil,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		workers = append(workers, w)
+	}
+	host, err := worker.NewHost(workers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.StartHost(host)
+
+	for _, directive := range []string{"one", "two"} {
+		if err := bus.Dispatch(directive, directive, "", nil, []byte(directive)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := bus.Property(directive, "RemoteContent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != (directive == "two") {
+			t.Errorf("%v: RemoteContent = %v", directive, got)
+		}
+	}
+
+	got, err := bus.WaitTransmissions(2, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := map[string]string{}
+	for _, tr := range got {
+		addrs[tr.Addr] = string(tr.Data)
+	}
+	if want := map[string]string{"one": "one", "two": "two"}; !cmp.Equal(addrs, want) {
+		t.Errorf("%v", cmp.Diff(addrs, want))
+	}
+}
+
+func TestHostTransmitAsync(t *testing.T) {
+	bus := NewBus(t)
+
+	completed := make(chan string, 2)
+	var workers []*worker.Worker
+	for _, directive := range []string{"one", "two"} {
+		directive := directive
+		w, err := worker.NewWorkerWithHandler(
+			directive,
+			false,
+			map[string]string{},
+			func(
+				ctx context.Context,
+				w *worker.Worker,
+				addr string,
+				id string,
+				responseTo string,
+				metadata map[string]string,
+				data []byte,
+			) error {
+				return w.TransmitAsync(addr, "reply-"+id, id, metadata, data)
+			},
+			nil,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetTransmitCompletedHandler(func(
+			w *worker.Worker,
+			id string,
+			responseCode int,
+			responseMetadata map[string]string,
+			responseData []byte,
+		) {
+			completed <- directive + ":" + id
+		})
+		workers = append(workers, w)
+	}
+	host, err := worker.NewHost(workers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.StartHost(host)
+
+	// Only the worker that sent the message receives its TransmitCompleted
+	// signal, although both share a connection.
+	if err := bus.Dispatch("one", "1234", "", nil, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-completed:
+		if want := "one:reply-1234"; got != want {
+			t.Errorf("%v != %v", got, want)
+		}
+	case <-time.After(timeout):
+		t.Fatal("TransmitCompleted was not received")
+	}
+	select {
+	case got := <-completed:
+		t.Errorf("unexpected TransmitCompleted: %v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestHostExportFailure(t *testing.T) {
+	bus := NewBus(t)
+
+	// The name of the second worker is taken, so it cannot be exported.
+	reply, err := bus.conn.RequestName("com.redhat.Yggdrasil1.Worker1.two", dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("cannot request name: %v", err)
+	}
+
+	var workers []*worker.Worker
+	for _, directive := range []string{"one", "two"} {
+		w, err := worker.NewWorkerWithHandler(directive, false, map[string]string{}, block, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		workers = append(workers, w)
+	}
+	host, err := worker.NewHost(workers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := host.Connect(make(chan os.Signal)); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// The first worker is stopped and its name is released.
+	err = bus.wait(timeout, func(events []Event) bool {
+		for _, e := range events {
+			if e.Worker == "one" && e.Name == ipc.WorkerEventNameStopped {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var has bool
+	err = bus.conn.BusObject().Call(
+		"org.freedesktop.DBus.NameHasOwner",
+		0,
+		"com.redhat.Yggdrasil1.Worker1.one",
+	).Store(&has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Error("name com.redhat.Yggdrasil1.Worker1.one is still owned")
+	}
+}
+
+func TestNewHostDuplicate(t *testing.T) {
+	var workers []*worker.Worker
+	for i := 0; i < 2; i++ {
+		w, err := worker.NewWorkerWithHandler("test", false, map[string]string{}, block, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		workers = append(workers, w)
+	}
+	if _, err := worker.NewHost(workers...); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestDispatchFD(t *testing.T) {
+	tests := []struct {
+		description string
+		metadata    map[string]string
+		content     string
+		want        string
+		wantErr     bool
+	}{
+		{
+			description: "without length",
+			content:     "hello",
+			want:        "hello",
+		},
+		{
+			description: "complete",
+			metadata:    map[string]string{"content-length": "5"},
+			content:     "hello",
+			want:        "hello",
+		},
+		{
+			description: "too long",
+			metadata:    map[string]string{"content-length": "4"},
+			content:     "hello",
+			want:        "hello",
+			wantErr:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			type result struct {
+				data []byte
+				err  error
+			}
+			results := make(chan result, 1)
+
+			bus := NewBus(t)
+			w, err := worker.NewWorker("test", true, map[string]string{}, nil, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.SetStreamRxFunc(func(
+				w *worker.Worker,
+				addr string,
+				id string,
+				responseTo string,
+				metadata map[string]string,
+				content io.Reader,
+			) error {
+				data, err := io.ReadAll(content)
+				results <- result{data, err}
+				return err
+			})
+			bus.Start(w)
+
+			stream, err := bus.Property("test", "StreamContent")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stream != true {
+				t.Errorf("StreamContent is %v", stream)
+			}
+
+			r, pw, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := pw.WriteString(test.content); err != nil {
+				t.Fatal(err)
+			}
+			if err := pw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			err = bus.DispatchFD("test", "1234", "", test.metadata, r)
+			if err := r.Close(); err != nil {
+				t.Error(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got result
+			select {
+			case got = <-results:
+			case <-time.After(timeout):
+				t.Fatal("content was not received")
+			}
+			if string(got.data) != test.want {
+				t.Errorf("%q != %q", got.data, test.want)
+			}
+			if test.wantErr {
+				if got.err == nil {
+					t.Error("expected error, got nil")
+				}
+			} else if got.err != nil {
+				t.Errorf("unexpected error: %v", got.err)
+			}
+			if _, err := bus.WaitEvent(ipc.WorkerEventNameEnd, "1234", timeout); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDispatchFDTruncated(t *testing.T) {
+	errs := make(chan error, 1)
+	bus := NewBus(t)
+	w, err := worker.NewWorker("test", true, map[string]string{}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetStreamRxFunc(func(
+		w *worker.Worker,
+		addr string,
+		id string,
+		responseTo string,
+		metadata map[string]string,
+		content io.Reader,
+	) error {
+		_, err := io.ReadAll(content)
+		errs <- err
+		return err
+	})
+	bus.Start(w)
+
+	r, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err = bus.DispatchFD("test", "1234", "", map[string]string{"content-length": "1"}, r)
+	if err := r.Close(); err != nil {
+		t.Error(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%v != %v", err, io.ErrUnexpectedEOF)
+		}
+	case <-time.After(timeout):
+		t.Fatal("content was not received")
+	}
+}
+
+func TestDispatchFDInvalidLength(t *testing.T) {
+	bus := NewBus(t)
+	w, err := worker.NewWorker("test", true, map[string]string{}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetStreamRxFunc(func(
+		w *worker.Worker,
+		addr string,
+		id string,
+		responseTo string,
+		metadata map[string]string,
+		content io.Reader,
+	) error {
+		t.Error("unexpected call of StreamRxFunc")
+		return nil
+	})
+	bus.Start(w)
+
+	r, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer pw.Close()
+
+	err = bus.DispatchFD("test", "1234", "", map[string]string{"content-length": "x"}, r)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestDispatchFDNotImplemented(t *testing.T) {
+	bus := NewBus(t)
+	w, err := worker.NewWorker("test", true, map[string]string{}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Start(w)
+
+	stream, err := bus.Property("test", "StreamContent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream != false {
+		t.Errorf("StreamContent is %v", stream)
+	}
+
+	r, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer pw.Close()
+
+	var dbusErr dbus.Error
+	err = bus.DispatchFD("test", "1234", "", nil, r)
+	if !errors.As(err, &dbusErr) || dbusErr.Name != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}